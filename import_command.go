@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/interfaces/telegram"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/interfaces/telegram_export"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/outbox"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
+
+	"go.etcd.io/bbolt"
+)
+
+// runImport replays Telegram Desktop chat export into the local store of the
+// source and writes it to its sheet, the service must be stopped.
+func runImport(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "result.json", "Telegram Desktop chat export in JSON format")
+	source_name := flags.String("source", cfg.TelegramSources[0].Name, "name of the source the chat belongs to")
+	dry_run := flags.Bool("dry-run", false, "print what would be created or updated without writing")
+	flags.Parse(args)
+
+	var source *config.TelegramSource
+	for i := range cfg.TelegramSources {
+		if cfg.TelegramSources[i].Name == *source_name {
+			source = &cfg.TelegramSources[i]
+		}
+	}
+	if source == nil {
+		log.Fatalf("Error import: unknown source '%s'", *source_name)
+	}
+
+	export, err := telegram_export.Read(*file)
+	if err != nil {
+		log.Fatalf("Error import: %s", err)
+	}
+
+	messages := export.TextMessages()
+
+	log.Printf("read %d messages of chat '%s' from export", len(messages), export.Name)
+
+	texts := make([]ingest.ReplayMessage, 0, len(messages))
+	for _, message := range messages {
+		texts = append(texts, ingest.ReplayMessage{Text: string(message.Text), SentAt: message.Time()})
+	}
+
+	if *dry_run {
+		ingest_service, err := ingest.New(cfg, *source, nil, nil)
+		if err != nil {
+			log.Fatalf("Error init ingest service: %s", err)
+		}
+
+		for _, item := range ingest_service.PlanReplay(texts) {
+			fmt.Println(item.Action, describeProblem(item.Problem))
+		}
+
+		log.Printf("not parsed messages: %s", ingest_service.Stats())
+		return
+	}
+
+	boltdb, err := telegram.OpenStateDB(cfg, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Fatalf("Error open state database, the database is locked while the service is running: %s", err)
+	}
+	defer boltdb.Close()
+
+	log.Print("connect to google sheets...")
+
+	repos, err := openRepositories(cfg, boltdb)
+	if err != nil {
+		log.Fatalf("Error init repositories: %s", err)
+	}
+	repo := repos.stores[source.GoogleSheetsSheet]
+	defer repo.Close(context.Background())
+
+	ingest_service, err := ingest.New(cfg, *source, repo, nil)
+	if err != nil {
+		log.Fatalf("Error init ingest service: %s", err)
+	}
+
+	items := ingest_service.PlanReplay(texts)
+	stats := ingest_service.ApplyReplay(context.Background(), items)
+
+	log.Printf("imported %d of %d problems, %d already imported, %d failed, not parsed messages: %s", stats.Written, len(items), stats.Skipped, stats.Failed, ingest_service.Stats())
+
+	if sheet_outbox, ok := repos.outboxes[source.GoogleSheetsSheet]; ok {
+		waitOutbox(sheet_outbox)
+	}
+}
+
+// waitOutbox writes imported problems to google sheets, events left on
+// interrupt are written by the service on next start.
+func waitOutbox(sheet_outbox *outbox.Outbox) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sheet_outbox.Run(ctx)
+	}()
+	defer func() { <-done }()
+	defer cancel()
+
+	for {
+		pending, err := sheet_outbox.Pending()
+		if err != nil {
+			log.Printf("Error read outbox: %s", err)
+			return
+		}
+		if pending == 0 {
+			log.Print("all problems are written to google sheets")
+			return
+		}
+
+		log.Printf("writing %d problems to google sheets, press ctrl c to leave them for the service", pending)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func describeProblem(problem *entity.Problem) string {
+	description := fmt.Sprintf("problem '%s' camera '%s' '%s' started at %s", problem.ProblemID, problem.CameraID, problem.Description, problem.StartedAt.Format(time.DateTime))
+	if problem.ResolvedAt != nil {
+		description += fmt.Sprintf(", resolved at %s", problem.ResolvedAt.Format(time.DateTime))
+	}
+	if problem.Severity != "" {
+		description += fmt.Sprintf(", severity %s", problem.Severity)
+	}
+	if problem.IsWithdrawn {
+		description += ", withdrawn"
+	}
+	return description
+}
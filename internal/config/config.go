@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"gopkg.in/yaml.v3"
@@ -13,6 +17,100 @@ const (
 	LogLevelProd  = "prod"
 )
 
+const (
+	ParserTemplateKindProblem  = "problem"
+	ParserTemplateKindResolved = "resolved"
+	ParserTemplateKindUpdate   = "update"
+	// ParserTemplateKindAlertmanager is the built-in parser of the default
+	// alertmanager telegram template, its lines are not used.
+	ParserTemplateKindAlertmanager = "alertmanager"
+)
+
+// ParserTemplate matches message rows with Lines in order, rows matching
+// OptionalLines may appear between the first and the last of them.
+type ParserTemplate struct {
+	Name          string   `yaml:"Name"`
+	Kind          string   `yaml:"Kind"`
+	TimeLayout    string   `yaml:"TimeLayout"`
+	Lines         []string `yaml:"Lines"`
+	OptionalLines []string `yaml:"OptionalLines"`
+}
+
+const (
+	GoogleSheetsFieldProblemID       = "ProblemID"
+	GoogleSheetsFieldCameraID        = "CameraID"
+	GoogleSheetsFieldDescription     = "Description"
+	GoogleSheetsFieldStartedAt       = "StartedAt"
+	GoogleSheetsFieldStatus          = "Status"
+	GoogleSheetsFieldResolvedAt      = "ResolvedAt"
+	GoogleSheetsFieldSource          = "Source"
+	GoogleSheetsFieldAcknowledged    = "Acknowledged"
+	GoogleSheetsFieldAcknowledgedBy  = "AcknowledgedBy"
+	GoogleSheetsFieldComments        = "Comments"
+	GoogleSheetsFieldSeverity        = "Severity"
+	GoogleSheetsFieldHost            = "Host"
+	GoogleSheetsFieldHostIP          = "HostIP"
+	GoogleSheetsFieldOperationalData = "OperationalData"
+	GoogleSheetsFieldTags            = "Tags"
+)
+
+// GoogleSheetsColumn is automatic column of problem field or manual column
+// filled by operators, manual column is read as annotation and never written.
+type GoogleSheetsColumn struct {
+	Field      string `yaml:"Field"`
+	Annotation string `yaml:"Annotation"`
+	Name       string `yaml:"Name"`
+}
+
+// GoogleSheetsSchema is layout of sheet, empty fields are taken from defaults.
+// Columns are written in the given order.
+type GoogleSheetsSchema struct {
+	Columns         []GoogleSheetsColumn `yaml:"Columns"`
+	StatusActive    string               `yaml:"StatusActive"`
+	StatusResolved  string               `yaml:"StatusResolved"`
+	StatusWithdrawn string               `yaml:"StatusWithdrawn"`
+	TimeFormat      string               `yaml:"TimeFormat"`
+}
+
+// TelegramSource is alert chat with its own parsing and target sheet,
+// empty fields are taken from the top level config.
+type TelegramSource struct {
+	Name              string           `yaml:"Name"`
+	ChatID            int64            `yaml:"ChatID"`
+	Timezone          string           `yaml:"Timezone"`
+	ParserTemplates   []ParserTemplate `yaml:"ParserTemplates"`
+	GoogleSheetsSheet string           `yaml:"GoogleSheetsSheet"`
+
+	GoogleSheetsSchema         GoogleSheetsSchema `yaml:"GoogleSheetsSchema"`
+	GoogleSheetsRotationLayout string             `yaml:"GoogleSheetsRotationLayout"`
+}
+
+const DefaultTelegramSourceName = "default"
+
+const (
+	DeletedMessagesIgnore   = "ignore"
+	DeletedMessagesWithdraw = "withdraw"
+	DeletedMessagesDelete   = "delete"
+)
+
+const (
+	SinkKindGoogleSheets = "google_sheets"
+	SinkKindJSONLines    = "jsonl"
+)
+
+const (
+	SinkPolicyRequired   = "required"
+	SinkPolicyBestEffort = "best_effort"
+)
+
+// Sink is backend every problem change is forwarded to after it is saved in
+// the local store, google sheets sink writes to the sheet of the source.
+type Sink struct {
+	Kind   string `yaml:"Kind"`
+	Policy string `yaml:"Policy"`
+	Path   string `yaml:"Path"`
+}
+
 type Config struct {
 	LogLevel string `yaml:"LogLevel" env:"LOG_LEVEL"`
 
@@ -22,9 +120,33 @@ type Config struct {
 	TelegramAppID    int    `yaml:"TelegramAppID" env:"TELEGRAM_APP_ID"`
 	TelegramChatID   int64  `yaml:"TelegramChatID" env:"TELEGRAM_CHAT_ID"`
 
+	TelegramReplyAssignee bool `yaml:"TelegramReplyAssignee" env:"TELEGRAM_REPLY_ASSIGNEE"`
+
+	TelegramDeletedMessages string `yaml:"TelegramDeletedMessages" env:"TELEGRAM_DELETED_MESSAGES"`
+
+	TelegramRecordUpdatesFile string `yaml:"TelegramRecordUpdatesFile" env:"TELEGRAM_RECORD_UPDATES_FILE"`
+
 	GoogleSheetsServiceAccountCredentialsFile string `yaml:"GoogleSheetsServiceAccountCredentialsFile" env:"GOOGLE_SHEETS_SERVICE_ACCOUNT_CREDENTIALS_FILE"`
 	GoogleSheetsSpreadsheetID                 string `yaml:"GoogleSheetsSpreadsheetID" env:"GOOGLE_SHEETS_SPREADSHEET_ID"`
 	GoogleSheetsSheet                         string `yaml:"GoogleSheetsSheet" env:"GOOGLE_SHEETS_SHEET"`
+	GoogleSheetsBaseURL                       string `yaml:"GoogleSheetsBaseURL" env:"GOOGLE_SHEETS_BASE_URL"`
+
+	GoogleSheetsSchema GoogleSheetsSchema `yaml:"GoogleSheetsSchema"`
+
+	GoogleSheetsRotationLayout string `yaml:"GoogleSheetsRotationLayout" env:"GOOGLE_SHEETS_ROTATION_LAYOUT"`
+
+	GoogleSheetsRepairLayout bool `yaml:"GoogleSheetsRepairLayout" env:"GOOGLE_SHEETS_REPAIR_LAYOUT"`
+
+	GoogleSheetsRowIndexDir string        `yaml:"GoogleSheetsRowIndexDir" env:"GOOGLE_SHEETS_ROW_INDEX_DIR"`
+	GoogleSheetsBatchSize   int           `yaml:"GoogleSheetsBatchSize" env:"GOOGLE_SHEETS_BATCH_SIZE"`
+	GoogleSheetsBatchWindow time.Duration `yaml:"GoogleSheetsBatchWindow" env:"GOOGLE_SHEETS_BATCH_WINDOW"`
+	GoogleSheetsMaxAttempts int           `yaml:"GoogleSheetsMaxAttempts" env:"GOOGLE_SHEETS_MAX_ATTEMPTS"`
+
+	ParserTemplates []ParserTemplate `yaml:"ParserTemplates"`
+
+	TelegramSources []TelegramSource `yaml:"TelegramSources"`
+
+	Sinks []Sink `yaml:"Sinks"`
 }
 
 func New() (*Config, error) {
@@ -42,9 +164,147 @@ func New() (*Config, error) {
 		return nil, fmt.Errorf("Invalid LogLevel config variable value: '%s', must be %s or %s", cfg.LogLevel, LogLevelDebug, LogLevelProd)
 	}
 
+	if cfg.TelegramDeletedMessages == "" {
+		cfg.TelegramDeletedMessages = DeletedMessagesIgnore
+	}
+	if cfg.TelegramDeletedMessages != DeletedMessagesIgnore && cfg.TelegramDeletedMessages != DeletedMessagesWithdraw && cfg.TelegramDeletedMessages != DeletedMessagesDelete {
+		return nil, fmt.Errorf("Invalid TelegramDeletedMessages config variable value: '%s', must be %s, %s or %s", cfg.TelegramDeletedMessages, DeletedMessagesIgnore, DeletedMessagesWithdraw, DeletedMessagesDelete)
+	}
+
+	err = validateParserTemplates("ParserTemplates", cfg.ParserTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.TelegramSources) == 0 {
+		cfg.TelegramSources = []TelegramSource{{
+			Name:   DefaultTelegramSourceName,
+			ChatID: cfg.TelegramChatID,
+		}}
+	}
+
+	names := map[string]bool{}
+	chat_ids := map[int64]bool{}
+	sheets := map[string]TelegramSource{}
+
+	for i := range cfg.TelegramSources {
+		source := &cfg.TelegramSources[i]
+
+		if source.Name == "" {
+			return nil, fmt.Errorf("Invalid TelegramSources[%d] config value: Name is empty", i)
+		}
+		if names[source.Name] {
+			return nil, fmt.Errorf("Invalid TelegramSources[%d] config value: Name '%s' is duplicated", i, source.Name)
+		}
+		if chat_ids[source.ChatID] {
+			return nil, fmt.Errorf("Invalid TelegramSources[%d] config value: ChatID %v is duplicated", i, source.ChatID)
+		}
+		names[source.Name] = true
+		chat_ids[source.ChatID] = true
+
+		if source.Timezone == "" {
+			source.Timezone = cfg.TelegramTimezone
+		}
+		if len(source.ParserTemplates) == 0 {
+			source.ParserTemplates = cfg.ParserTemplates
+		}
+		if source.GoogleSheetsSheet == "" {
+			source.GoogleSheetsSheet = cfg.GoogleSheetsSheet
+		}
+
+		if len(source.GoogleSheetsSchema.Columns) == 0 {
+			source.GoogleSheetsSchema.Columns = cfg.GoogleSheetsSchema.Columns
+		}
+		if source.GoogleSheetsSchema.StatusActive == "" {
+			source.GoogleSheetsSchema.StatusActive = cfg.GoogleSheetsSchema.StatusActive
+		}
+		if source.GoogleSheetsSchema.StatusResolved == "" {
+			source.GoogleSheetsSchema.StatusResolved = cfg.GoogleSheetsSchema.StatusResolved
+		}
+		if source.GoogleSheetsSchema.StatusWithdrawn == "" {
+			source.GoogleSheetsSchema.StatusWithdrawn = cfg.GoogleSheetsSchema.StatusWithdrawn
+		}
+		if source.GoogleSheetsSchema.TimeFormat == "" {
+			source.GoogleSheetsSchema.TimeFormat = cfg.GoogleSheetsSchema.TimeFormat
+		}
+		if source.GoogleSheetsRotationLayout == "" {
+			source.GoogleSheetsRotationLayout = cfg.GoogleSheetsRotationLayout
+		}
+
+		err = validateParserTemplates(fmt.Sprintf("TelegramSources[%d].ParserTemplates", i), source.ParserTemplates)
+		if err != nil {
+			return nil, err
+		}
+
+		// sources may share sheet, it has single layout
+		other, ok := sheets[source.GoogleSheetsSheet]
+		if ok && !reflect.DeepEqual(other.GoogleSheetsSchema, source.GoogleSheetsSchema) {
+			return nil, fmt.Errorf("Invalid TelegramSources[%d] config value: GoogleSheetsSchema differs from other source of sheet '%s'", i, source.GoogleSheetsSheet)
+		}
+		if ok && other.GoogleSheetsRotationLayout != source.GoogleSheetsRotationLayout {
+			return nil, fmt.Errorf("Invalid TelegramSources[%d] config value: GoogleSheetsRotationLayout differs from other source of sheet '%s'", i, source.GoogleSheetsSheet)
+		}
+		sheets[source.GoogleSheetsSheet] = *source
+	}
+
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = []Sink{{
+			Kind:   SinkKindGoogleSheets,
+			Policy: SinkPolicyRequired,
+		}}
+	}
+
+	for i, sink := range cfg.Sinks {
+		if sink.Kind != SinkKindGoogleSheets && sink.Kind != SinkKindJSONLines {
+			return nil, fmt.Errorf("Invalid Sinks[%d] config value: Kind '%s', must be %s or %s", i, sink.Kind, SinkKindGoogleSheets, SinkKindJSONLines)
+		}
+		if sink.Policy != SinkPolicyRequired && sink.Policy != SinkPolicyBestEffort {
+			return nil, fmt.Errorf("Invalid Sinks[%d] config value: Policy '%s', must be %s or %s", i, sink.Policy, SinkPolicyRequired, SinkPolicyBestEffort)
+		}
+		if sink.Kind == SinkKindJSONLines && sink.Path == "" {
+			return nil, fmt.Errorf("Invalid Sinks[%d] config value: Path is empty", i)
+		}
+	}
+
 	return &cfg, nil
 }
 
+func validateParserTemplates(name string, templates []ParserTemplate) error {
+	for i, template := range templates {
+		if template.Name == "" {
+			return fmt.Errorf("Invalid %s[%d] config value: Name is empty", name, i)
+		}
+		if template.Kind != ParserTemplateKindProblem && template.Kind != ParserTemplateKindResolved && template.Kind != ParserTemplateKindUpdate && template.Kind != ParserTemplateKindAlertmanager {
+			return fmt.Errorf("Invalid %s[%d] config value: Kind '%s', must be %s, %s, %s or %s", name, i, template.Kind, ParserTemplateKindProblem, ParserTemplateKindResolved, ParserTemplateKindUpdate, ParserTemplateKindAlertmanager)
+		}
+		if template.Kind == ParserTemplateKindAlertmanager {
+			continue
+		}
+		if len(template.Lines) == 0 {
+			return fmt.Errorf("Invalid %s[%d] config value: Lines is empty", name, i)
+		}
+		if !capturesProblemID(template.Lines) {
+			return fmt.Errorf("Invalid %s[%d] config value: template '%s' has no (?P<ProblemID>...) capture in Lines, problems of its messages can not be told apart", name, i, template.Name)
+		}
+	}
+	return nil
+}
+
+// capturesProblemID reports whether any of lines has ProblemID capture,
+// invalid lines are reported by parser.
+func capturesProblemID(lines []string) bool {
+	for _, line := range lines {
+		re, err := regexp.Compile(line)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(re.SubexpNames(), "ProblemID") {
+			return true
+		}
+	}
+	return false
+}
+
 func (cfg *Config) StringSecureMasked() (string, error) {
 	cfg_masked := new(Config)
 	*cfg_masked = *cfg
@@ -1,6 +1,21 @@
 package entity
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// AnnotationAssignee is annotation with operator responsible for problem.
+const AnnotationAssignee = "Assignee"
+
+// Severities are zabbix severity names from the lowest.
+var Severities = []string{"Not classified", "Information", "Warning", "Average", "High", "Disaster"}
+
+// Acknowledgement change made by update of problem.
+const (
+	Acknowledged   = "acknowledged"
+	Unacknowledged = "unacknowledged"
+)
 
 type Problem struct {
 	ProblemID   string
@@ -9,4 +24,71 @@ type Problem struct {
 	StartedAt   time.Time
 	IsResolved  bool
 	ResolvedAt  *time.Time
+	Source      string
+	// Severity, Host, HostIP, OperationalData and Tags are optional details
+	// of alert, they are empty if messages do not carry them.
+	Severity        string
+	Host            string
+	HostIP          string
+	OperationalData string
+	Tags            []Tag
+	// IsWithdrawn is set when alert message was deleted from chat as false positive.
+	IsWithdrawn bool
+	// IsAcknowledged and Acknowledger are state of the last acknowledgement,
+	// Comments are every update of problem, oldest first.
+	IsAcknowledged bool
+	Acknowledger   string
+	Comments       []Comment
+	// Annotations are values of manual sheet columns by annotation name,
+	// they are filled by operators and only read by the app.
+	Annotations map[string]string
+	// Version grows with every change of problem saved in local store, so
+	// projections can skip stale writes, zero is unknown version.
+	Version uint64
+}
+
+// Comment is update of problem by user, e.g. acknowledgement, message or
+// severity change. Acknowledgement is Acknowledged, Unacknowledged or empty
+// if update did not change it, Severity is the new severity or empty.
+type Comment struct {
+	Author          string
+	Action          string
+	Message         string
+	Acknowledgement string
+	Severity        string
+	CreatedAt       time.Time
+}
+
+// Tag is zabbix event tag, value may be empty.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// FormatTags formats tags as zabbix {EVENT.TAGS} macro, e.g.
+// "scope:availability, camera".
+func FormatTags(tags []Tag) string {
+	formatted := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag.Value == "" {
+			formatted = append(formatted, tag.Name)
+			continue
+		}
+		formatted = append(formatted, tag.Name+":"+tag.Value)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// ParseTags parses tags formatted by FormatTags.
+func ParseTags(value string) []Tag {
+	var tags []Tag
+	for _, part := range strings.Split(value, ", ") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, tag_value, _ := strings.Cut(part, ":")
+		tags = append(tags, Tag{Name: name, Value: tag_value})
+	}
+	return tags
 }
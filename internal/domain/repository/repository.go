@@ -1,8 +1,54 @@
 package repository
 
-import "github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+import (
+	"context"
+	"errors"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+)
+
+var (
+	// ErrAlreadyExists is returned by Create if problem with the same id exists.
+	ErrAlreadyExists = errors.New("problem already exists")
+	// ErrNotFound is returned by Get if there is no problem with the id.
+	ErrNotFound = errors.New("problem not found")
+)
+
+// Filter selects problems in List, zero fields match any problem.
+// Withdrawn problems are neither active nor resolved, so IsResolved skips them.
+type Filter struct {
+	CameraID   string
+	Source     string
+	IsResolved *bool
+	Limit      int
+}
 
 type Repository interface {
-	Create(*entity.Problem) error
-	Update(*entity.Problem) error
+	Create(ctx context.Context, problem *entity.Problem) error
+	Update(ctx context.Context, problem *entity.Problem) error
+	Get(ctx context.Context, id string) (*entity.Problem, error)
+	List(ctx context.Context, filter Filter) ([]*entity.Problem, error)
+	// Delete removes problem, problem that does not exist is not an error
+	// for projections, as it may have been removed by operators.
+	Delete(ctx context.Context, problem *entity.Problem) error
+	Close(ctx context.Context) error
+}
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Write is single Create, Update or Delete call for BatchWriter.
+type Write struct {
+	Action  string
+	Problem *entity.Problem
+}
+
+// BatchWriter is implemented by repositories that can apply several writes
+// at once. Writes are applied in order, create of existing problem is skipped
+// as Create would fail with ErrAlreadyExists.
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, writes []Write) error
 }
@@ -0,0 +1,116 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/gotd/contrib/storage"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/gotd/td/tg"
+)
+
+const backfillPageSize = 100
+
+// backfill applies messages that were sent to watched chats while client was down,
+// updates recovery only covers a limited gap.
+func (c *Client) backfill(ctx context.Context) {
+	for _, chat_id := range c.handler.chats.watched() {
+		count, err := c.backfillChat(ctx, chat_id)
+		if err != nil {
+			log.Printf("Failed backfill chat %v: %s", chat_id, err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("Backfilled %d messages from chat %v", count, chat_id)
+		}
+	}
+}
+
+func (c *Client) backfillChat(ctx context.Context, chat_id int64) (int, error) {
+	input_peer, err := c.findInputPeer(ctx, chat_id)
+	if err != nil {
+		return 0, err
+	}
+
+	last_id, ok, err := c.handler.chats.lastMessageID(chat_id)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		// nothing processed yet, start from the newest message instead of whole history
+		messages, err := c.getHistory(ctx, input_peer, 0, 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		if len(messages) > 0 {
+			return 0, c.handler.chats.setLastMessageID(chat_id, messages[0].GetID())
+		}
+		return 0, nil
+	}
+
+	var missed []tg.MessageClass
+
+	offset_id := 0
+	for {
+		messages, err := c.getHistory(ctx, input_peer, offset_id, last_id, backfillPageSize)
+		if err != nil {
+			return 0, err
+		}
+
+		missed = append(missed, messages...)
+
+		if len(messages) < backfillPageSize {
+			break
+		}
+		offset_id = messages[len(messages)-1].GetID()
+	}
+
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].GetID() < missed[j].GetID()
+	})
+
+	for _, message := range missed {
+		err := c.handler.onMessage(ctx, message)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(missed), nil
+}
+
+// getHistory returns messages newer than min_id and older than offset_id, newest first.
+func (c *Client) getHistory(ctx context.Context, input_peer tg.InputPeerClass, offset_id int, min_id int, limit int) ([]tg.MessageClass, error) {
+	result, err := c.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+		Peer:     input_peer,
+		OffsetID: offset_id,
+		MinID:    min_id,
+		Limit:    limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed get history: %s", err)
+	}
+
+	modified, ok := result.AsModified()
+	if !ok {
+		return nil, nil
+	}
+
+	return modified.GetMessages(), nil
+}
+
+// findInputPeer looks up chat in collected peers, config has only bare id so every peer kind is tried.
+func (c *Client) findInputPeer(ctx context.Context, chat_id int64) (tg.InputPeerClass, error) {
+	for _, kind := range []dialogs.PeerKind{dialogs.Channel, dialogs.Chat, dialogs.User} {
+		peer, err := c.peerDB.Find(ctx, storage.PeerKey{Kind: kind, ID: chat_id})
+		if err != nil {
+			continue
+		}
+		return peer.AsInputPeer(), nil
+	}
+
+	return nil, fmt.Errorf("Chat %v not found among dialogs", chat_id)
+}
@@ -0,0 +1,180 @@
+package telegram
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	chatMigrationsBucket = []byte("chat_migrations")
+	lastMessageIDsBucket = []byte("last_message_ids")
+)
+
+// chats resolves chat ids of incoming messages to configured chat ids.
+// When basic group is upgraded to supergroup its id changes, migrations are
+// persisted so ingestion keeps following the group after restart.
+// It also keeps the last processed message id of every chat for backfill.
+type chats struct {
+	db      *bbolt.DB
+	mu      sync.RWMutex
+	aliases map[int64]int64
+	current map[int64]int64
+}
+
+func newChats(db *bbolt.DB, configured ...int64) (*chats, error) {
+	c := chats{
+		db:      db,
+		aliases: map[int64]int64{},
+		current: map[int64]int64{},
+	}
+
+	migrations := map[int64]int64{}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(lastMessageIDsBucket)
+		if err != nil {
+			return err
+		}
+
+		bucket, err := tx.CreateBucketIfNotExists(chatMigrationsBucket)
+		if err != nil {
+			return err
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			migrations[decodeInt64(k)] = decodeInt64(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed load chat migrations: %s", err)
+	}
+
+	for _, chat_id := range configured {
+		c.aliases[chat_id] = chat_id
+
+		current := chat_id
+		for i := 0; i < len(migrations); i++ {
+			next, ok := migrations[current]
+			if !ok {
+				break
+			}
+			current = next
+			c.aliases[current] = chat_id
+		}
+
+		c.current[chat_id] = current
+
+		if current != chat_id {
+			log.Printf("Chat %v was migrated to %v, following it", chat_id, current)
+		}
+	}
+
+	return &c, nil
+}
+
+// resolve returns configured chat id for chat_id, false if chat is not watched.
+func (c *chats) resolve(chat_id int64) (int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	configured, ok := c.aliases[chat_id]
+	return configured, ok
+}
+
+// migrate starts following to if from is watched, does nothing otherwise.
+func (c *chats) migrate(from int64, to int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	configured, ok := c.aliases[from]
+	if !ok {
+		return nil
+	}
+
+	if _, ok := c.aliases[to]; ok {
+		return nil
+	}
+
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(chatMigrationsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(encodeInt64(from), encodeInt64(to))
+	})
+	if err != nil {
+		return fmt.Errorf("Failed save chat migration %v -> %v: %s", from, to, err)
+	}
+
+	c.aliases[to] = configured
+	c.current[configured] = to
+
+	log.Printf("Chat %v was migrated to %v, following it", from, to)
+
+	return nil
+}
+
+// watched returns current ids of all configured chats.
+func (c *chats) watched() []int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	chat_ids := make([]int64, 0, len(c.current))
+	for _, chat_id := range c.current {
+		chat_ids = append(chat_ids, chat_id)
+	}
+	return chat_ids
+}
+
+// lastMessageID returns id of the last processed message in chat, false if there is none yet.
+func (c *chats) lastMessageID(chat_id int64) (int, bool, error) {
+	var message_id int
+	var ok bool
+
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(lastMessageIDsBucket).Get(encodeInt64(chat_id))
+		if value != nil {
+			message_id = int(decodeInt64(value))
+			ok = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("Failed read last message id of chat %v: %s", chat_id, err)
+	}
+
+	return message_id, ok, nil
+}
+
+// setLastMessageID saves message_id if it is newer than saved one.
+func (c *chats) setLastMessageID(chat_id int64, message_id int) error {
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(lastMessageIDsBucket)
+
+		value := bucket.Get(encodeInt64(chat_id))
+		if value != nil && int(decodeInt64(value)) >= message_id {
+			return nil
+		}
+
+		return bucket.Put(encodeInt64(chat_id), encodeInt64(int64(message_id)))
+	})
+	if err != nil {
+		return fmt.Errorf("Failed save last message id of chat %v: %s", chat_id, err)
+	}
+	return nil
+}
+
+func encodeInt64(value int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(value))
+	return b
+}
+
+func decodeInt64(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
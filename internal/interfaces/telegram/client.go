@@ -11,8 +11,7 @@ import (
 	"time"
 
 	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
-	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
-	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/parser"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
 
 	pebbledb "github.com/cockroachdb/pebble"
 	boltstor "github.com/gotd/contrib/bbolt"
@@ -23,6 +22,7 @@ import (
 	"github.com/gotd/td/examples"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/message"
 	"github.com/gotd/td/telegram/message/peer"
 	"github.com/gotd/td/telegram/query"
 	"github.com/gotd/td/telegram/updates"
@@ -58,21 +58,45 @@ type Client struct {
 	peerDB          *pebble.PeerStorage
 	api             *tg.Client
 	updatesRecovery *updates.Manager
+	handler         *handler
 }
 
-func New(cfg *config.Config, repo repository.Repository) (*Client, error) {
-	location, err := time.LoadLocation(cfg.TelegramTimezone)
-	if err != nil {
-		return nil, fmt.Errorf("Failed load timezone: %s", err)
-	}
-
+func sessionDir(cfg *config.Config) (string, error) {
 	if ok := isValidPhoneNumber(cfg.TelegramPhone); !ok {
-		return nil, fmt.Errorf("Invalid telegram phone number in config: %s", cfg.TelegramPhone)
+		return "", fmt.Errorf("Invalid telegram phone number in config: %s", cfg.TelegramPhone)
 	}
 
 	sessionDir := filepath.Join("data/telegram/session", sessionFolder(cfg.TelegramPhone))
 	if err := os.MkdirAll(sessionDir, 0700); err != nil {
-		return nil, fmt.Errorf("Failed create session storage: %s", err)
+		return "", fmt.Errorf("Failed create session storage: %s", err)
+	}
+
+	return sessionDir, nil
+}
+
+// OpenStateDB opens bolt database of the session, besides updates state it keeps
+// chat migrations, last processed messages and outbox, so it is shared with
+// other components and must be opened once.
+func OpenStateDB(cfg *config.Config, options *bbolt.Options) (*bbolt.DB, error) {
+	sessionDir, err := sessionDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	boltdb, err := bbolt.Open(filepath.Join(sessionDir, "updates.bolt.db"), 0666, options)
+	if err != nil {
+		return nil, fmt.Errorf("Failed create bolt storage: %w", err)
+	}
+
+	return boltdb, nil
+}
+
+// New creates client that feeds messages of every source chat to its service,
+// boltdb is opened by OpenStateDB.
+func New(cfg *config.Config, services []*ingest.Service, boltdb *bbolt.DB) (*Client, error) {
+	sessionDir, err := sessionDir(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	sessionStorage := &telegram.FileSessionStorage{
@@ -89,11 +113,20 @@ func New(cfg *config.Config, repo repository.Repository) (*Client, error) {
 
 	updateHandler := storage.UpdateHook(dispatcher, peerDB)
 
-	boltdb, err := bbolt.Open(filepath.Join(sessionDir, "updates.bolt.db"), 0666, nil)
+	handler, err := newHandler(services, boltdb)
 	if err != nil {
-		return nil, fmt.Errorf("Failed create bolt storage: %s", err)
+		return nil, err
+	}
+
+	if cfg.TelegramRecordUpdatesFile != "" {
+		handler.recorder, err = newRecorder(cfg.TelegramRecordUpdatesFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	handler.register(dispatcher)
+
 	updatesRecovery := updates.New(updates.Config{
 		Handler: updateHandler,
 		Storage: boltstor.NewStateStorage(boltdb),
@@ -118,86 +151,11 @@ func New(cfg *config.Config, repo repository.Repository) (*Client, error) {
 	resolver := storage.NewResolverCache(peer.Plain(api), peerDB)
 	_ = resolver
 
-	dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
-		msg, ok := u.Message.(*tg.Message)
-		if !ok {
-			return nil
-		}
-
-		peer_chat, ok := msg.GetPeerID().(*tg.PeerUser)
-		if !ok {
-			return nil
-		}
-
-		if peer_chat.UserID != cfg.TelegramChatID {
-			log.Printf("Ignoring message '%s' from chat %v", msg.Message, peer_chat.UserID)
-			return nil
-		}
-
-		problem, ok := parser.ParseProblemMessage(msg.Message, location)
-		if !ok {
-			log.Printf("Message '%s' from target chat is not valid problem message", msg.Message)
-			return nil
-		}
-
-		err := repo.Create(problem)
-		if err != nil {
-			return fmt.Errorf("Failed write problem '%s' to google sheets: %s", msg.Message, err)
-		}
-
-		log.Printf("Problem '%s' successfully writed to google sheets", msg.Message)
-
-		return nil
-	})
-
-	dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
-		msg, ok := u.Message.(*tg.Message)
-		if !ok {
-			return nil
-		}
-
-		peer_chanel, ok := msg.GetPeerID().(*tg.PeerChannel)
-		if !ok {
-			return nil
-		}
-
-		if peer_chanel.ChannelID != cfg.TelegramChatID {
-			log.Printf("Ignoring message '%s' from chat %v", msg.Message, peer_chanel.ChannelID)
-			return nil
-		}
-
-		problem, ok := parser.ParseProblemMessage(msg.Message, location)
-		if !ok {
-			log.Printf("Message '%s' from target chat is not valid problem message", msg.Message)
-			return nil
-		}
-
-		if problem == nil {
-			return fmt.Errorf("Message '%s' is valid problem message, but parsed problem is nil", msg.Message)
-		}
-
-		if !problem.IsResolved {
-			err := repo.Create(problem)
-			if err != nil {
-				return fmt.Errorf("Failed write problem '%s' to google sheets: %s", msg.Message, err)
-			}
-		} else {
-			err := repo.Update(problem)
-			if err != nil {
-				return fmt.Errorf("Failed write problem '%s' to google sheets: %s", msg.Message, err)
-			}
-		}
-
-		log.Printf("Problem '%s' successfully writed to google sheets", msg.Message)
-
-		return nil
-	})
-
 	flow := auth.NewFlow(examples.Terminal{PhoneNumber: cfg.TelegramPhone}, auth.SendCodeOptions{})
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Client{
+	c := &Client{
 		ctx:             ctx,
 		cancel:          cancel,
 		waiter:          waiter,
@@ -206,7 +164,29 @@ func New(cfg *config.Config, repo repository.Repository) (*Client, error) {
 		peerDB:          peerDB,
 		api:             api,
 		updatesRecovery: updatesRecovery,
-	}, nil
+		handler:         handler,
+	}
+
+	if cfg.TelegramReplyAssignee {
+		handler.reply = c.reply
+	}
+
+	return c, nil
+}
+
+// reply sends text as reply to message of chat.
+func (c *Client) reply(ctx context.Context, chat_id int64, message_id int, text string) error {
+	input_peer, err := c.findInputPeer(ctx, chat_id)
+	if err != nil {
+		return err
+	}
+
+	_, err = message.NewSender(c.api).To(input_peer).Reply(message_id).Text(ctx, text)
+	if err != nil {
+		return fmt.Errorf("Failed send reply to chat %v: %s", chat_id, err)
+	}
+
+	return nil
 }
 
 func (c *Client) Run() error {
@@ -228,6 +208,8 @@ func (c *Client) Run() error {
 				return err
 			}
 
+			c.backfill(ctx)
+
 			fmt.Println("Listening for updates. Interrupt (Ctrl+C) to stop.")
 			return c.updatesRecovery.Run(ctx, c.api, self.ID, updates.AuthOptions{
 				IsBot: self.Bot,
@@ -244,5 +226,9 @@ func (c *Client) Run() error {
 
 func (c *Client) Stop() error {
 	c.cancel()
+
+	if c.handler.recorder != nil {
+		return c.handler.recorder.close()
+	}
 	return nil
 }
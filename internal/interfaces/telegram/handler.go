@@ -0,0 +1,348 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
+
+	"github.com/gotd/td/tg"
+	"go.etcd.io/bbolt"
+)
+
+// peerChatID returns id of the chat message belongs to, for every supported peer type.
+func peerChatID(peer tg.PeerClass) (int64, bool) {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		return p.UserID, true
+	case *tg.PeerChat:
+		return p.ChatID, true
+	case *tg.PeerChannel:
+		return p.ChannelID, true
+	}
+	return 0, false
+}
+
+// handler routes messages of watched chats to pipeline of their source.
+// It has no connection of its own, so tests feed updates through dispatcher.
+type handler struct {
+	services  map[int64]*ingest.Service
+	chats     *chats
+	revisions *revisions
+	messages  *messages
+	// reply posts assignee of resolved problem to its alert, nil if disabled
+	reply func(ctx context.Context, chat_id int64, message_id int, text string) error
+	// recorder writes updates of watched chats to file, nil if disabled
+	recorder *recorder
+}
+
+func newHandler(services []*ingest.Service, boltdb *bbolt.DB) (*handler, error) {
+	services_by_chat := map[int64]*ingest.Service{}
+	chat_ids := make([]int64, 0, len(services))
+	for _, service := range services {
+		services_by_chat[service.Source().ChatID] = service
+		chat_ids = append(chat_ids, service.Source().ChatID)
+	}
+
+	chats, err := newChats(boltdb, chat_ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := newRevisions(boltdb)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := newMessages(boltdb)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handler{
+		services:  services_by_chat,
+		chats:     chats,
+		revisions: revisions,
+		messages:  messages,
+	}, nil
+}
+
+// register subscribes handler to message updates of dispatcher.
+func (h *handler) register(dispatcher tg.UpdateDispatcher) {
+	dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
+		h.record(u, e, u.Message)
+		return h.onMessage(ctx, u.Message)
+	})
+
+	dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+		h.record(u, e, u.Message)
+		return h.onMessage(ctx, u.Message)
+	})
+
+	dispatcher.OnEditMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateEditMessage) error {
+		h.record(u, e, u.Message)
+		return h.onEdit(ctx, u.Message)
+	})
+
+	dispatcher.OnEditChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateEditChannelMessage) error {
+		h.record(u, e, u.Message)
+		return h.onEdit(ctx, u.Message)
+	})
+
+	dispatcher.OnDeleteMessages(func(ctx context.Context, e tg.Entities, u *tg.UpdateDeleteMessages) error {
+		return h.onDelete(ctx, u, e, commonBox, u.Messages)
+	})
+
+	dispatcher.OnDeleteChannelMessages(func(ctx context.Context, e tg.Entities, u *tg.UpdateDeleteChannelMessages) error {
+		return h.onDelete(ctx, u, e, u.ChannelID, u.Messages)
+	})
+}
+
+// record writes update with its entities if message belongs to watched chat.
+func (h *handler) record(update tg.UpdateClass, entities tg.Entities, message tg.MessageClass) {
+	if h.recorder == nil {
+		return
+	}
+
+	peer, ok := message.(interface{ GetPeerID() tg.PeerClass })
+	if !ok {
+		return
+	}
+
+	chat_id, ok := peerChatID(peer.GetPeerID())
+	if !ok {
+		return
+	}
+
+	if _, ok := h.chats.resolve(chat_id); ok {
+		h.recorder.record(update, entities)
+	}
+}
+
+func (h *handler) onMessage(ctx context.Context, message tg.MessageClass) error {
+	switch msg := message.(type) {
+	case *tg.Message:
+		return h.onTextMessage(ctx, msg)
+	case *tg.MessageService:
+		return h.onServiceMessage(msg)
+	}
+	return nil
+}
+
+func (h *handler) onTextMessage(ctx context.Context, msg *tg.Message) error {
+	chat_id, ok := peerChatID(msg.GetPeerID())
+	if !ok {
+		return nil
+	}
+
+	configured_id, ok := h.chats.resolve(chat_id)
+	if !ok {
+		log.Printf("Ignoring message '%s' from chat %v", msg.Message, chat_id)
+		return nil
+	}
+
+	service, ok := h.services[configured_id]
+	if !ok {
+		return nil
+	}
+
+	// message may come both from backfill and updates recovery
+	last_id, ok, err := h.chats.lastMessageID(chat_id)
+	if err != nil {
+		return err
+	}
+	if ok && msg.ID <= last_id {
+		return nil
+	}
+
+	problems, err := service.HandleMessage(ctx, msg.Message, time.Unix(int64(msg.Date), 0))
+	if err != nil {
+		return err
+	}
+
+	// only start of problem is traced back when message is deleted,
+	// deleted resolution does not make problem false positive
+	var started []string
+	for _, problem := range problems {
+		if !problem.IsResolved {
+			started = append(started, problem.ProblemID)
+		}
+	}
+	if len(started) > 0 {
+		err = h.messages.add(messageBox(msg.PeerID), msg.ID, messageProblem{ChatID: configured_id, ProblemIDs: started})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = h.chats.setLastMessageID(chat_id, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	if h.reply != nil {
+		h.replyAssignees(ctx, chat_id, msg.ID, problems)
+	}
+
+	return nil
+}
+
+// replyAssignees replies to message with assignees of its resolved problems,
+// problems of grouped notification are named by description.
+func (h *handler) replyAssignees(ctx context.Context, chat_id int64, message_id int, problems []*entity.Problem) {
+	var resolved []*entity.Problem
+	for _, problem := range problems {
+		if problem.IsResolved && problem.Annotations[entity.AnnotationAssignee] != "" {
+			resolved = append(resolved, problem)
+		}
+	}
+	if len(resolved) == 0 {
+		return
+	}
+
+	text := fmt.Sprintf("Ответственный: %s", resolved[0].Annotations[entity.AnnotationAssignee])
+	if len(problems) > 1 {
+		lines := []string{"Ответственные:"}
+		for _, problem := range resolved {
+			lines = append(lines, fmt.Sprintf("%s: %s", problem.Description, problem.Annotations[entity.AnnotationAssignee]))
+		}
+		text = strings.Join(lines, "\n")
+	}
+
+	err := h.reply(ctx, chat_id, message_id, text)
+	if err != nil {
+		log.Printf("Failed reply assignee of problem '%s': %s", resolved[0].ProblemID, err)
+	}
+}
+
+// onEdit reconciles problem of edited message and saves revision of the message.
+func (h *handler) onEdit(ctx context.Context, message tg.MessageClass) error {
+	msg, ok := message.(*tg.Message)
+	if !ok {
+		return nil
+	}
+
+	chat_id, ok := peerChatID(msg.GetPeerID())
+	if !ok {
+		return nil
+	}
+
+	configured_id, ok := h.chats.resolve(chat_id)
+	if !ok {
+		return nil
+	}
+
+	service, ok := h.services[configured_id]
+	if !ok {
+		return nil
+	}
+
+	// message that is not processed yet is left to its own update or backfill,
+	// both of them bring edited text
+	last_id, ok, err := h.chats.lastMessageID(chat_id)
+	if err != nil {
+		return err
+	}
+	if !ok || msg.ID > last_id {
+		return nil
+	}
+
+	// updates recovery may deliver the edit again
+	known, err := h.revisions.list(chat_id, msg.ID)
+	if err != nil {
+		return err
+	}
+	if len(known) > 0 && known[len(known)-1].Message == msg.Message {
+		return nil
+	}
+
+	rev, err := service.HandleEdit(ctx, msg.Message, time.Unix(int64(msg.Date), 0))
+	if err != nil {
+		return err
+	}
+
+	edited_at, ok := msg.GetEditDate()
+	if !ok {
+		edited_at = msg.Date
+	}
+
+	message_rev := revision{
+		EditedAt: time.Unix(int64(edited_at), 0),
+		Message:  msg.Message,
+	}
+	if rev != nil {
+		message_rev.ProblemID = rev.ProblemID
+		message_rev.Changes = rev.Changes
+	}
+
+	if rev != nil && rev.Created {
+		err = h.messages.add(messageBox(msg.PeerID), msg.ID, messageProblem{ChatID: configured_id, ProblemIDs: []string{rev.ProblemID}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.revisions.add(chat_id, msg.ID, message_rev)
+}
+
+// onDelete applies deletion policy to problems created by deleted messages,
+// update is recorded only if it deletes such message.
+func (h *handler) onDelete(ctx context.Context, update tg.UpdateClass, entities tg.Entities, box int64, message_ids []int) error {
+	recorded := false
+
+	for _, message_id := range message_ids {
+		problem, ok, err := h.messages.get(box, message_id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if !recorded && h.recorder != nil {
+			h.recorder.record(update, entities)
+			recorded = true
+		}
+
+		service, ok := h.services[problem.ChatID]
+		if ok {
+			for _, problem_id := range problem.ProblemIDs {
+				err = service.HandleDeletion(ctx, problem_id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = h.messages.remove(box, message_id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// onServiceMessage follows basic group upgrade to supergroup, the old group
+// gets "migrate to" message and the new supergroup gets "migrate from" one.
+func (h *handler) onServiceMessage(msg *tg.MessageService) error {
+	switch action := msg.Action.(type) {
+	case *tg.MessageActionChatMigrateTo:
+		chat_id, ok := peerChatID(msg.GetPeerID())
+		if !ok {
+			return nil
+		}
+		return h.chats.migrate(chat_id, action.ChannelID)
+	case *tg.MessageActionChannelMigrateFrom:
+		channel_id, ok := peerChatID(msg.GetPeerID())
+		if !ok {
+			return nil
+		}
+		return h.chats.migrate(action.ChatID, channel_id)
+	}
+	return nil
+}
@@ -0,0 +1,565 @@
+package telegram
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+
+	"github.com/gotd/td/tg"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite expected calls of recorded fixtures")
+
+func TestNewMessageOfEveryPeerTypeCreatesProblem(t *testing.T) {
+	h := newHarness(t, alertsSource, camerasSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		chatMessage(camerasSource.ChatID, 20, problemText("200", "cam-2")),
+	)
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"cameras: create 200 active",
+	)
+
+	problem, err := h.repos["alerts"].Get(context.Background(), "100")
+	if err != nil {
+		t.Fatalf("Failed get problem: %s", err)
+	}
+	if problem.CameraID != "cam-1" || problem.Source != "alerts" {
+		t.Fatalf("Problem is %+v, want camera cam-1 of source alerts", *problem)
+	}
+}
+
+func TestUserChatIsWatched(t *testing.T) {
+	source := alertsSource
+	source.ChatID = 4242
+	h := newHarness(t, source)
+
+	h.mustFeed(userMessage(4242, 1, problemText("100", "cam-1")))
+
+	h.assertCalls("alerts: create 100 active")
+}
+
+func TestMessagesOfOtherChatsAreIgnored(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(
+		channelMessage(3003, 10, problemText("100", "cam-1")),
+		chatMessage(4004, 11, problemText("101", "cam-1")),
+		userMessage(5005, 12, problemText("102", "cam-1")),
+	)
+
+	h.assertCalls()
+}
+
+func TestNotAlertMessagesAreIgnored(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, "Коллеги, камера 5 снова недоступна?"),
+		channelMessage(alertsSource.ChatID, 11, "Problem: С камеры cam-1 нет сигнала"),
+	)
+
+	h.assertCalls()
+}
+
+func TestRedeliveredMessageIsAppliedOnce(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	// updates recovery may deliver the message again after backfill
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	h.mustFeed(channelMessage(alertsSource.ChatID, 9, problemText("99", "cam-1")))
+
+	h.assertCalls("alerts: create 100 active")
+}
+
+func TestResolveRepliesAssignee(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	h.repos["alerts"].annotate("100", entity.AnnotationAssignee, "Иванов")
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 11, resolvedText("100", "cam-1")),
+		channelMessage(alertsSource.ChatID, 12, resolvedText("200", "cam-2")),
+	)
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: update 100 resolved",
+		"alerts: update 200 resolved",
+	)
+	h.assertReplies("1001/11: Ответственный: Иванов")
+}
+
+func TestNoRepliesWhenDisabled(t *testing.T) {
+	h := newHarness(t, alertsSource)
+	h.handler.reply = nil
+
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	h.repos["alerts"].annotate("100", entity.AnnotationAssignee, "Иванов")
+	h.mustFeed(channelMessage(alertsSource.ChatID, 11, resolvedText("100", "cam-1")))
+
+	h.assertReplies()
+}
+
+func TestMigratedGroupIsFollowed(t *testing.T) {
+	h := newHarness(t, camerasSource)
+
+	h.mustFeed(
+		chatMessage(camerasSource.ChatID, 1, problemText("300", "cam-3")),
+		&tg.UpdateNewMessage{Message: &tg.MessageService{
+			ID:     2,
+			PeerID: &tg.PeerChat{ChatID: camerasSource.ChatID},
+			Action: &tg.MessageActionChatMigrateTo{ChannelID: 7007},
+		}},
+	)
+	// message ids of supergroup start over
+	h.mustFeed(channelMessage(7007, 1, resolvedText("300", "cam-3")))
+
+	h.assertCalls(
+		"cameras: create 300 active",
+		"cameras: update 300 resolved",
+	)
+}
+
+func TestEditedAlertUpdatesProblem(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	h.mustFeed(editChannelMessage(alertsSource.ChatID, 10, problemText("100", "cam-7")))
+	// updates recovery may deliver the edit again
+	h.mustFeed(editChannelMessage(alertsSource.ChatID, 10, problemText("100", "cam-7")))
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: update 100 active",
+	)
+
+	revisions, err := h.handler.revisions.list(alertsSource.ChatID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("Got %d revisions, want 1: %+v", len(revisions), revisions)
+	}
+	changes := revisions[0].Changes
+	if len(changes) != 1 || changes[0].Field != "CameraID" || changes[0].Old != "cam-1" || changes[0].New != "cam-7" {
+		t.Fatalf("Revision changes are %+v, want camera cam-1 -> cam-7", changes)
+	}
+}
+
+func TestListRevisions(t *testing.T) {
+	h := newHarness(t, alertsSource, camerasSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		channelMessage(alertsSource.ChatID, 11, problemText("200", "cam-2")),
+		channelMessage(camerasSource.ChatID, 10, problemText("300", "cam-3")),
+	)
+	h.mustFeed(
+		editChannelMessage(alertsSource.ChatID, 11, problemText("200", "cam-5")),
+		editChannelMessage(alertsSource.ChatID, 10, problemText("100", "cam-7")),
+		editChannelMessage(camerasSource.ChatID, 10, problemText("300", "cam-9")),
+	)
+
+	db := h.handler.revisions.db
+
+	revisions, err := ListRevisions(db, alertsSource.ChatID, "")
+	if err != nil {
+		t.Fatalf("Failed list revisions: %s", err)
+	}
+	if len(revisions) != 2 || revisions[0].MessageID != 10 || revisions[1].MessageID != 11 || revisions[0].ChatID != alertsSource.ChatID {
+		t.Fatalf("Revisions of chat are %+v, want edits of messages 10 and 11", revisions)
+	}
+	if revisions[0].ProblemID != "100" || revisions[0].Message != problemText("100", "cam-7") || len(revisions[0].Changes) != 1 || revisions[0].Changes[0].New != "cam-7" {
+		t.Fatalf("Revision of message 10 is %+v, want camera of problem 100 changed to cam-7", revisions[0])
+	}
+
+	revisions, err = ListRevisions(db, 0, "300")
+	if err != nil {
+		t.Fatalf("Failed list revisions: %s", err)
+	}
+	if len(revisions) != 1 || revisions[0].ChatID != camerasSource.ChatID || revisions[0].MessageID != 10 {
+		t.Fatalf("Revisions of problem 300 are %+v, want edit of message 10 in cameras chat", revisions)
+	}
+}
+
+func TestPlaceholderEditedIntoAlertCreatesProblem(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, "Загрузка..."))
+	h.mustFeed(editChannelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+
+	h.assertCalls("alerts: create 100 active")
+}
+
+func TestEditedStartDoesNotReopenResolvedProblem(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		channelMessage(alertsSource.ChatID, 11, resolvedText("100", "cam-1")),
+	)
+	h.mustFeed(editChannelMessage(alertsSource.ChatID, 10, problemText("100", "cam-7")))
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: update 100 resolved",
+		"alerts: update 100 resolved",
+	)
+}
+
+func TestEditOfNotProcessedMessageIsLeftToMessage(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(editChannelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+
+	h.assertCalls("alerts: create 100 active")
+}
+
+func TestAlertDetailsAreCaptured(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, "Problem: С камеры cam-1 нет сигнала\nProblem started at 09:30:00 on 2026.10.18\nSeverity: High\nHost: cam-1.local\nOperational data:\nTags: scope:availability, camera\nOriginal problem ID: 100"),
+		// resolution without details keeps them
+		channelMessage(alertsSource.ChatID, 11, resolvedText("100", "cam-1")),
+	)
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: update 100 resolved",
+	)
+
+	problem, err := h.repos["alerts"].Get(context.Background(), "100")
+	if err != nil {
+		t.Fatalf("Failed get problem: %s", err)
+	}
+	if problem.Severity != "High" || problem.Host != "cam-1.local" || problem.OperationalData != "" || len(problem.Tags) != 2 || problem.Tags[0].Value != "availability" {
+		t.Fatalf("Problem is %+v, want details of start message", *problem)
+	}
+}
+
+func TestUpdateAcknowledgesProblem(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		channelMessage(alertsSource.ChatID, 11, updateText("100", "cam-1", "Admin", "acknowledged and commented", "restarting camera")),
+		// the same update forwarded again
+		channelMessage(alertsSource.ChatID, 12, updateText("100", "cam-1", "Admin", "acknowledged and commented", "restarting camera")),
+		channelMessage(alertsSource.ChatID, 13, resolvedText("100", "cam-1")),
+		// start of update is before the watched messages
+		channelMessage(alertsSource.ChatID, 14, updateText("101", "cam-2", "Admin", "commented", "")),
+	)
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: update 100 active",
+		"alerts: update 100 resolved",
+	)
+
+	problem, err := h.repos["alerts"].Get(context.Background(), "100")
+	if err != nil {
+		t.Fatalf("Failed get problem: %s", err)
+	}
+	if !problem.IsAcknowledged || problem.Acknowledger != "Admin" || len(problem.Comments) != 1 || problem.Comments[0].Message != "restarting camera" {
+		t.Fatalf("Problem is %+v, want acknowledged by Admin with one comment", *problem)
+	}
+}
+
+func TestUpdateChangesSeverity(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, "Problem: С камеры cam-1 нет сигнала\nProblem started at 09:30:00 on 2026.10.18\nSeverity: Warning\nOriginal problem ID: 100"),
+		channelMessage(alertsSource.ChatID, 11, updateText("100", "cam-1", "Admin", "acknowledged and changed severity from Warning to Not classified", "")),
+		channelMessage(alertsSource.ChatID, 12, resolvedText("100", "cam-1")),
+	)
+
+	problem, err := h.repos["alerts"].Get(context.Background(), "100")
+	if err != nil {
+		t.Fatalf("Failed get problem: %s", err)
+	}
+	if problem.Severity != "Not classified" || !problem.IsAcknowledged || !problem.IsResolved {
+		t.Fatalf("Problem is %+v, want resolved and acknowledged with severity changed to Not classified", *problem)
+	}
+}
+
+func TestUnacknowledgeUpdate(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		channelMessage(alertsSource.ChatID, 11, updateText("100", "cam-1", "Admin", "acknowledged", "")),
+		channelMessage(alertsSource.ChatID, 12, updateText("100", "cam-1", "Operator", "unacknowledged", "not mine")),
+	)
+
+	problem, err := h.repos["alerts"].Get(context.Background(), "100")
+	if err != nil {
+		t.Fatalf("Failed get problem: %s", err)
+	}
+	if problem.IsAcknowledged || problem.Acknowledger != "" || len(problem.Comments) != 2 {
+		t.Fatalf("Problem is %+v, want unacknowledged with two comments", *problem)
+	}
+}
+
+func TestGroupedAlertmanagerNotificationExpandsIntoProblems(t *testing.T) {
+	h := newHarnessWithConfig(t, &config.Config{TelegramDeletedMessages: config.DeletedMessagesWithdraw}, prometheusSource)
+
+	// fingerprints of alert labels, as alertmanager computes them
+	const cam1, cam2 = "0b54e657eb4628a3", "1bc4be1bcb73dbe4"
+
+	h.mustFeed(channelMessage(prometheusSource.ChatID, 10, "[FIRING:2] CameraDown\nAlerts Firing:\n"+alertmanagerAlert("cam-1")+"\n"+alertmanagerAlert("cam-2")))
+	h.repos["prometheus"].annotate(cam1, entity.AnnotationAssignee, "Иванов")
+	h.mustFeed(channelMessage(prometheusSource.ChatID, 11, "[FIRING:1] CameraDown\nAlerts Firing:\n"+alertmanagerAlert("cam-2")+"\nAlerts Resolved:\n"+alertmanagerAlert("cam-1")))
+	h.mustFeed(deleteChannelMessages(prometheusSource.ChatID, 10))
+
+	h.assertCalls(
+		"prometheus: create "+cam1+" active",
+		"prometheus: create "+cam2+" active",
+		// still firing alert is repeated
+		"prometheus: create "+cam2+" active",
+		"prometheus: update "+cam1+" resolved",
+		"prometheus: update "+cam1+" withdrawn",
+		"prometheus: update "+cam2+" withdrawn",
+	)
+	h.assertReplies("8008/11: Ответственный: Иванов")
+
+	problem, err := h.repos["prometheus"].Get(context.Background(), cam1)
+	if err != nil {
+		t.Fatalf("Failed get problem: %s", err)
+	}
+	started_at := time.Unix(1760779800+10, 0)
+	resolved_at := time.Unix(1760779800+11, 0)
+	if problem.CameraID != "cam-1" || problem.Description != "Camera cam-1 is down" || problem.Severity != "critical" ||
+		!problem.StartedAt.Equal(started_at) || problem.ResolvedAt == nil || !problem.ResolvedAt.Equal(resolved_at) {
+		t.Fatalf("Problem is %+v, want camera cam-1 started and resolved at times of its messages", *problem)
+	}
+}
+
+func TestAlertmanagerAlertFiringAgainReopensProblem(t *testing.T) {
+	h := newHarness(t, prometheusSource)
+
+	const cam1 = "0b54e657eb4628a3"
+
+	h.mustFeed(
+		channelMessage(prometheusSource.ChatID, 10, "[FIRING:1] CameraDown\nAlerts Firing:\n"+alertmanagerAlert("cam-1")),
+		channelMessage(prometheusSource.ChatID, 11, "[RESOLVED] CameraDown\nAlerts Resolved:\n"+alertmanagerAlert("cam-1")),
+		channelMessage(prometheusSource.ChatID, 12, "[FIRING:1] CameraDown\nAlerts Firing:\n"+alertmanagerAlert("cam-1")),
+	)
+
+	h.assertCalls(
+		"prometheus: create "+cam1+" active",
+		"prometheus: update "+cam1+" resolved",
+		"prometheus: create "+cam1+" active",
+		"prometheus: update "+cam1+" active",
+	)
+
+	problem, err := h.repos["prometheus"].Get(context.Background(), cam1)
+	if err != nil {
+		t.Fatalf("Failed get problem: %s", err)
+	}
+	if problem.IsResolved || problem.ResolvedAt != nil || !problem.StartedAt.Equal(time.Unix(1760779800+12, 0)) {
+		t.Fatalf("Problem is %+v, want active since the second firing", *problem)
+	}
+}
+
+func TestDeletedAlertIsIgnoredByDefault(t *testing.T) {
+	h := newHarness(t, alertsSource)
+
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	h.mustFeed(deleteChannelMessages(alertsSource.ChatID, 10))
+
+	h.assertCalls("alerts: create 100 active")
+}
+
+func TestDeletedAlertWithdrawsProblem(t *testing.T) {
+	h := newHarnessWithConfig(t, &config.Config{TelegramDeletedMessages: config.DeletedMessagesWithdraw}, alertsSource, camerasSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		channelMessage(alertsSource.ChatID, 11, problemText("101", "cam-1")),
+		chatMessage(camerasSource.ChatID, 20, problemText("200", "cam-2")),
+	)
+	h.mustFeed(
+		deleteChannelMessages(alertsSource.ChatID, 10, 12),
+		// ids of basic group messages are reported without chat
+		deleteMessages(20),
+		// message of other channel with the same id
+		deleteChannelMessages(3003, 11),
+	)
+	// delete is reported again after updates recovery
+	h.mustFeed(deleteChannelMessages(alertsSource.ChatID, 10))
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: create 101 active",
+		"cameras: create 200 active",
+		"alerts: update 100 withdrawn",
+		"cameras: update 200 withdrawn",
+	)
+}
+
+func TestDeletedAlertDeletesProblem(t *testing.T) {
+	h := newHarnessWithConfig(t, &config.Config{TelegramDeletedMessages: config.DeletedMessagesDelete}, alertsSource)
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		channelMessage(alertsSource.ChatID, 11, resolvedText("100", "cam-1")),
+	)
+	// deleted resolution does not make problem false positive
+	h.mustFeed(deleteChannelMessages(alertsSource.ChatID, 11))
+	h.mustFeed(deleteChannelMessages(alertsSource.ChatID, 10))
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: update 100 resolved",
+		"alerts: delete 100 resolved",
+	)
+}
+
+func TestDeletedPlaceholderEditedIntoAlertIsTraced(t *testing.T) {
+	h := newHarnessWithConfig(t, &config.Config{TelegramDeletedMessages: config.DeletedMessagesWithdraw}, alertsSource)
+
+	h.mustFeed(channelMessage(alertsSource.ChatID, 10, "Загрузка..."))
+	h.mustFeed(editChannelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")))
+	h.mustFeed(deleteChannelMessages(alertsSource.ChatID, 10))
+
+	h.assertCalls(
+		"alerts: create 100 active",
+		"alerts: update 100 withdrawn",
+	)
+}
+
+// TestRecordedFixtures replays every testdata/*.jsonl file recorded with
+// TelegramRecordUpdatesFile and compares repository calls and replies with
+// the .golden file next to it. Run with -update to rewrite golden files.
+func TestRecordedFixtures(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("No fixtures in testdata")
+	}
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".jsonl")
+
+		t.Run(name, func(t *testing.T) {
+			h := newHarness(t, alertsSource, camerasSource)
+			h.replay(fixture)
+
+			got := strings.Join(append(h.calls, h.replies...), "\n") + "\n"
+
+			golden := strings.TrimSuffix(fixture, ".jsonl") + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Fatalf("Replay of '%s' made\n%s\nwant\n%s", fixture, got, want)
+			}
+		})
+	}
+}
+
+func TestRecorderWritesReplayableFixtures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updates.jsonl")
+
+	r, err := newRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHarness(t, alertsSource)
+	h.handler.recorder = r
+
+	h.mustFeed(
+		channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1")),
+		channelMessage(3003, 11, problemText("900", "cam-9")),
+		channelMessage(alertsSource.ChatID, 12, resolvedText("100", "cam-1")),
+		deleteChannelMessages(alertsSource.ChatID, 10),
+		deleteChannelMessages(3003, 11),
+	)
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := readRecordedUpdates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 3 {
+		t.Fatalf("Recorded %d updates, want 3 of watched chat: %+v", len(recorded), recorded)
+	}
+
+	replayed := newHarness(t, alertsSource)
+	replayed.replay(path)
+
+	assertLines(t, "replayed calls", replayed.calls, h.calls)
+}
+
+func TestRecorderKeepsEntitiesOfUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updates.jsonl")
+
+	r, err := newRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHarness(t, alertsSource)
+	h.handler.recorder = r
+
+	channel := &tg.Channel{ID: alertsSource.ChatID, Title: "Alerts", Username: "alerts", Megagroup: true}
+	channel.SetAccessHash(42)
+	user := &tg.User{ID: 5005, Username: "zabbix", FirstName: "Zabbix", LastName: "Bot"}
+	user.SetAccessHash(43)
+
+	err = h.dispatcher.Handle(context.Background(), &tg.Updates{
+		Updates: []tg.UpdateClass{channelMessage(alertsSource.ChatID, 10, problemText("100", "cam-1"))},
+		Users:   []tg.UserClass{user},
+		Chats:   []tg.ChatClass{channel},
+	})
+	if err != nil {
+		t.Fatalf("Failed handle updates: %s", err)
+	}
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := readRecordedUpdates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 {
+		t.Fatalf("Recorded %d updates, want 1: %+v", len(recorded), recorded)
+	}
+
+	users, chats, err := recorded[0].toEntities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users, []tg.UserClass{user}) || !reflect.DeepEqual(chats, []tg.ChatClass{channel}) {
+		t.Fatalf("Restored entities are %+v %+v, want %+v %+v", users, chats, user, channel)
+	}
+}
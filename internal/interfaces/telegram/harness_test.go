@@ -0,0 +1,338 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
+
+	"github.com/gotd/td/tg"
+	"go.etcd.io/bbolt"
+)
+
+// fakeRepository keeps problems in memory and records calls, Create of
+// existing problem fails as in local store.
+type fakeRepository struct {
+	mu       sync.Mutex
+	name     string
+	calls    *[]string
+	problems map[string]*entity.Problem
+}
+
+func (r *fakeRepository) record(action string, problem *entity.Problem) {
+	status := "active"
+	if problem.IsResolved {
+		status = "resolved"
+	}
+	if problem.IsWithdrawn {
+		status = "withdrawn"
+	}
+	*r.calls = append(*r.calls, fmt.Sprintf("%s: %s %s %s", r.name, action, problem.ProblemID, status))
+}
+
+func (r *fakeRepository) Create(ctx context.Context, problem *entity.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("create", problem)
+
+	if _, ok := r.problems[problem.ProblemID]; ok {
+		return fmt.Errorf("Failed create problem '%s': %w", problem.ProblemID, repository.ErrAlreadyExists)
+	}
+	stored := *problem
+	r.problems[problem.ProblemID] = &stored
+	return nil
+}
+
+func (r *fakeRepository) Update(ctx context.Context, problem *entity.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("update", problem)
+
+	stored := *problem
+	if existing, ok := r.problems[problem.ProblemID]; ok {
+		stored.Annotations = existing.Annotations
+	}
+	r.problems[problem.ProblemID] = &stored
+	return nil
+}
+
+func (r *fakeRepository) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	problem, ok := r.problems[id]
+	if !ok {
+		return nil, fmt.Errorf("Failed get problem '%s': %w", id, repository.ErrNotFound)
+	}
+	found := *problem
+	return &found, nil
+}
+
+func (r *fakeRepository) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	problems := []*entity.Problem{}
+	for _, problem := range r.problems {
+		found := *problem
+		problems = append(problems, &found)
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].ProblemID < problems[j].ProblemID
+	})
+	return problems, nil
+}
+
+func (r *fakeRepository) Delete(ctx context.Context, problem *entity.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record("delete", problem)
+
+	if _, ok := r.problems[problem.ProblemID]; !ok {
+		return fmt.Errorf("Failed delete problem '%s': %w", problem.ProblemID, repository.ErrNotFound)
+	}
+	delete(r.problems, problem.ProblemID)
+	return nil
+}
+
+func (r *fakeRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// annotate sets annotation of stored problem as operator would fill it in the sheet.
+func (r *fakeRepository) annotate(id string, annotation string, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	problem, ok := r.problems[id]
+	if !ok {
+		problem = &entity.Problem{ProblemID: id}
+		r.problems[id] = problem
+	}
+	if problem.Annotations == nil {
+		problem.Annotations = map[string]string{}
+	}
+	problem.Annotations[annotation] = value
+}
+
+// harness feeds updates to handler through dispatcher, as the client does,
+// and records repository calls and replies of every source in order.
+type harness struct {
+	t          *testing.T
+	handler    *handler
+	dispatcher tg.UpdateDispatcher
+	repos      map[string]*fakeRepository
+	calls      []string
+	replies    []string
+}
+
+// Sources of harness, fixtures in testdata are recorded from these chats.
+var (
+	alertsSource  = config.TelegramSource{Name: "alerts", ChatID: 1001, Timezone: "UTC"}
+	camerasSource = config.TelegramSource{Name: "cameras", ChatID: 2002, Timezone: "UTC"}
+
+	prometheusSource = config.TelegramSource{
+		Name:            "prometheus",
+		ChatID:          8008,
+		Timezone:        "UTC",
+		ParserTemplates: []config.ParserTemplate{{Name: "alertmanager", Kind: config.ParserTemplateKindAlertmanager}},
+	}
+)
+
+func newHarness(t *testing.T, sources ...config.TelegramSource) *harness {
+	t.Helper()
+	return newHarnessWithConfig(t, &config.Config{}, sources...)
+}
+
+func newHarnessWithConfig(t *testing.T, cfg *config.Config, sources ...config.TelegramSource) *harness {
+	t.Helper()
+
+	boltdb, err := bbolt.Open(filepath.Join(t.TempDir(), "updates.bolt.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed open state db: %s", err)
+	}
+	t.Cleanup(func() { boltdb.Close() })
+
+	h := harness{
+		t:          t,
+		dispatcher: tg.NewUpdateDispatcher(),
+		repos:      map[string]*fakeRepository{},
+	}
+
+	var services []*ingest.Service
+	for _, source := range sources {
+		repo := &fakeRepository{name: source.Name, calls: &h.calls, problems: map[string]*entity.Problem{}}
+		h.repos[source.Name] = repo
+
+		service, err := ingest.New(cfg, source, repo, repo)
+		if err != nil {
+			t.Fatalf("Failed create service of source '%s': %s", source.Name, err)
+		}
+		services = append(services, service)
+	}
+
+	h.handler, err = newHandler(services, boltdb)
+	if err != nil {
+		t.Fatalf("Failed create handler: %s", err)
+	}
+
+	h.handler.reply = func(ctx context.Context, chat_id int64, message_id int, text string) error {
+		h.replies = append(h.replies, fmt.Sprintf("%d/%d: %s", chat_id, message_id, text))
+		return nil
+	}
+
+	h.handler.register(h.dispatcher)
+
+	return &h
+}
+
+// feed dispatches updates at once with entities of their peers.
+func (h *harness) feed(updates ...tg.UpdateClass) error {
+	batch := tg.Updates{Updates: updates}
+
+	for _, update := range updates {
+		message, ok := update.(interface{ GetMessage() tg.MessageClass })
+		if !ok {
+			continue
+		}
+		peer, ok := message.GetMessage().(interface{ GetPeerID() tg.PeerClass })
+		if !ok {
+			continue
+		}
+
+		switch p := peer.GetPeerID().(type) {
+		case *tg.PeerUser:
+			batch.Users = append(batch.Users, &tg.User{ID: p.UserID})
+		case *tg.PeerChat:
+			batch.Chats = append(batch.Chats, &tg.Chat{ID: p.ChatID})
+		case *tg.PeerChannel:
+			batch.Chats = append(batch.Chats, &tg.Channel{ID: p.ChannelID})
+		}
+	}
+
+	return h.dispatcher.Handle(context.Background(), &batch)
+}
+
+// feedRecorded dispatches recorded update with entities recorded with it.
+func (h *harness) feedRecorded(r *recordedUpdate, update tg.UpdateClass) error {
+	users, chats, err := r.toEntities()
+	if err != nil {
+		return err
+	}
+
+	return h.dispatcher.Handle(context.Background(), &tg.Updates{Updates: []tg.UpdateClass{update}, Users: users, Chats: chats})
+}
+
+// mustFeed feeds updates and fails test on error.
+func (h *harness) mustFeed(updates ...tg.UpdateClass) {
+	h.t.Helper()
+
+	if err := h.feed(updates...); err != nil {
+		h.t.Fatalf("Failed handle updates: %s", err)
+	}
+}
+
+// replay feeds recorded updates one by one, as they come from updates recovery.
+func (h *harness) replay(path string) {
+	h.t.Helper()
+
+	recorded, err := readRecordedUpdates(path)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	for i, r := range recorded {
+		update, err := r.toUpdate()
+		if err != nil {
+			h.t.Fatalf("Failed convert update %d of '%s': %s", i+1, path, err)
+		}
+
+		// fixtures recorded without entities get entities of their peers
+		if len(r.Entities) == 0 {
+			err = h.feed(update)
+		} else {
+			err = h.feedRecorded(&r, update)
+		}
+		if err != nil {
+			h.t.Fatalf("Failed handle update %d of '%s': %s", i+1, path, err)
+		}
+	}
+}
+
+func (h *harness) assertCalls(want ...string) {
+	h.t.Helper()
+	assertLines(h.t, "repository calls", h.calls, want)
+}
+
+func (h *harness) assertReplies(want ...string) {
+	h.t.Helper()
+	assertLines(h.t, "replies", h.replies, want)
+}
+
+func assertLines(t *testing.T, what string, got []string, want []string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("Got %d %s %q, want %q", len(got), what, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("Got %s %q, want %q", what, got, want)
+		}
+	}
+}
+
+func textMessage(peer tg.PeerClass, id int, text string) *tg.Message {
+	return &tg.Message{ID: id, PeerID: peer, Date: 1760779800 + id, Message: text}
+}
+
+func channelMessage(channel_id int64, id int, text string) *tg.UpdateNewChannelMessage {
+	return &tg.UpdateNewChannelMessage{Message: textMessage(&tg.PeerChannel{ChannelID: channel_id}, id, text)}
+}
+
+func chatMessage(chat_id int64, id int, text string) *tg.UpdateNewMessage {
+	return &tg.UpdateNewMessage{Message: textMessage(&tg.PeerChat{ChatID: chat_id}, id, text)}
+}
+
+func userMessage(user_id int64, id int, text string) *tg.UpdateNewMessage {
+	return &tg.UpdateNewMessage{Message: textMessage(&tg.PeerUser{UserID: user_id}, id, text)}
+}
+
+func editChannelMessage(channel_id int64, id int, text string) *tg.UpdateEditChannelMessage {
+	return &tg.UpdateEditChannelMessage{Message: textMessage(&tg.PeerChannel{ChannelID: channel_id}, id, text)}
+}
+
+func problemText(id string, camera string) string {
+	return fmt.Sprintf("Problem: С камеры %s нет сигнала\nProblem started at 09:30:00 on 2026.10.18\nOriginal problem ID: %s", camera, id)
+}
+
+func resolvedText(id string, camera string) string {
+	return fmt.Sprintf("Resolved in 15m 0s: С камеры %s нет сигнала\nProblem has been resolved in 15m 0s at 09:45:00 on 2026.10.18\nOriginal problem ID: %s", camera, id)
+}
+
+func updateText(id string, camera string, user string, action string, message string) string {
+	return fmt.Sprintf("Problem updated: С камеры %s нет сигнала\n%s %s problem at 2026.10.18 09:40:00.\nMessage: %s\nOriginal problem ID: %s", camera, user, action, message, id)
+}
+
+// alertmanagerAlert is alert of the default alertmanager telegram template.
+func alertmanagerAlert(camera string) string {
+	return fmt.Sprintf("Labels:\n - alertname = CameraDown\n - camera = %s\n - severity = critical\nAnnotations:\n - summary = Camera %s is down\nSource: http://prometheus:9090/graph", camera, camera)
+}
+
+func deleteChannelMessages(channel_id int64, ids ...int) *tg.UpdateDeleteChannelMessages {
+	return &tg.UpdateDeleteChannelMessages{ChannelID: channel_id, Messages: ids}
+}
+
+func deleteMessages(ids ...int) *tg.UpdateDeleteMessages {
+	return &tg.UpdateDeleteMessages{Messages: ids}
+}
@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+	"go.etcd.io/bbolt"
+)
+
+var messageProblemsBucket = []byte("message_problems")
+
+// commonBox is message box of users and basic groups, their message ids are
+// unique across all of them, while ids of channel messages are unique only
+// within channel. Deleted messages are reported with box but without chat.
+const commonBox = 0
+
+// messageBox returns box of messages of peer.
+func messageBox(peer tg.PeerClass) int64 {
+	if p, ok := peer.(*tg.PeerChannel); ok {
+		return p.ChannelID
+	}
+	return commonBox
+}
+
+// messageProblem is problems created by alert message, grouped notification
+// creates several of them. ChatID is configured chat id of the source.
+type messageProblem struct {
+	ChatID     int64    `json:"chat_id"`
+	ProblemIDs []string `json:"problem_ids"`
+}
+
+// messages maps alert messages to problems they created, keyed by message
+// box and message id, so deleted messages can be traced back to problems.
+type messages struct {
+	db *bbolt.DB
+}
+
+func newMessages(db *bbolt.DB) (*messages, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(messageProblemsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed create message problems: %s", err)
+	}
+	return &messages{db: db}, nil
+}
+
+func (m *messages) add(box int64, message_id int, problem messageProblem) error {
+	value, err := json.Marshal(problem)
+	if err != nil {
+		return fmt.Errorf("Failed encode problem of message %v: %s", message_id, err)
+	}
+
+	err = m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(messageProblemsBucket).Put(messageKey(box, message_id), value)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed save problem of message %v: %s", message_id, err)
+	}
+	return nil
+}
+
+// get returns problems of message, false if message did not create problems.
+func (m *messages) get(box int64, message_id int) (messageProblem, bool, error) {
+	var problem messageProblem
+	var ok bool
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(messageProblemsBucket).Get(messageKey(box, message_id))
+		if value == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(value, &problem)
+	})
+	if err != nil {
+		return messageProblem{}, false, fmt.Errorf("Failed read problem of message %v: %s", message_id, err)
+	}
+
+	return problem, ok, nil
+}
+
+func (m *messages) remove(box int64, message_id int) error {
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(messageProblemsBucket).Delete(messageKey(box, message_id))
+	})
+	if err != nil {
+		return fmt.Errorf("Failed remove problem of message %v: %s", message_id, err)
+	}
+	return nil
+}
+
+func messageKey(box int64, message_id int) []byte {
+	return append(encodeInt64(box), encodeInt64(int64(message_id))...)
+}
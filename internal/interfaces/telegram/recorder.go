@@ -0,0 +1,307 @@
+package telegram
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	updateNewMessage            = "new_message"
+	updateNewChannelMessage     = "new_channel_message"
+	updateEditMessage           = "edit_message"
+	updateEditChannelMessage    = "edit_channel_message"
+	updateDeleteMessages        = "delete_messages"
+	updateDeleteChannelMessages = "delete_channel_messages"
+
+	peerTypeUser    = "user"
+	peerTypeChat    = "chat"
+	peerTypeChannel = "channel"
+)
+
+// recordedUpdate is message update in JSON form, one per line of file.
+// Recorded files are replayed by tests as fixtures.
+type recordedUpdate struct {
+	Update    string `json:"update"`
+	PeerType  string `json:"peer_type"`
+	PeerID    int64  `json:"peer_id"`
+	MessageID int    `json:"message_id"`
+	Date      int    `json:"date,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// MigrateTo and MigrateFrom are set for service messages of basic group upgrade
+	MigrateTo   int64 `json:"migrate_to,omitempty"`
+	MigrateFrom int64 `json:"migrate_from,omitempty"`
+	// MessageIDs are set for deleted messages, peer is empty for messages
+	// of users and basic groups
+	MessageIDs []int `json:"message_ids,omitempty"`
+	// Entities are users and chats that came with the update
+	Entities []recordedEntity `json:"entities,omitempty"`
+}
+
+// recordedEntity is user, basic group or channel that came with update.
+type recordedEntity struct {
+	PeerType   string `json:"peer_type"`
+	PeerID     int64  `json:"peer_id"`
+	AccessHash int64  `json:"access_hash,omitempty"`
+	// Title is set for chats and channels, names are set for users
+	Title     string `json:"title,omitempty"`
+	Username  string `json:"username,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Megagroup bool   `json:"megagroup,omitempty"`
+}
+
+// recordEntities converts entities of update ordered by type and id, so
+// recorded lines do not depend on map order.
+func recordEntities(entities tg.Entities) []recordedEntity {
+	var recorded []recordedEntity
+
+	for _, user := range entities.Users {
+		recorded = append(recorded, recordedEntity{
+			PeerType:   peerTypeUser,
+			PeerID:     user.ID,
+			AccessHash: user.AccessHash,
+			Username:   user.Username,
+			FirstName:  user.FirstName,
+			LastName:   user.LastName,
+		})
+	}
+	for _, chat := range entities.Chats {
+		recorded = append(recorded, recordedEntity{PeerType: peerTypeChat, PeerID: chat.ID, Title: chat.Title})
+	}
+	for _, channel := range entities.Channels {
+		recorded = append(recorded, recordedEntity{
+			PeerType:   peerTypeChannel,
+			PeerID:     channel.ID,
+			AccessHash: channel.AccessHash,
+			Title:      channel.Title,
+			Username:   channel.Username,
+			Megagroup:  channel.Megagroup,
+		})
+	}
+
+	slices.SortFunc(recorded, func(a, b recordedEntity) int {
+		if a.PeerType != b.PeerType {
+			return cmp.Compare(a.PeerType, b.PeerType)
+		}
+		return cmp.Compare(a.PeerID, b.PeerID)
+	})
+
+	return recorded
+}
+
+// toEntities converts recorded entities back to users and chats of updates.
+func (r *recordedUpdate) toEntities() ([]tg.UserClass, []tg.ChatClass, error) {
+	var users []tg.UserClass
+	var chats []tg.ChatClass
+
+	for _, entity := range r.Entities {
+		switch entity.PeerType {
+		case peerTypeUser:
+			user := &tg.User{ID: entity.PeerID, Username: entity.Username, FirstName: entity.FirstName, LastName: entity.LastName}
+			user.SetAccessHash(entity.AccessHash)
+			users = append(users, user)
+		case peerTypeChat:
+			chats = append(chats, &tg.Chat{ID: entity.PeerID, Title: entity.Title})
+		case peerTypeChannel:
+			channel := &tg.Channel{ID: entity.PeerID, Title: entity.Title, Username: entity.Username, Megagroup: entity.Megagroup}
+			channel.SetAccessHash(entity.AccessHash)
+			chats = append(chats, channel)
+		default:
+			return nil, nil, fmt.Errorf("Invalid recorded update: entity peer type '%s'", entity.PeerType)
+		}
+	}
+
+	return users, chats, nil
+}
+
+// recordUpdate converts message update with its entities, false is returned
+// for other updates and messages that are neither text nor group upgrade.
+func recordUpdate(update tg.UpdateClass, entities tg.Entities) (recordedUpdate, bool) {
+	recorded := recordedUpdate{Entities: recordEntities(entities)}
+	var message tg.MessageClass
+
+	switch u := update.(type) {
+	case *tg.UpdateDeleteMessages:
+		recorded.Update, recorded.MessageIDs = updateDeleteMessages, u.Messages
+		return recorded, true
+	case *tg.UpdateDeleteChannelMessages:
+		recorded.Update, recorded.MessageIDs = updateDeleteChannelMessages, u.Messages
+		recorded.PeerType, recorded.PeerID = peerTypeChannel, u.ChannelID
+		return recorded, true
+	case *tg.UpdateNewMessage:
+		recorded.Update, message = updateNewMessage, u.Message
+	case *tg.UpdateNewChannelMessage:
+		recorded.Update, message = updateNewChannelMessage, u.Message
+	case *tg.UpdateEditMessage:
+		recorded.Update, message = updateEditMessage, u.Message
+	case *tg.UpdateEditChannelMessage:
+		recorded.Update, message = updateEditChannelMessage, u.Message
+	default:
+		return recorded, false
+	}
+
+	var peer tg.PeerClass
+
+	switch msg := message.(type) {
+	case *tg.Message:
+		peer = msg.PeerID
+		recorded.MessageID = msg.ID
+		recorded.Date = msg.Date
+		recorded.Message = msg.Message
+	case *tg.MessageService:
+		peer = msg.PeerID
+		recorded.MessageID = msg.ID
+		recorded.Date = msg.Date
+		switch action := msg.Action.(type) {
+		case *tg.MessageActionChatMigrateTo:
+			recorded.MigrateTo = action.ChannelID
+		case *tg.MessageActionChannelMigrateFrom:
+			recorded.MigrateFrom = action.ChatID
+		default:
+			return recorded, false
+		}
+	default:
+		return recorded, false
+	}
+
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		recorded.PeerType, recorded.PeerID = peerTypeUser, p.UserID
+	case *tg.PeerChat:
+		recorded.PeerType, recorded.PeerID = peerTypeChat, p.ChatID
+	case *tg.PeerChannel:
+		recorded.PeerType, recorded.PeerID = peerTypeChannel, p.ChannelID
+	default:
+		return recorded, false
+	}
+
+	return recorded, true
+}
+
+// toUpdate converts recorded update back to update of telegram.
+func (r *recordedUpdate) toUpdate() (tg.UpdateClass, error) {
+	switch r.Update {
+	case updateDeleteMessages:
+		return &tg.UpdateDeleteMessages{Messages: r.MessageIDs}, nil
+	case updateDeleteChannelMessages:
+		if r.PeerType != peerTypeChannel {
+			return nil, fmt.Errorf("Invalid recorded update: peer type '%s' of deleted channel messages", r.PeerType)
+		}
+		return &tg.UpdateDeleteChannelMessages{ChannelID: r.PeerID, Messages: r.MessageIDs}, nil
+	}
+
+	var peer tg.PeerClass
+	switch r.PeerType {
+	case peerTypeUser:
+		peer = &tg.PeerUser{UserID: r.PeerID}
+	case peerTypeChat:
+		peer = &tg.PeerChat{ChatID: r.PeerID}
+	case peerTypeChannel:
+		peer = &tg.PeerChannel{ChannelID: r.PeerID}
+	default:
+		return nil, fmt.Errorf("Invalid recorded update: peer type '%s'", r.PeerType)
+	}
+
+	var message tg.MessageClass
+	switch {
+	case r.MigrateTo != 0:
+		message = &tg.MessageService{ID: r.MessageID, PeerID: peer, Date: r.Date, Action: &tg.MessageActionChatMigrateTo{ChannelID: r.MigrateTo}}
+	case r.MigrateFrom != 0:
+		message = &tg.MessageService{ID: r.MessageID, PeerID: peer, Date: r.Date, Action: &tg.MessageActionChannelMigrateFrom{ChatID: r.MigrateFrom}}
+	default:
+		message = &tg.Message{ID: r.MessageID, PeerID: peer, Date: r.Date, Message: r.Message}
+	}
+
+	switch r.Update {
+	case updateNewMessage:
+		return &tg.UpdateNewMessage{Message: message}, nil
+	case updateNewChannelMessage:
+		return &tg.UpdateNewChannelMessage{Message: message}, nil
+	case updateEditMessage:
+		return &tg.UpdateEditMessage{Message: message}, nil
+	case updateEditChannelMessage:
+		return &tg.UpdateEditChannelMessage{Message: message}, nil
+	}
+
+	return nil, fmt.Errorf("Invalid recorded update: update '%s'", r.Update)
+}
+
+// readRecordedUpdates reads file written by recorder.
+func readRecordedUpdates(path string) ([]recordedUpdate, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed open recorded updates: %s", err)
+	}
+	defer file.Close()
+
+	var recorded []recordedUpdate
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		update := recordedUpdate{}
+		if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+			return nil, fmt.Errorf("Failed decode recorded update at line %d: %s", line, err)
+		}
+		recorded = append(recorded, update)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed read recorded updates: %s", err)
+	}
+
+	return recorded, nil
+}
+
+// recorder appends updates to file, failures are only logged.
+type recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newRecorder(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed open file of recorded updates: %s", err)
+	}
+	return &recorder{file: file}, nil
+}
+
+func (r *recorder) record(update tg.UpdateClass, entities tg.Entities) {
+	recorded, ok := recordUpdate(update, entities)
+	if !ok {
+		return
+	}
+
+	line, err := json.Marshal(recorded)
+	if err != nil {
+		log.Printf("Failed record update: %s", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.file.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("Failed record update: %s", err)
+	}
+}
+
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
@@ -0,0 +1,145 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
+
+	"go.etcd.io/bbolt"
+)
+
+var messageRevisionsBucket = []byte("message_revisions")
+
+// revision is edit of alert message, changes are empty if edit did not change problem.
+type revision struct {
+	ID        uint64          `json:"-"`
+	EditedAt  time.Time       `json:"edited_at"`
+	Message   string          `json:"message"`
+	ProblemID string          `json:"problem_id,omitempty"`
+	Changes   []ingest.Change `json:"changes,omitempty"`
+}
+
+// revisions keeps history of edits of every message, keyed by chat id,
+// message id and sequence, so edits of message are adjacent and ordered.
+type revisions struct {
+	db *bbolt.DB
+}
+
+func newRevisions(db *bbolt.DB) (*revisions, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(messageRevisionsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed create message revisions: %s", err)
+	}
+	return &revisions{db: db}, nil
+}
+
+func (r *revisions) add(chat_id int64, message_id int, rev revision) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(messageRevisionsBucket)
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+
+		key := append(revisionPrefix(chat_id, message_id), encodeInt64(int64(seq))...)
+		return bucket.Put(key, value)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed save revision of message %v in chat %v: %s", message_id, chat_id, err)
+	}
+	return nil
+}
+
+// list returns edits of message, oldest first.
+func (r *revisions) list(chat_id int64, message_id int) ([]revision, error) {
+	list := []revision{}
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		prefix := revisionPrefix(chat_id, message_id)
+		cursor := tx.Bucket(messageRevisionsBucket).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			rev := revision{}
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return err
+			}
+			rev.ID = uint64(decodeInt64(k[len(prefix):]))
+			list = append(list, rev)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed read revisions of message %v in chat %v: %s", message_id, chat_id, err)
+	}
+	return list, nil
+}
+
+// MessageRevision is saved edit of alert message in chat.
+type MessageRevision struct {
+	ChatID    int64
+	MessageID int
+	ID        uint64
+	EditedAt  time.Time
+	Message   string
+	// ProblemID and Changes are empty if edit did not change problem
+	ProblemID string
+	Changes   []ingest.Change
+}
+
+// ListRevisions reads edits of alert messages from state database, ordered
+// by chat, message and edit. Edits of every chat are returned if chat_id is
+// zero, and edits of every problem if problem_id is empty.
+func ListRevisions(db *bbolt.DB, chat_id int64, problem_id string) ([]MessageRevision, error) {
+	list := []MessageRevision{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(messageRevisionsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		var prefix []byte
+		if chat_id != 0 {
+			prefix = encodeInt64(chat_id)
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			rev := revision{}
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return err
+			}
+			if problem_id != "" && rev.ProblemID != problem_id {
+				continue
+			}
+
+			list = append(list, MessageRevision{
+				ChatID:    decodeInt64(k[:8]),
+				MessageID: int(decodeInt64(k[8:16])),
+				ID:        uint64(decodeInt64(k[16:])),
+				EditedAt:  rev.EditedAt,
+				Message:   rev.Message,
+				ProblemID: rev.ProblemID,
+				Changes:   rev.Changes,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed read message revisions: %s", err)
+	}
+	return list, nil
+}
+
+func revisionPrefix(chat_id int64, message_id int) []byte {
+	return append(encodeInt64(chat_id), encodeInt64(int64(message_id))...)
+}
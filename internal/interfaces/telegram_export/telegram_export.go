@@ -0,0 +1,112 @@
+package telegram_export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export is Telegram Desktop chat export (result.json) in JSON format.
+type Export struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	ID       int64     `json:"id"`
+	Messages []Message `json:"messages"`
+}
+
+type Message struct {
+	ID           int    `json:"id"`
+	Type         string `json:"type"`
+	DateUnixtime string `json:"date_unixtime"`
+	Date         string `json:"date"`
+	Text         Text   `json:"text"`
+}
+
+// Text is message text, export stores it either as plain string or as array
+// of strings and entities like {"type": "bold", "text": "..."}.
+type Text string
+
+func (t *Text) UnmarshalJSON(data []byte) error {
+	var plain string
+	if err := json.Unmarshal(data, &plain); err == nil {
+		*t = Text(plain)
+		return nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("text is neither string nor array: %s", err)
+	}
+
+	var text strings.Builder
+	for _, part := range parts {
+		var plain string
+		if err := json.Unmarshal(part, &plain); err == nil {
+			text.WriteString(plain)
+			continue
+		}
+
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &entity); err != nil {
+			return fmt.Errorf("text part is neither string nor entity: %s", err)
+		}
+		text.WriteString(entity.Text)
+	}
+
+	*t = Text(text.String())
+	return nil
+}
+
+// Time returns time message was sent at, export "date" field has no timezone
+// so unix time is preferred.
+func (m *Message) Time() time.Time {
+	var unixtime int64
+	if _, err := fmt.Sscan(m.DateUnixtime, &unixtime); err == nil {
+		return time.Unix(unixtime, 0)
+	}
+
+	date, err := time.ParseInLocation("2006-01-02T15:04:05", m.Date, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+func Read(path string) (*Export, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed read export file: %s", err)
+	}
+
+	export := Export{}
+
+	err = json.Unmarshal(data, &export)
+	if err != nil {
+		return nil, fmt.Errorf("Failed parse export file: %s", err)
+	}
+
+	return &export, nil
+}
+
+// TextMessages returns ordinary messages in chronological order,
+// service messages (joins, pins, migrations) are skipped.
+func (e *Export) TextMessages() []Message {
+	messages := make([]Message, 0, len(e.Messages))
+	for _, message := range e.Messages {
+		if message.Type != "message" || message.Text == "" {
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+
+	return messages
+}
@@ -0,0 +1,107 @@
+package telegram_export_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/interfaces/telegram_export"
+)
+
+// export is shortened result.json of Telegram Desktop with plain and
+// formatted texts, service message and message without text.
+const export = `{
+ "name": "Zabbix alerts",
+ "type": "private_supergroup",
+ "id": 1234567890,
+ "messages": [
+  {
+   "id": 12,
+   "type": "message",
+   "date": "2026-10-18T09:45:00",
+   "date_unixtime": "1792305900",
+   "from": "Zabbix",
+   "text": [
+    {"type": "bold", "text": "Resolved in 15m 0s:"},
+    " С камеры cam-1 нет сигнала\nOriginal problem ID: 100"
+   ]
+  },
+  {
+   "id": 10,
+   "type": "message",
+   "date": "2026-10-18T09:30:00",
+   "date_unixtime": "1792305000",
+   "from": "Zabbix",
+   "text": "Problem: С камеры cam-1 нет сигнала\nOriginal problem ID: 100"
+  },
+  {
+   "id": 11,
+   "type": "service",
+   "date": "2026-10-18T09:31:00",
+   "date_unixtime": "1792305060",
+   "action": "pin_message",
+   "text": ""
+  },
+  {
+   "id": 13,
+   "type": "message",
+   "date": "2026-10-18T09:50:00",
+   "photo": "photos/photo_1.jpg",
+   "text": ""
+  },
+  {
+   "id": 14,
+   "type": "message",
+   "date": "2026-10-18T09:55:00",
+   "text": "Коллеги, камера cam-1 снова в сети"
+  }
+ ]
+}`
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(path, []byte(export), 0600); err != nil {
+		t.Fatalf("Failed write export: %s", err)
+	}
+
+	e, err := telegram_export.Read(path)
+	if err != nil {
+		t.Fatalf("Failed read export: %s", err)
+	}
+	if e.Name != "Zabbix alerts" || e.ID != 1234567890 || len(e.Messages) != 5 {
+		t.Fatalf("Export is %s %d with %d messages, want 'Zabbix alerts' with 5 messages", e.Name, e.ID, len(e.Messages))
+	}
+
+	messages := e.TextMessages()
+
+	want := []struct {
+		id   int
+		text string
+		time time.Time
+	}{
+		{10, "Problem: С камеры cam-1 нет сигнала\nOriginal problem ID: 100", time.Unix(1792305000, 0)},
+		{12, "Resolved in 15m 0s: С камеры cam-1 нет сигнала\nOriginal problem ID: 100", time.Unix(1792305900, 0)},
+		// export of older Telegram Desktop has no unix time
+		{14, "Коллеги, камера cam-1 снова в сети", time.Date(2026, 10, 18, 9, 55, 0, 0, time.Local)},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("Text messages are %+v, want %d", messages, len(want))
+	}
+	for i, message := range messages {
+		if message.ID != want[i].id || string(message.Text) != want[i].text || !message.Time().Equal(want[i].time) {
+			t.Fatalf("Message %d is %d %q at %s, want %d %q at %s", i, message.ID, message.Text, message.Time(), want[i].id, want[i].text, want[i].time)
+		}
+	}
+}
+
+func TestReadInvalidText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(path, []byte(`{"messages": [{"id": 1, "type": "message", "text": 42}]}`), 0600); err != nil {
+		t.Fatalf("Failed write export: %s", err)
+	}
+
+	if _, err := telegram_export.Read(path); err == nil {
+		t.Fatalf("Export with numeric text is read")
+	}
+}
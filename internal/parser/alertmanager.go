@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+)
+
+// Rows of the default Alertmanager telegram template, e.g.
+//
+//	[FIRING:2] CameraDown
+//	Alerts Firing:
+//	Labels:
+//	 - alertname = CameraDown
+//	 - camera = cam-1
+//	Annotations:
+//	 - summary = Camera cam-1 is down
+//	Source: http://prometheus:9090/graph?g0.expr=...
+//
+// Subject row is optional, it is added by templates that include __subject.
+var (
+	alertmanagerSubject = regexp.MustCompile(`^\[(FIRING(?::\d+)?|RESOLVED)\](?: .*)?$`)
+	alertmanagerPair    = regexp.MustCompile(`^- (\S+) = (.*)$`)
+)
+
+const (
+	alertmanagerFiring      = "Alerts Firing:"
+	alertmanagerResolved    = "Alerts Resolved:"
+	alertmanagerLabels      = "Labels:"
+	alertmanagerAnnotations = "Annotations:"
+	alertmanagerSource      = "Source:"
+)
+
+// Labels and annotations of alert mapped to problem fields.
+const (
+	alertmanagerLabelAlertname   = "alertname"
+	alertmanagerLabelCamera      = "camera"
+	alertmanagerLabelSeverity    = "severity"
+	alertmanagerLabelInstance    = "instance"
+	alertmanagerLabelFingerprint = "fingerprint"
+	alertmanagerSummary          = "summary"
+	alertmanagerDescription      = "description"
+)
+
+type alertmanagerAlert struct {
+	resolved    bool
+	labels      map[string]string
+	annotations map[string]string
+}
+
+// parseAlertmanager expands notification into problem of every alert.
+// Messages carry no times, they are left zero for ingest to fill.
+func (t *template) parseAlertmanager(message string) ([]*entity.Problem, *ParseError) {
+	rows := strings.Split(message, "\n")
+
+	var alerts []*alertmanagerAlert
+	var alert *alertmanagerAlert
+	var pairs map[string]string
+	status := ""
+	recognized := false
+
+	for i, row := range rows {
+		row = strings.TrimSpace(row)
+
+		switch {
+		case row == "":
+			continue
+		case i == 0 && alertmanagerSubject.MatchString(row):
+			status = alertmanagerSubject.FindStringSubmatch(row)[1]
+		case row == alertmanagerFiring:
+			status = "FIRING"
+		case row == alertmanagerResolved:
+			status = "RESOLVED"
+		case row == alertmanagerLabels:
+			if status == "" {
+				return nil, t.error(i, "subject or alerts section before labels", row)
+			}
+			alert = &alertmanagerAlert{
+				resolved:    status == "RESOLVED",
+				labels:      map[string]string{},
+				annotations: map[string]string{},
+			}
+			alerts = append(alerts, alert)
+			pairs = alert.labels
+		case row == alertmanagerAnnotations && alert != nil:
+			pairs = alert.annotations
+		case strings.HasPrefix(row, alertmanagerSource) && alert != nil:
+			pairs = nil
+		case alertmanagerPair.MatchString(row) && pairs != nil:
+			match := alertmanagerPair.FindStringSubmatch(row)
+			pairs[match[1]] = match[2]
+		default:
+			if !recognized {
+				return nil, t.error(0, "alertmanager subject or alerts section", row)
+			}
+			return nil, t.error(i, "'- name = value' of labels or annotations", row)
+		}
+
+		recognized = true
+	}
+
+	if len(alerts) == 0 {
+		if !recognized {
+			return nil, t.error(0, "alertmanager subject or alerts section", "")
+		}
+		return nil, t.error(len(rows), "alert labels", "")
+	}
+
+	problems := make([]*entity.Problem, 0, len(alerts))
+	for i, alert := range alerts {
+		if len(alert.labels) == 0 {
+			return nil, t.error(len(rows)-1, fmt.Sprintf("labels of alert %d", i), "")
+		}
+		problems = append(problems, alert.problem())
+	}
+
+	return problems, nil
+}
+
+// problem maps alert to problem, ProblemID is fingerprint of labels, the
+// same as Alertmanager computes, unless template prints fingerprint itself.
+// Every label is kept as tag.
+func (a *alertmanagerAlert) problem() *entity.Problem {
+	problem := entity.Problem{
+		ProblemID:   a.labels[alertmanagerLabelFingerprint],
+		CameraID:    a.labels[alertmanagerLabelCamera],
+		Description: a.annotations[alertmanagerSummary],
+		IsResolved:  a.resolved,
+		Severity:    a.labels[alertmanagerLabelSeverity],
+		Host:        a.labels[alertmanagerLabelInstance],
+	}
+
+	if problem.ProblemID == "" {
+		problem.ProblemID = a.annotations[alertmanagerLabelFingerprint]
+	}
+	if problem.Description == "" {
+		problem.Description = a.annotations[alertmanagerDescription]
+	}
+	if problem.Description == "" {
+		problem.Description = a.labels[alertmanagerLabelAlertname]
+	}
+
+	names := make([]string, 0, len(a.labels))
+	for name := range a.labels {
+		if name != alertmanagerLabelFingerprint {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	hash := fnv.New64a()
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write([]byte{255})
+		hash.Write([]byte(a.labels[name]))
+		hash.Write([]byte{255})
+
+		problem.Tags = append(problem.Tags, entity.Tag{Name: name, Value: a.labels[name]})
+	}
+
+	if problem.ProblemID == "" {
+		problem.ProblemID = fmt.Sprintf("%016x", hash.Sum64())
+	}
+
+	return &problem
+}
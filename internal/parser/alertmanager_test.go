@@ -0,0 +1,204 @@
+package parser_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/parser"
+)
+
+func newAlertmanagerParser(t *testing.T) *parser.Parser {
+	t.Helper()
+
+	p, err := parser.New([]config.ParserTemplate{{Name: "alertmanager", Kind: config.ParserTemplateKindAlertmanager}})
+	if err != nil {
+		t.Fatalf("Failed create parser: %s", err)
+	}
+	return p
+}
+
+func TestAlertmanagerTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []entity.Problem
+	}{
+		{
+			name: "grouped firing",
+			message: "[FIRING:2] CameraDown\n" +
+				"Alerts Firing:\n" +
+				"Labels:\n" +
+				" - alertname = CameraDown\n" +
+				" - camera = cam-1\n" +
+				" - severity = critical\n" +
+				"Annotations:\n" +
+				" - summary = Camera cam-1 is down\n" +
+				"Source: http://prometheus:9090/graph?g0.expr=up\n" +
+				"Labels:\n" +
+				" - alertname = CameraDown\n" +
+				" - camera = cam-2\n" +
+				" - severity = critical\n" +
+				"Annotations:\n" +
+				" - description = Camera cam-2 does not answer ping\n" +
+				"Source: http://prometheus:9090/graph?g0.expr=up",
+			want: []entity.Problem{
+				{
+					ProblemID:   "0b54e657eb4628a3",
+					CameraID:    "cam-1",
+					Description: "Camera cam-1 is down",
+					Severity:    "critical",
+					Tags:        []entity.Tag{{Name: "alertname", Value: "CameraDown"}, {Name: "camera", Value: "cam-1"}, {Name: "severity", Value: "critical"}},
+				},
+				{
+					ProblemID:   "1bc4be1bcb73dbe4",
+					CameraID:    "cam-2",
+					Description: "Camera cam-2 does not answer ping",
+					Severity:    "critical",
+					Tags:        []entity.Tag{{Name: "alertname", Value: "CameraDown"}, {Name: "camera", Value: "cam-2"}, {Name: "severity", Value: "critical"}},
+				},
+			},
+		},
+		{
+			name: "firing and resolved without subject",
+			message: "Alerts Firing:\n" +
+				"Labels:\n" +
+				" - alertname = NodeDown\n" +
+				" - instance = nvr-1:9100\n" +
+				"Alerts Resolved:\n" +
+				"Labels:\n" +
+				" - alertname = CameraDown\n" +
+				" - camera = cam-1\n" +
+				" - severity = critical\n" +
+				"Annotations:\n" +
+				" - summary = Camera cam-1 is down",
+			want: []entity.Problem{
+				{
+					ProblemID:   "aceaea2cc49c14e5",
+					Description: "NodeDown",
+					Host:        "nvr-1:9100",
+					Tags:        []entity.Tag{{Name: "alertname", Value: "NodeDown"}, {Name: "instance", Value: "nvr-1:9100"}},
+				},
+				{
+					ProblemID:   "0b54e657eb4628a3",
+					CameraID:    "cam-1",
+					Description: "Camera cam-1 is down",
+					Severity:    "critical",
+					IsResolved:  true,
+					Tags:        []entity.Tag{{Name: "alertname", Value: "CameraDown"}, {Name: "camera", Value: "cam-1"}, {Name: "severity", Value: "critical"}},
+				},
+			},
+		},
+		{
+			name: "printed fingerprint",
+			message: "[RESOLVED] CameraDown\n" +
+				"Labels:\n" +
+				" - alertname = CameraDown\n" +
+				" - camera = cam-1\n" +
+				"Annotations:\n" +
+				" - fingerprint = 5f3c0e1d2a4b6c7d",
+			want: []entity.Problem{
+				{
+					ProblemID:   "5f3c0e1d2a4b6c7d",
+					CameraID:    "cam-1",
+					Description: "CameraDown",
+					IsResolved:  true,
+					Tags:        []entity.Tag{{Name: "alertname", Value: "CameraDown"}, {Name: "camera", Value: "cam-1"}},
+				},
+			},
+		},
+	}
+
+	p := newAlertmanagerParser(t)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := p.ParseProblemMessage(test.message, location)
+			if err != nil {
+				t.Fatalf("Failed parse: %s", err)
+			}
+
+			if result.Kind != config.ParserTemplateKindAlertmanager || result.Problem != result.Problems[0] {
+				t.Fatalf("Result is %s, want the first of alertmanager problems", result.Kind)
+			}
+			// indented label rows are the default layout
+			if len(result.Normalizations) != 0 {
+				t.Fatalf("Normalizations are %v, want none", result.Normalizations)
+			}
+
+			got := make([]entity.Problem, 0, len(result.Problems))
+			for _, problem := range result.Problems {
+				got = append(got, *problem)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("Problems are\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAlertmanagerParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		kind    parser.ErrorKind
+		row     int
+	}{
+		{
+			name:    "chat message",
+			message: "Коллеги, камера cam-1 снова в сети",
+			kind:    parser.ErrorKindNotAlert,
+		},
+		{
+			name:    "labels before status",
+			message: "Labels:\n - alertname = CameraDown",
+			kind:    parser.ErrorKindNotAlert,
+		},
+		{
+			name:    "unknown row",
+			message: "[FIRING:1] CameraDown\nLabels:\n - alertname = CameraDown\nGenerator: prometheus",
+			kind:    parser.ErrorKindMalformed,
+			row:     3,
+		},
+		{
+			name:    "no labels",
+			message: "[FIRING:1] CameraDown\nAlerts Firing:",
+			kind:    parser.ErrorKindMalformed,
+			row:     2,
+		},
+	}
+
+	p := newAlertmanagerParser(t)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := p.ParseProblemMessage(test.message, location)
+
+			var parse_err *parser.ParseError
+			if !errors.As(err, &parse_err) {
+				t.Fatalf("Parse returned %v, want *ParseError", err)
+			}
+			if parse_err.Kind != test.kind || parse_err.Row != test.row {
+				t.Fatalf("Error is %+v, want %s at row %d", *parse_err, test.kind, test.row)
+			}
+		})
+	}
+}
+
+func TestZabbixTemplatesReturnSingleProblem(t *testing.T) {
+	for _, text := range []string{
+		message(problemRow, startedRow, idRow),
+		message("Resolved in 5m 3s: С камеры cam-1 нет сигнала", resolvedRow, idRow),
+	} {
+		result, err := parser.ParseProblemMessage(text, location)
+		if err != nil {
+			t.Fatalf("Failed parse: %s", err)
+		}
+
+		if len(result.Problems) != 1 || result.Problem != result.Problems[0] {
+			t.Fatalf("Problems are %v, want single problem", result.Problems)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package parser
+
+import "fmt"
+
+type ErrorKind string
+
+const (
+	// ErrorKindNotAlert is ordinary chat message, nothing looks like an alert.
+	ErrorKindNotAlert ErrorKind = "not alert"
+	// ErrorKindMalformed is message with the first row of some template matched, but not the rest.
+	ErrorKindMalformed ErrorKind = "malformed alert"
+	// ErrorKindUnknownKind is message with alert rows, but no template recognizes its first row.
+	ErrorKindUnknownKind ErrorKind = "unknown alert kind"
+)
+
+// ParseError describes why message was not parsed, Template and Row point to the
+// closest template attempt, Row is zero based.
+type ParseError struct {
+	Kind     ErrorKind
+	Template string
+	Row      int
+	Expected string
+	Actual   string
+}
+
+func (e *ParseError) Error() string {
+	if e.Template == "" {
+		return fmt.Sprintf("%s: row %d '%s', expected %s", e.Kind, e.Row, e.Actual, e.Expected)
+	}
+	return fmt.Sprintf("%s: template '%s' row %d '%s', expected %s", e.Kind, e.Template, e.Row, e.Actual, e.Expected)
+}
@@ -0,0 +1,125 @@
+package parser_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/parser"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    parser.ParseError
+	}{
+		{
+			name:    "chat message",
+			message: "Коллеги, камера cam-1 снова в сети",
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindNotAlert,
+				Expected: "first row of any template",
+				Actual:   "Коллеги, камера cam-1 снова в сети",
+			},
+		},
+		{
+			name:    "empty message",
+			message: "",
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindNotAlert,
+				Expected: "first row of any template",
+			},
+		},
+		{
+			name:    "unknown first row",
+			message: message("Problem is back: С камеры cam-1 нет сигнала", idRow),
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindUnknownKind,
+				Expected: "first row of any template",
+				Actual:   "Problem is back: С камеры cam-1 нет сигнала",
+			},
+		},
+		{
+			name:    "changed row",
+			message: message(problemRow, "Started at 09:30:00 on 2026.10.18", idRow),
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindMalformed,
+				Template: "zabbix_problem",
+				Row:      1,
+				Expected: "'Problem started at (?P<StartedAt>.+)'",
+				Actual:   "Started at 09:30:00 on 2026.10.18",
+			},
+		},
+		{
+			name:    "missing row",
+			message: message(problemRow, startedRow),
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindMalformed,
+				Template: "zabbix_problem",
+				Row:      2,
+				Expected: "'Original problem ID: (?P<ProblemID>.+)'",
+			},
+		},
+		{
+			name:    "extra row",
+			message: message(problemRow, startedRow, idRow, "Event ID: 200"),
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindMalformed,
+				Template: "zabbix_problem",
+				Row:      3,
+				Expected: "end of message",
+				Actual:   "Event ID: 200",
+			},
+		},
+		{
+			name:    "invalid time",
+			message: message(problemRow, "Problem started at 2026-10-18 09:30", idRow),
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindMalformed,
+				Template: "zabbix_problem",
+				Row:      1,
+				Expected: "StartedAt in layout '15:04:05 on 2006.01.02'",
+				Actual:   "2026-10-18 09:30",
+			},
+		},
+		{
+			name:    "invalid duration",
+			message: message("Resolved in a while: С камеры cam-1 нет сигнала", "Problem has been resolved in a while at 09:35:03 on 2026.10.18", idRow),
+			want: parser.ParseError{
+				Kind:     parser.ErrorKindMalformed,
+				Template: "zabbix_resolved",
+				Row:      1,
+				Expected: "Duration like '1d 2h 3m 4s'",
+				Actual:   "a while",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parser.ParseProblemMessage(test.message, location)
+
+			var parse_err *parser.ParseError
+			if !errors.As(err, &parse_err) {
+				t.Fatalf("Parse returned %v, want *ParseError", err)
+			}
+			if *parse_err != test.want {
+				t.Fatalf("Error is\n%+v\nwant\n%+v", *parse_err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	err := &parser.ParseError{
+		Kind:     parser.ErrorKindMalformed,
+		Template: "zabbix_problem",
+		Row:      2,
+		Expected: "'Original problem ID: (?P<ProblemID>.+)'",
+	}
+
+	want := "malformed alert: template 'zabbix_problem' row 2 '', expected 'Original problem ID: (?P<ProblemID>.+)'"
+	if err.Error() != want {
+		t.Fatalf("Error is %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Normalization names a text fix that was needed to match a message.
+type Normalization string
+
+const (
+	NormalizationLineEndings Normalization = "line_endings"
+	NormalizationNbsp        Normalization = "nbsp"
+	NormalizationWhitespace  Normalization = "whitespace"
+	NormalizationHomoglyphs  Normalization = "homoglyphs"
+)
+
+// homoglyphs folds cyrillic letters to the latin letters that look the same.
+var homoglyphs = map[rune]rune{
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I', 'Ј': 'J',
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'ѕ': 's', 'і': 'i', 'ј': 'j',
+}
+
+func isNbsp(r rune) bool {
+	return r == '\u00a0' || r == '\u2007' || r == '\u202f' || isZeroWidth(r)
+}
+
+func isZeroWidth(r rune) bool {
+	return r == '\u200b' || r == '\u2060' || r == '\ufeff'
+}
+
+// normalizeWhitespace unifies line endings, replaces non-breaking spaces
+// and collapses repeated spaces in every row and empty rows around the message.
+func normalizeWhitespace(message string) (string, []Normalization) {
+	var normalizations []Normalization
+
+	if strings.Contains(message, "\r") {
+		message = strings.ReplaceAll(message, "\r\n", "\n")
+		message = strings.ReplaceAll(message, "\r", "\n")
+		normalizations = append(normalizations, NormalizationLineEndings)
+	}
+
+	if strings.IndexFunc(message, isNbsp) >= 0 {
+		message = strings.Map(func(r rune) rune {
+			if isZeroWidth(r) {
+				return -1
+			}
+			if isNbsp(r) {
+				return ' '
+			}
+			return r
+		}, message)
+		normalizations = append(normalizations, NormalizationNbsp)
+	}
+
+	rows := strings.Split(message, "\n")
+	changed := false
+	for i, row := range rows {
+		collapsed := strings.Join(strings.Fields(row), " ")
+		if collapsed != row {
+			rows[i] = collapsed
+			changed = true
+		}
+	}
+	joined := strings.Trim(strings.Join(rows, "\n"), "\n")
+	if changed || joined != message {
+		message = joined
+		normalizations = append(normalizations, NormalizationWhitespace)
+	}
+
+	return message, normalizations
+}
+
+// lookAlikes returns rune with the letters that look the same.
+func lookAlikes(r rune) []rune {
+	runes := []rune{r}
+	for cyrillic, latin := range homoglyphs {
+		switch r {
+		case cyrillic:
+			runes = append(runes, latin)
+		case latin:
+			runes = append(runes, cyrillic)
+		}
+	}
+	return runes
+}
+
+// foldPattern makes every letter of literal text in regexp match its
+// look-alike letters too, e.g. "Problem" matches "Рroblem" with cyrillic
+// "Р". Character classes are kept as is, so e.g. [а-я] still means cyrillic
+// letters only, and captures get the text of the row unchanged.
+func foldPattern(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var fold func(re *syntax.Regexp)
+	fold = func(re *syntax.Regexp) {
+		for _, sub := range re.Sub {
+			fold(sub)
+		}
+		if re.Op != syntax.OpLiteral {
+			return
+		}
+
+		var subs []*syntax.Regexp
+		for _, r := range re.Rune {
+			runes := lookAlikes(r)
+			if re.Flags&syntax.FoldCase != 0 {
+				for _, letter := range runes {
+					for folded := unicode.SimpleFold(letter); folded != letter; folded = unicode.SimpleFold(folded) {
+						runes = append(runes, folded)
+					}
+				}
+			}
+
+			if len(runes) == 1 {
+				subs = append(subs, &syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune{r}})
+				continue
+			}
+
+			slices.Sort(runes)
+			runes = slices.Compact(runes)
+			class := make([]rune, 0, 2*len(runes))
+			for _, letter := range runes {
+				class = append(class, letter, letter)
+			}
+			subs = append(subs, &syntax.Regexp{Op: syntax.OpCharClass, Rune: class})
+		}
+
+		*re = syntax.Regexp{Op: syntax.OpConcat, Sub: subs}
+	}
+	fold(re)
+
+	return re.String(), nil
+}
@@ -0,0 +1,131 @@
+package parser_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/parser"
+)
+
+func TestNormalizations(t *testing.T) {
+	tests := []struct {
+		name           string
+		message        string
+		template       string
+		cameraID       string
+		description    string
+		normalizations []parser.Normalization
+	}{
+		{
+			name:        "cyrillic С",
+			message:     message(problemRow, startedRow, idRow),
+			template:    "zabbix_problem",
+			cameraID:    "cam-1",
+			description: "нет сигнала",
+		},
+		{
+			name:           "latin C",
+			message:        message("Problem: C камеры cam-1 нет сигнала", startedRow, idRow),
+			template:       "zabbix_problem",
+			cameraID:       "cam-1",
+			description:    "нет сигнала",
+			normalizations: []parser.Normalization{parser.NormalizationHomoglyphs},
+		},
+		{
+			name:           "latin C of resolved",
+			message:        message("Resolved in 5m 3s: C камеры cam-1 нет сигнала", resolvedRow, idRow),
+			template:       "zabbix_resolved",
+			cameraID:       "cam-1",
+			description:    "нет сигнала",
+			normalizations: []parser.Normalization{parser.NormalizationHomoglyphs},
+		},
+		{
+			name:           "cyrillic letters in latin words",
+			message:        message("\u0420roblem: С камеры cam-1 нет сигнала", startedRow, "Original \u0440roblem ID: 100"),
+			template:       "zabbix_problem",
+			cameraID:       "cam-1",
+			description:    "нет сигнала",
+			normalizations: []parser.Normalization{parser.NormalizationHomoglyphs},
+		},
+		{
+			name:           "crlf line endings",
+			message:        strings.ReplaceAll(message(problemRow, startedRow, idRow, ""), "\n", "\r\n"),
+			template:       "zabbix_problem",
+			cameraID:       "cam-1",
+			description:    "нет сигнала",
+			normalizations: []parser.Normalization{parser.NormalizationLineEndings, parser.NormalizationWhitespace},
+		},
+		{
+			name:           "non-breaking and zero width spaces",
+			message:        message("Problem: С камеры\u00a0cam-1 нет\u202fсигнала", startedRow, "Original problem ID: \u200b100"),
+			template:       "zabbix_problem",
+			cameraID:       "cam-1",
+			description:    "нет сигнала",
+			normalizations: []parser.Normalization{parser.NormalizationNbsp},
+		},
+		{
+			name:           "repeated spaces and empty rows",
+			message:        message("", "Problem:  С камеры   cam-1 нет сигнала ", startedRow, idRow, "", ""),
+			template:       "zabbix_problem",
+			cameraID:       "cam-1",
+			description:    "нет сигнала",
+			normalizations: []parser.Normalization{parser.NormalizationWhitespace},
+		},
+		{
+			name:           "look-alike letters of camera id are kept",
+			message:        message("Problem: C камеры \u0441\u0430m-1 нет сигнала", startedRow, idRow),
+			template:       "zabbix_problem",
+			cameraID:       "\u0441\u0430m-1",
+			description:    "нет сигнала",
+			normalizations: []parser.Normalization{parser.NormalizationHomoglyphs},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parser.ParseProblemMessage(test.message, location)
+			if err != nil {
+				t.Fatalf("Failed parse: %s", err)
+			}
+
+			if result.Template != test.template {
+				t.Fatalf("Matched template '%s', want '%s'", result.Template, test.template)
+			}
+			if result.Problem.ProblemID != "100" || result.Problem.CameraID != test.cameraID || result.Problem.Description != test.description {
+				t.Fatalf("Problem is %+v, want problem 100 of camera '%s' with description '%s'", *result.Problem, test.cameraID, test.description)
+			}
+			if !reflect.DeepEqual(result.Normalizations, test.normalizations) {
+				t.Fatalf("Normalizations are %v, want %v", result.Normalizations, test.normalizations)
+			}
+		})
+	}
+}
+
+func TestFoldedTemplateKeepsCharacterClasses(t *testing.T) {
+	p, err := parser.New([]config.ParserTemplate{
+		{
+			Name:  "entrance",
+			Kind:  config.ParserTemplateKindProblem,
+			Lines: []string{`Камера (?P<ProblemID>\d+) (?P<CameraID>[а-я]+)`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed create parser: %s", err)
+	}
+
+	// latin "K" of the literal is folded
+	result, err := p.ParseProblemMessage("Kамера 100 подъезд", location)
+	if err != nil {
+		t.Fatalf("Failed parse: %s", err)
+	}
+	if result.Problem.CameraID != "подъезд" || !reflect.DeepEqual(result.Normalizations, []parser.Normalization{parser.NormalizationHomoglyphs}) {
+		t.Fatalf("Result is %+v %v, want camera 'подъезд' after homoglyphs", *result.Problem, result.Normalizations)
+	}
+
+	// cyrillic range does not match latin letters after folding
+	if _, err := p.ParseProblemMessage("Камера 100 abc", location); err == nil {
+		t.Fatalf("Latin camera id is matched by cyrillic range")
+	}
+}
@@ -1,197 +1,120 @@
 package parser
 
 import (
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
 	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
 )
 
-func ParseProblemMessage(message string, location *time.Location) (*entity.Problem, bool) {
-	problem, ok := tryParseProblemStarted(message, location)
-	if ok {
-		return problem, ok
-	}
-
-	problem, ok = tryParseProblemResolved(message, location)
-	if ok {
-		return problem, ok
-	}
-
-	return nil, false
+// ParseResult is problem parsed by template of Kind. Problem of update kind
+// has only identity fields and the single comment of the update.
+// Problems are every problem of grouped notification of alertmanager kind,
+// Problem is the first of them.
+type ParseResult struct {
+	Problem  *entity.Problem
+	Problems []*entity.Problem
+	Kind     string
+	Template string
+
+	// Normalizations lists text fixes that were needed to match the message,
+	// non empty list means the upstream template should be corrected.
+	Normalizations []Normalization
 }
 
-func tryParseProblemStarted(message string, location *time.Location) (*entity.Problem, bool) {
-	rows := strings.Split(message, "\n")
-	if len(rows) != 3 {
-		return nil, false
-	}
-
-	problem := entity.Problem{}
-
-	{
-		// try parse first row
-		// wait "Problem: С камеры <CameraID> <Description>"
-		// or "Problem: <Description>"
-
-		parts := strings.Split(rows[0], ": ")
-		if len(parts) != 2 || parts[0] != "Problem" {
-			return nil, false
-		}
-
-		small_parts := strings.Split(parts[1], " ")
-
-		if len(small_parts) >= 3 &&
-			small_parts[0] == "C" &&
-			small_parts[1] == "камеры" {
-			problem.CameraID = small_parts[2]
+type Parser struct {
+	templates []*template
+}
 
-			if len(small_parts) > 3 {
-				problem.Description = strings.Join(small_parts[3:], " ")
-			}
-		} else {
-			problem.Description = parts[1]
-		}
+// New compiles templates in the given order, the built-in templates are used if none are given.
+func New(templates []config.ParserTemplate) (*Parser, error) {
+	if len(templates) == 0 {
+		templates = DefaultTemplates()
 	}
 
-	{
-		// try parse second row
-		// wait "Problem started at 15:04:05 on 2006.01.02"
-
-		layout := "Problem started at 15:04:05 on 2006.01.02"
+	p := Parser{}
 
-		started_at, err := time.ParseInLocation(layout, rows[1], location)
+	for _, template_cfg := range templates {
+		template, err := compileTemplate(template_cfg)
 		if err != nil {
-			return nil, false
+			return nil, err
 		}
-
-		problem.StartedAt = started_at
+		p.templates = append(p.templates, template)
 	}
 
-	{
-		// try parse third row
-		// wait "Original problem ID: <ProblemID>"
-
-		parts := strings.Split(rows[2], ": ")
+	return &p, nil
+}
 
-		if len(parts) != 2 ||
-			parts[0] != "Original problem ID" {
-			return nil, false
-		}
+var defaultParser = mustNewDefault()
 
-		problem.ProblemID = parts[1]
+func mustNewDefault() *Parser {
+	p, err := New(nil)
+	if err != nil {
+		panic(err)
 	}
-
-	return &problem, true
+	return p
 }
 
-func tryParseProblemResolved(message string, location *time.Location) (*entity.Problem, bool) {
-	rows := strings.Split(message, "\n")
-	if len(rows) != 3 {
-		return nil, false
-	}
-
-	problem := entity.Problem{}
+// ParseProblemMessage parses message with the built-in templates.
+func ParseProblemMessage(message string, location *time.Location) (*ParseResult, error) {
+	return defaultParser.ParseProblemMessage(message, location)
+}
 
-	{
-		// try parse first row
-		// wait "Resolved in 366d 0h 0m 0s: С камеры <CameraID> <Description>"
-		// or "Resolved in 366d 0h 0m 0s: <Description>"
+// ParseProblemMessage tries templates in order and returns the problem from the first matched one,
+// otherwise *ParseError for the template that went furthest.
+func (p *Parser) ParseProblemMessage(message string, location *time.Location) (*ParseResult, error) {
+	message, normalizations := normalizeWhitespace(message)
+
+	var closest *ParseError
+
+	for _, template := range p.templates {
+		problems, template_normalizations, parse_err := template.parse(message, location)
+		if parse_err == nil {
+			found := normalizations
+			// default alertmanager layout indents label rows, they are
+			// trimmed by its parser, so it is not upstream template fault
+			if template.kind == config.ParserTemplateKindAlertmanager {
+				found = slices.DeleteFunc(slices.Clone(found), func(n Normalization) bool { return n == NormalizationWhitespace })
+			}
 
-		parts := strings.Split(rows[0], ": ")
-		if len(parts) != 2 || len(parts[0]) < len("Resolved in") || parts[0][:len("Resolved in")] != "Resolved in" {
-			return nil, false
+			return &ParseResult{
+				Problem:        problems[0],
+				Problems:       problems,
+				Kind:           template.kind,
+				Template:       template.name,
+				Normalizations: append(found, template_normalizations...),
+			}, nil
 		}
 
-		small_parts := strings.Split(parts[1], " ")
-
-		if len(small_parts) >= 3 &&
-			small_parts[0] == "C" &&
-			small_parts[1] == "камеры" {
-			problem.CameraID = small_parts[2]
-
-			if len(small_parts) > 3 {
-				problem.Description = strings.Join(small_parts[3:], " ")
-			}
-		} else {
-			problem.Description = parts[1]
+		if closest == nil || parse_err.Row > closest.Row {
+			closest = parse_err
 		}
 	}
 
-	{
-		// try parse second row
-		// wait "Problem has been resolved in 366d 0h 0m 0s at 15:04:05 on 2006.01.02"
-
-		parts := strings.Split(rows[1], " at ")
-
-		if len(parts) != 2 {
-			return nil, false
-		}
-
-		var resolved_in time.Duration
-
-		{
-			small_parts := strings.Split(parts[0], " in ")
-
-			if len(small_parts) != 2 {
-				return nil, false
-			}
-
-			small_small_parts := strings.Split(small_parts[1], "d ")
-
-			if len(small_small_parts) == 1 {
-				resolved_in_try, err := time.ParseDuration(strings.ReplaceAll(small_parts[1], " ", ""))
-				if err != nil {
-					return nil, false
-				}
-				resolved_in = resolved_in_try
-			} else if len(small_small_parts) == 2 {
-				resolved_in_try, err := time.ParseDuration(strings.ReplaceAll(small_small_parts[1], " ", ""))
-				if err != nil {
-					return nil, false
-				}
-				resolved_in = resolved_in_try
+	if closest != nil && closest.Kind == ErrorKindMalformed {
+		return nil, closest
+	}
 
-				day_count_try, err := strconv.Atoi(small_small_parts[0])
-				if err != nil {
-					return nil, false
+	rows := strings.Split(message, "\n")
+	for _, row := range rows {
+		for _, template := range p.templates {
+			if template.isAlertRow(row) {
+				return nil, &ParseError{
+					Kind:     ErrorKindUnknownKind,
+					Row:      0,
+					Expected: "first row of any template",
+					Actual:   rows[0],
 				}
-				day_count := day_count_try
-
-				resolved_in = resolved_in + 24*time.Hour*time.Duration(day_count)
-			} else {
-				return nil, false
 			}
 		}
-
-		layout := "15:04:05 on 2006.01.02"
-
-		problem.IsResolved = true
-
-		resolved_at, err := time.ParseInLocation(layout, parts[1], location)
-		if err != nil {
-			return nil, false
-		}
-
-		problem.ResolvedAt = &resolved_at
-
-		problem.StartedAt = problem.ResolvedAt.Add(-resolved_in)
 	}
 
-	{
-		// try parse third row
-		// wait "Original problem ID: <ProblemID>"
-
-		parts := strings.Split(rows[2], ": ")
-
-		if len(parts) != 2 ||
-			parts[0] != "Original problem ID" {
-			return nil, false
-		}
-
-		problem.ProblemID = parts[1]
+	return nil, &ParseError{
+		Kind:     ErrorKindNotAlert,
+		Row:      0,
+		Expected: "first row of any template",
+		Actual:   rows[0],
 	}
-
-	return &problem, true
 }
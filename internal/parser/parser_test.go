@@ -0,0 +1,297 @@
+package parser_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/parser"
+)
+
+var location = time.FixedZone("MSK", 3*60*60)
+
+func at(value string) time.Time {
+	t, err := time.ParseInLocation(time.DateTime, value, location)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func atPtr(value string) *time.Time {
+	t := at(value)
+	return &t
+}
+
+// Rows of the default zabbix layout, tests build messages of them and
+// replace the row they are about.
+const (
+	problemRow  = "Problem: С камеры cam-1 нет сигнала"
+	startedRow  = "Problem started at 09:30:00 on 2026.10.18"
+	resolvedRow = "Problem has been resolved in 5m 3s at 09:35:03 on 2026.10.18"
+	idRow       = "Original problem ID: 100"
+)
+
+func message(rows ...string) string {
+	return strings.Join(rows, "\n")
+}
+
+func TestDefaultTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    entity.Problem
+	}{
+		{
+			name:    "problem with camera",
+			message: message(problemRow, startedRow, idRow),
+			want: entity.Problem{
+				ProblemID:   "100",
+				CameraID:    "cam-1",
+				Description: "нет сигнала",
+				StartedAt:   at("2026-10-18 09:30:00"),
+			},
+		},
+		{
+			name:    "problem with camera only",
+			message: message("Problem: С камеры cam-1", startedRow, idRow),
+			want: entity.Problem{
+				ProblemID: "100",
+				CameraID:  "cam-1",
+				StartedAt: at("2026-10-18 09:30:00"),
+			},
+		},
+		{
+			name:    "problem without camera",
+			message: message("Problem: Disk is full on storage-1", "Problem started at 23:59:59 on 2026.12.31", "Original problem ID: 101"),
+			want: entity.Problem{
+				ProblemID:   "101",
+				Description: "Disk is full on storage-1",
+				StartedAt:   at("2026-12-31 23:59:59"),
+			},
+		},
+		{
+			name:    "resolved in seconds",
+			message: message("Resolved in 5m 3s: С камеры cam-1 нет сигнала", resolvedRow, idRow),
+			want: entity.Problem{
+				ProblemID:   "100",
+				CameraID:    "cam-1",
+				Description: "нет сигнала",
+				StartedAt:   at("2026-10-18 09:30:00"),
+				IsResolved:  true,
+				ResolvedAt:  atPtr("2026-10-18 09:35:03"),
+			},
+		},
+		{
+			name:    "resolved in days",
+			message: message("Resolved in 366d 0h 0m 0s: Disk is full", "Problem has been resolved in 366d 0h 0m 0s at 10:00:00 on 2026.10.18", "Original problem ID: 101"),
+			want: entity.Problem{
+				ProblemID:   "101",
+				Description: "Disk is full",
+				StartedAt:   at("2025-10-17 10:00:00"),
+				IsResolved:  true,
+				ResolvedAt:  atPtr("2026-10-18 10:00:00"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parser.ParseProblemMessage(test.message, location)
+			if err != nil {
+				t.Fatalf("Failed parse: %s", err)
+			}
+
+			if !reflect.DeepEqual(*result.Problem, test.want) {
+				t.Fatalf("Problem is\n%+v\nwant\n%+v", *result.Problem, test.want)
+			}
+		})
+	}
+}
+
+func TestDetailRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    entity.Problem
+	}{
+		{
+			name:    "problem with details",
+			message: message(problemRow, startedRow, "Severity: High", "Host: cam-1.local", "Host IP: 10.0.0.1", "Operational data:", "Tags: scope:availability, camera", idRow),
+			want: entity.Problem{
+				ProblemID:   "100",
+				CameraID:    "cam-1",
+				Description: "нет сигнала",
+				StartedAt:   at("2026-10-18 09:30:00"),
+				Severity:    "High",
+				Host:        "cam-1.local",
+				HostIP:      "10.0.0.1",
+				Tags:        []entity.Tag{{Name: "scope", Value: "availability"}, {Name: "camera"}},
+			},
+		},
+		{
+			name:    "problem with some details",
+			message: message(problemRow, startedRow, "Operational data: ping loss 100%", idRow),
+			want: entity.Problem{
+				ProblemID:       "100",
+				CameraID:        "cam-1",
+				Description:     "нет сигнала",
+				StartedAt:       at("2026-10-18 09:30:00"),
+				OperationalData: "ping loss 100%",
+			},
+		},
+		{
+			name:    "resolved with details",
+			message: message("Resolved in 5m 3s: С камеры cam-1 нет сигнала", resolvedRow, "Severity: Disaster", idRow),
+			want: entity.Problem{
+				ProblemID:   "100",
+				CameraID:    "cam-1",
+				Description: "нет сигнала",
+				StartedAt:   at("2026-10-18 09:30:00"),
+				IsResolved:  true,
+				ResolvedAt:  atPtr("2026-10-18 09:35:03"),
+				Severity:    "Disaster",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parser.ParseProblemMessage(test.message, location)
+			if err != nil {
+				t.Fatalf("Failed parse: %s", err)
+			}
+
+			if !reflect.DeepEqual(*result.Problem, test.want) {
+				t.Fatalf("Problem is\n%+v\nwant\n%+v", *result.Problem, test.want)
+			}
+		})
+	}
+}
+
+func TestConfiguredTemplates(t *testing.T) {
+	p, err := parser.New([]config.ParserTemplate{
+		{
+			Name:       "short",
+			Kind:       config.ParserTemplateKindProblem,
+			TimeLayout: time.DateTime,
+			Lines: []string{
+				`ALARM (?P<ProblemID>\d+) (?P<CameraID>\S+)`,
+				`since (?P<StartedAt>.+)`,
+			},
+		},
+		{
+			Name:       "short_resolved",
+			Kind:       config.ParserTemplateKindResolved,
+			TimeLayout: time.DateTime,
+			Lines: []string{
+				`OK (?P<ProblemID>\d+) (?P<CameraID>\S+)`,
+				`at (?P<ResolvedAt>.+) after (?P<Duration>.+)`,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed create parser: %s", err)
+	}
+
+	result, err := p.ParseProblemMessage("ALARM 100 cam-1\nsince 2026-10-18 09:30:00", location)
+	if err != nil {
+		t.Fatalf("Failed parse: %s", err)
+	}
+	want := entity.Problem{ProblemID: "100", CameraID: "cam-1", StartedAt: at("2026-10-18 09:30:00")}
+	if result.Template != "short" || result.Kind != config.ParserTemplateKindProblem || !reflect.DeepEqual(*result.Problem, want) {
+		t.Fatalf("Result is %s %+v, want short %+v", result.Template, *result.Problem, want)
+	}
+
+	result, err = p.ParseProblemMessage("OK 100 cam-1\nat 2026-10-18 09:45:00 after 15m 0s", location)
+	if err != nil {
+		t.Fatalf("Failed parse: %s", err)
+	}
+	want = entity.Problem{ProblemID: "100", CameraID: "cam-1", StartedAt: at("2026-10-18 09:30:00"), IsResolved: true, ResolvedAt: atPtr("2026-10-18 09:45:00")}
+	if result.Template != "short_resolved" || !reflect.DeepEqual(*result.Problem, want) {
+		t.Fatalf("Result is %s %+v, want short_resolved %+v", result.Template, *result.Problem, want)
+	}
+
+	// the default templates are not used when templates are configured
+	_, err = p.ParseProblemMessage(message(problemRow, startedRow, idRow), location)
+	if err == nil {
+		t.Fatalf("Default layout is parsed by configured templates")
+	}
+}
+
+func TestInvalidTemplates(t *testing.T) {
+	tests := map[string]config.ParserTemplate{
+		"invalid pattern": {Name: "broken", Kind: config.ParserTemplateKindProblem, Lines: []string{`Problem: (?P<ProblemID>`}},
+		"unknown capture": {Name: "broken", Kind: config.ParserTemplateKindProblem, Lines: []string{`Problem: (?P<Camera>.+)`}},
+		"no problem id":   {Name: "broken", Kind: config.ParserTemplateKindProblem, Lines: []string{`Problem: (?P<CameraID>.+)`}, OptionalLines: []string{`ID: (?P<ProblemID>.+)`}},
+	}
+
+	for name, template := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parser.New([]config.ParserTemplate{template})
+			if err == nil || !strings.Contains(err.Error(), "'broken'") {
+				t.Fatalf("New returned %v, want error naming template 'broken'", err)
+			}
+		})
+	}
+}
+
+func TestUpdateTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    entity.Comment
+	}{
+		{
+			name:    "acknowledged and commented",
+			message: "Problem updated: С камеры cam-1 нет сигнала\nAdmin (Zabbix Administrator) acknowledged and commented problem at 2026.10.18 09:40:00.\nMessage: restarting camera\nOriginal problem ID: 100",
+			want: entity.Comment{
+				Author:          "Admin (Zabbix Administrator)",
+				Action:          "acknowledged and commented",
+				Message:         "restarting camera",
+				Acknowledgement: entity.Acknowledged,
+				CreatedAt:       at("2026-10-18 09:40:00"),
+			},
+		},
+		{
+			name:    "unacknowledged without message",
+			message: "Problem updated: С камеры cam-1 нет сигнала\nOperator unacknowledged problem at 2026.10.18 09:45:00\nMessage:\nOriginal problem ID: 100",
+			want: entity.Comment{
+				Author:          "Operator",
+				Action:          "unacknowledged",
+				Acknowledgement: entity.Unacknowledged,
+				CreatedAt:       at("2026-10-18 09:45:00"),
+			},
+		},
+		{
+			name:    "changed severity",
+			message: "Problem updated: С камеры cam-1 нет сигнала\nAdmin changed severity from Warning to Not classified and commented problem at 2026.10.18 09:50:00.\nMessage: false positive\nOriginal problem ID: 100",
+			want: entity.Comment{
+				Author:    "Admin",
+				Action:    "changed severity from Warning to Not classified and commented",
+				Message:   "false positive",
+				Severity:  "Not classified",
+				CreatedAt: at("2026-10-18 09:50:00"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parser.ParseProblemMessage(test.message, location)
+			if err != nil {
+				t.Fatalf("Failed parse: %s", err)
+			}
+
+			if result.Kind != config.ParserTemplateKindUpdate || result.Problem.ProblemID != "100" || result.Problem.CameraID != "cam-1" {
+				t.Fatalf("Result is %s %+v, want update of problem 100", result.Kind, *result.Problem)
+			}
+			if len(result.Problem.Comments) != 1 || !reflect.DeepEqual(result.Problem.Comments[0], test.want) {
+				t.Fatalf("Comments are %+v, want %+v", result.Problem.Comments, test.want)
+			}
+		})
+	}
+}
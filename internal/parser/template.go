@@ -0,0 +1,426 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+)
+
+// Named captures that can be used in template lines.
+const (
+	CaptureProblemID   = "ProblemID"
+	CaptureCameraID    = "CameraID"
+	CaptureDescription = "Description"
+	CaptureStartedAt   = "StartedAt"
+	CaptureResolvedAt  = "ResolvedAt"
+	CaptureDuration    = "Duration"
+
+	// optional details of alert
+	CaptureSeverity        = "Severity"
+	CaptureHost            = "Host"
+	CaptureHostIP          = "HostIP"
+	CaptureOperationalData = "OperationalData"
+	CaptureTags            = "Tags"
+
+	// captures of update templates
+	CaptureUpdatedBy     = "UpdatedBy"
+	CaptureUpdateAction  = "UpdateAction"
+	CaptureUpdateMessage = "UpdateMessage"
+	CaptureUpdatedAt     = "UpdatedAt"
+)
+
+var knownCaptures = map[string]bool{
+	CaptureProblemID:   true,
+	CaptureCameraID:    true,
+	CaptureDescription: true,
+	CaptureStartedAt:   true,
+	CaptureResolvedAt:  true,
+	CaptureDuration:    true,
+
+	CaptureSeverity:        true,
+	CaptureHost:            true,
+	CaptureHostIP:          true,
+	CaptureOperationalData: true,
+	CaptureTags:            true,
+
+	CaptureUpdatedBy:     true,
+	CaptureUpdateAction:  true,
+	CaptureUpdateMessage: true,
+	CaptureUpdatedAt:     true,
+}
+
+const defaultTimeLayout = "15:04:05 on 2006.01.02"
+
+// updateTimeLayout is layout of {EVENT.UPDATE.DATE} {EVENT.UPDATE.TIME} macros.
+const updateTimeLayout = "2006.01.02 15:04:05"
+
+// DefaultTemplates returns the built-in Zabbix layouts, used when config has no templates.
+func DefaultTemplates() []config.ParserTemplate {
+	// "С" is cyrillic, rows with latin "C" are matched by homoglyph folding
+	description := `(?:С камеры (?P<CameraID>\S+)(?: (?P<Description>.*))?|(?P<Description>.*))`
+
+	// rows of {EVENT.SEVERITY}, {HOST.NAME}, {HOST.IP}, {EVENT.OPDATA} and
+	// {EVENT.TAGS} macros, if media type message has them
+	details := []string{
+		`Severity: (?P<Severity>.+)`,
+		`Host: (?P<Host>.+)`,
+		`Host IP: (?P<HostIP>.+)`,
+		`Operational data:(?: (?P<OperationalData>.*))?`,
+		`Tags:(?: (?P<Tags>.*))?`,
+	}
+
+	return []config.ParserTemplate{
+		{
+			Name:       "zabbix_problem",
+			Kind:       config.ParserTemplateKindProblem,
+			TimeLayout: defaultTimeLayout,
+			Lines: []string{
+				`Problem: ` + description,
+				`Problem started at (?P<StartedAt>.+)`,
+				`Original problem ID: (?P<ProblemID>.+)`,
+			},
+			OptionalLines: details,
+		},
+		{
+			Name:       "zabbix_resolved",
+			Kind:       config.ParserTemplateKindResolved,
+			TimeLayout: defaultTimeLayout,
+			Lines: []string{
+				`Resolved in [^:]+: ` + description,
+				`Problem has been resolved in (?P<Duration>.+?) at (?P<ResolvedAt>.+)`,
+				`Original problem ID: (?P<ProblemID>.+)`,
+			},
+			OptionalLines: details,
+		},
+		{
+			Name:       "zabbix_update",
+			Kind:       config.ParserTemplateKindUpdate,
+			TimeLayout: updateTimeLayout,
+			Lines: []string{
+				`Problem updated: ` + description,
+				// action is e.g. "acknowledged and commented" or "changed severity from Warning to High"
+				`(?P<UpdatedBy>.+?) (?P<UpdateAction>(?:acknowledged|unacknowledged|commented|changed|closed|suppressed|unsuppressed).*?) problem at (?P<UpdatedAt>.+?)\.?`,
+				`Message:(?: (?P<UpdateMessage>.*))?`,
+				`Original problem ID: (?P<ProblemID>.+)`,
+			},
+		},
+	}
+}
+
+// severityChange captures the new severity of update action, e.g.
+// "acknowledged and changed severity from Warning to High".
+var severityChange = regexp.MustCompile(`changed severity from .+? to (.+?)(?: and |$)`)
+
+type template struct {
+	name       string
+	kind       string
+	timeLayout string
+	sources    []string
+	lines      []*regexp.Regexp
+	folded     []*regexp.Regexp
+	optional   []*regexp.Regexp
+	// optionalFolded are folded patterns of optional lines
+	optionalFolded []*regexp.Regexp
+}
+
+func compileTemplate(cfg config.ParserTemplate) (*template, error) {
+	t := template{
+		name:       cfg.Name,
+		kind:       cfg.Kind,
+		timeLayout: cfg.TimeLayout,
+	}
+
+	if t.timeLayout == "" {
+		t.timeLayout = defaultTimeLayout
+	}
+
+	for i, line := range cfg.Lines {
+		re, folded_re, err := compileLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Failed compile line %d of template '%s': %s", i, cfg.Name, err)
+		}
+
+		t.sources = append(t.sources, line)
+		t.lines = append(t.lines, re)
+		t.folded = append(t.folded, folded_re)
+	}
+
+	// optional lines may be absent, so problem id must be in lines
+	if cfg.Kind != config.ParserTemplateKindAlertmanager && !slices.ContainsFunc(t.lines, func(re *regexp.Regexp) bool {
+		return slices.Contains(re.SubexpNames(), CaptureProblemID)
+	}) {
+		return nil, fmt.Errorf("Template '%s' has no %s capture in its lines", cfg.Name, CaptureProblemID)
+	}
+
+	for i, line := range cfg.OptionalLines {
+		re, folded_re, err := compileLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Failed compile optional line %d of template '%s': %s", i, cfg.Name, err)
+		}
+
+		t.optional = append(t.optional, re)
+		t.optionalFolded = append(t.optionalFolded, folded_re)
+	}
+
+	return &t, nil
+}
+
+// compileLine compiles line matched against the whole row and its fallback
+// for rows typed with look-alike letters.
+func compileLine(line string) (*regexp.Regexp, *regexp.Regexp, error) {
+	re, err := regexp.Compile("^(?:" + line + ")$")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range re.SubexpNames() {
+		if name != "" && !knownCaptures[name] {
+			return nil, nil, fmt.Errorf("unknown capture '%s'", name)
+		}
+	}
+
+	folded_line, err := foldPattern(line)
+	if err != nil {
+		return nil, nil, err
+	}
+	folded_re, err := regexp.Compile("^(?:" + folded_line + ")$")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return re, folded_re, nil
+}
+
+// parse matches rows with lines in order, rows matching optional lines may
+// appear anywhere between the first and the last line. Alertmanager template
+// has no lines, it may return several problems.
+func (t *template) parse(message string, location *time.Location) ([]*entity.Problem, []Normalization, *ParseError) {
+	if t.kind == config.ParserTemplateKindAlertmanager {
+		problems, parse_err := t.parseAlertmanager(message)
+		return problems, nil, parse_err
+	}
+
+	rows := strings.Split(message, "\n")
+
+	captures := map[string]string{}
+	capture_rows := map[string]int{}
+	var normalizations []Normalization
+
+	line := 0
+	for i, row := range rows {
+		if line >= len(t.lines) {
+			return nil, nil, t.error(i, "end of message", row)
+		}
+
+		re := t.lines[line]
+		match, folded := matchRow(re, t.folded[line], row)
+
+		optional := false
+		for j := 0; match == nil && line > 0 && j < len(t.optional); j++ {
+			re = t.optional[j]
+			match, folded = matchRow(re, t.optionalFolded[j], row)
+			optional = match != nil
+		}
+
+		if match == nil {
+			return nil, nil, t.error(i, fmt.Sprintf("'%s'", t.sources[line]), row)
+		}
+
+		if folded && !slices.Contains(normalizations, NormalizationHomoglyphs) {
+			normalizations = append(normalizations, NormalizationHomoglyphs)
+		}
+
+		// the same name may be used in several alternatives,
+		// take the first one that participated in the match
+		seen := map[string]bool{}
+		for j, name := range re.SubexpNames() {
+			if name == "" || seen[name] || match[2*j] < 0 {
+				continue
+			}
+			seen[name] = true
+			captures[name] = row[match[2*j]:match[2*j+1]]
+			capture_rows[name] = i
+		}
+
+		if !optional {
+			line++
+		}
+	}
+
+	if line < len(t.lines) {
+		return nil, nil, t.error(len(rows), fmt.Sprintf("'%s'", t.sources[line]), "")
+	}
+
+	problem, parse_err := t.buildProblem(captures, capture_rows, location)
+	if parse_err != nil {
+		return nil, nil, parse_err
+	}
+
+	return []*entity.Problem{problem}, normalizations, nil
+}
+
+// matchRow prefers the folded match, raw one may fall into a less specific
+// alternative, e.g. description without camera id. Reports whether the
+// folded match was taken.
+func matchRow(re *regexp.Regexp, folded_re *regexp.Regexp, row string) ([]int, bool) {
+	match := re.FindStringSubmatchIndex(row)
+
+	folded_match := folded_re.FindStringSubmatchIndex(row)
+	if folded_match != nil && !slices.Equal(match, folded_match) {
+		return folded_match, true
+	}
+
+	return match, false
+}
+
+// error classifies failure by the failed row, the first row is the alert kind
+// header, so any later failure means alert of this kind is malformed.
+func (t *template) error(row int, expected string, actual string) *ParseError {
+	kind := ErrorKindMalformed
+	if row == 0 {
+		kind = ErrorKindNotAlert
+	}
+
+	return &ParseError{
+		Kind:     kind,
+		Template: t.name,
+		Row:      row,
+		Expected: expected,
+		Actual:   actual,
+	}
+}
+
+func (t *template) buildProblem(captures map[string]string, capture_rows map[string]int, location *time.Location) (*entity.Problem, *ParseError) {
+	problem := entity.Problem{
+		ProblemID:   captures[CaptureProblemID],
+		CameraID:    captures[CaptureCameraID],
+		Description: captures[CaptureDescription],
+		IsResolved:  t.kind == config.ParserTemplateKindResolved,
+
+		Severity:        captures[CaptureSeverity],
+		Host:            captures[CaptureHost],
+		HostIP:          captures[CaptureHostIP],
+		OperationalData: captures[CaptureOperationalData],
+		Tags:            entity.ParseTags(captures[CaptureTags]),
+	}
+
+	if problem.ProblemID == "" {
+		return nil, t.error(capture_rows[CaptureProblemID], "non empty "+CaptureProblemID, "")
+	}
+
+	if value, ok := captures[CaptureResolvedAt]; ok {
+		resolved_at, err := time.ParseInLocation(t.timeLayout, value, location)
+		if err != nil {
+			return nil, t.error(capture_rows[CaptureResolvedAt], fmt.Sprintf("%s in layout '%s'", CaptureResolvedAt, t.timeLayout), value)
+		}
+		problem.ResolvedAt = &resolved_at
+	}
+
+	if value, ok := captures[CaptureStartedAt]; ok {
+		started_at, err := time.ParseInLocation(t.timeLayout, value, location)
+		if err != nil {
+			return nil, t.error(capture_rows[CaptureStartedAt], fmt.Sprintf("%s in layout '%s'", CaptureStartedAt, t.timeLayout), value)
+		}
+		problem.StartedAt = started_at
+	} else if value, ok := captures[CaptureDuration]; ok && problem.ResolvedAt != nil {
+		resolved_in, err := parseDuration(value)
+		if err != nil {
+			return nil, t.error(capture_rows[CaptureDuration], fmt.Sprintf("%s like '1d 2h 3m 4s'", CaptureDuration), value)
+		}
+		problem.StartedAt = problem.ResolvedAt.Add(-resolved_in)
+	}
+
+	if problem.IsResolved && problem.ResolvedAt == nil {
+		return nil, t.error(len(t.lines)-1, CaptureResolvedAt+" capture in resolved template", "")
+	}
+
+	if t.kind == config.ParserTemplateKindUpdate {
+		comment, parse_err := t.buildComment(captures, capture_rows, location)
+		if parse_err != nil {
+			return nil, parse_err
+		}
+		problem.Comments = []entity.Comment{*comment}
+	}
+
+	return &problem, nil
+}
+
+func (t *template) buildComment(captures map[string]string, capture_rows map[string]int, location *time.Location) (*entity.Comment, *ParseError) {
+	comment := entity.Comment{
+		Author:  captures[CaptureUpdatedBy],
+		Action:  captures[CaptureUpdateAction],
+		Message: captures[CaptureUpdateMessage],
+	}
+
+	value, ok := captures[CaptureUpdatedAt]
+	if !ok {
+		return nil, t.error(len(t.lines)-1, CaptureUpdatedAt+" capture in update template", "")
+	}
+	updated_at, err := time.ParseInLocation(t.timeLayout, value, location)
+	if err != nil {
+		return nil, t.error(capture_rows[CaptureUpdatedAt], fmt.Sprintf("%s in layout '%s'", CaptureUpdatedAt, t.timeLayout), value)
+	}
+	comment.CreatedAt = updated_at
+
+	// "unacknowledged" contains "acknowledged", so it is checked first
+	switch {
+	case strings.Contains(comment.Action, entity.Unacknowledged):
+		comment.Acknowledgement = entity.Unacknowledged
+	case strings.Contains(comment.Action, entity.Acknowledged):
+		comment.Acknowledgement = entity.Acknowledged
+	}
+
+	if match := severityChange.FindStringSubmatch(comment.Action); match != nil {
+		comment.Severity = match[1]
+	}
+
+	return &comment, nil
+}
+
+// isAlertRow reports whether row matches any non header line with a literal
+// prefix, e.g. "Original problem ID: ...".
+func (t *template) isAlertRow(row string) bool {
+	for i := 1; i < len(t.lines); i++ {
+		if prefix, _ := t.lines[i].LiteralPrefix(); prefix == "" {
+			continue
+		}
+		if t.lines[i].MatchString(row) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDuration parses zabbix durations like "366d 0h 0m 0s" or "5m 3s".
+func parseDuration(value string) (time.Duration, error) {
+	var days int
+
+	parts := strings.SplitN(value, "d", 2)
+	if len(parts) == 2 {
+		day_count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return 0, err
+		}
+		days = day_count
+		value = parts[1]
+	}
+
+	var duration time.Duration
+
+	value = strings.ReplaceAll(value, " ", "")
+	if value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+		duration = parsed
+	}
+
+	return duration + 24*time.Hour*time.Duration(days), nil
+}
@@ -0,0 +1,177 @@
+package fanout
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+)
+
+type Policy string
+
+const (
+	// PolicyRequired sink failure fails the write.
+	PolicyRequired Policy = "required"
+	// PolicyBestEffort sink failure is only logged.
+	PolicyBestEffort Policy = "best_effort"
+)
+
+type Sink struct {
+	Name   string
+	Policy Policy
+	Repo   repository.Repository
+}
+
+// Result is outcome of write to one sink, Err is nil on success.
+type Result struct {
+	Sink   string
+	Policy Policy
+	Err    error
+}
+
+// Report is outcome of write of one problem to every sink.
+type Report struct {
+	Action    string
+	ProblemID string
+	Results   []Result
+}
+
+func (r *Report) String() string {
+	results := make([]string, 0, len(r.Results))
+	for _, result := range r.Results {
+		if result.Err == nil {
+			results = append(results, fmt.Sprintf("%s: ok", result.Sink))
+		} else {
+			results = append(results, fmt.Sprintf("%s (%s): %s", result.Sink, result.Policy, result.Err))
+		}
+	}
+	return fmt.Sprintf("%s problem '%s': %s", r.Action, r.ProblemID, strings.Join(results, ", "))
+}
+
+// Failed returns true if write to any sink failed.
+func (r *Report) Failed() bool {
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredFailed returns true if write to any required sink failed.
+func (r *Report) RequiredFailed() bool {
+	for _, result := range r.Results {
+		if result.Err != nil && result.Policy == PolicyRequired {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is returned when required sink failed, report shows which sinks succeeded.
+type Error struct {
+	Report *Report
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Failed write to required sink, %s", e.Report)
+}
+
+// fanout is repository that forwards every write to all sinks, even if some
+// of them fail. Reads are served by the first sink.
+type fanout struct {
+	sinks []Sink
+}
+
+func New(sinks ...Sink) (*fanout, error) {
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("Failed create fanout repository, no sinks")
+	}
+
+	for _, sink := range sinks {
+		if sink.Policy != PolicyRequired && sink.Policy != PolicyBestEffort {
+			return nil, fmt.Errorf("Failed create fanout repository, invalid policy '%s' of sink '%s'", sink.Policy, sink.Name)
+		}
+	}
+
+	return &fanout{sinks: sinks}, nil
+}
+
+func (f *fanout) Create(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed create problem, problem is nil")
+	}
+
+	return f.write("create", problem, func(repo repository.Repository) error {
+		return repo.Create(ctx, problem)
+	})
+}
+
+func (f *fanout) Update(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed update problem, problem is nil")
+	}
+
+	return f.write("update", problem, func(repo repository.Repository) error {
+		return repo.Update(ctx, problem)
+	})
+}
+
+func (f *fanout) Delete(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed delete problem, problem is nil")
+	}
+
+	return f.write("delete", problem, func(repo repository.Repository) error {
+		return repo.Delete(ctx, problem)
+	})
+}
+
+func (f *fanout) write(action string, problem *entity.Problem, write func(repo repository.Repository) error) error {
+	report := Report{
+		Action:    action,
+		ProblemID: problem.ProblemID,
+		Results:   make([]Result, 0, len(f.sinks)),
+	}
+
+	for _, sink := range f.sinks {
+		report.Results = append(report.Results, Result{
+			Sink:   sink.Name,
+			Policy: sink.Policy,
+			Err:    write(sink.Repo),
+		})
+	}
+
+	if report.RequiredFailed() {
+		return &Error{Report: &report}
+	}
+
+	if report.Failed() {
+		log.Printf("WARNING: failed write to best effort sink, %s", &report)
+	}
+
+	return nil
+}
+
+func (f *fanout) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	return f.sinks[0].Repo.Get(ctx, id)
+}
+
+func (f *fanout) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	return f.sinks[0].Repo.List(ctx, filter)
+}
+
+// Close closes every sink, the first error is returned.
+func (f *fanout) Close(ctx context.Context) error {
+	var first error
+	for _, sink := range f.sinks {
+		err := sink.Repo.Close(ctx)
+		if err != nil && first == nil {
+			first = fmt.Errorf("Failed close sink '%s': %s", sink.Name, err)
+		}
+	}
+	return first
+}
@@ -0,0 +1,194 @@
+package fanout_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/fanout"
+)
+
+// sink fails every write with err if it is set, else keeps "<action> <id>"
+// of writes.
+type sink struct {
+	err     error
+	written []string
+}
+
+func (s *sink) write(action string, problem *entity.Problem) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.written = append(s.written, action+" "+problem.ProblemID)
+	return nil
+}
+
+func (s *sink) Create(ctx context.Context, problem *entity.Problem) error {
+	return s.write("create", problem)
+}
+
+func (s *sink) Update(ctx context.Context, problem *entity.Problem) error {
+	return s.write("update", problem)
+}
+
+func (s *sink) Delete(ctx context.Context, problem *entity.Problem) error {
+	return s.write("delete", problem)
+}
+
+func (s *sink) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	return nil, repository.ErrNotFound
+}
+
+func (s *sink) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	return nil, nil
+}
+
+func (s *sink) Close(ctx context.Context) error {
+	return s.err
+}
+
+var errUnavailable = errors.New("sink is unavailable")
+
+func TestWriteReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		required    error
+		best_effort error
+		// report is nil if write succeeds
+		report  *fanout.Report
+		warning string
+	}{
+		{
+			name: "every sink succeeds",
+		},
+		{
+			name:        "best effort sink fails",
+			best_effort: errUnavailable,
+			warning:     "WARNING: failed write to best effort sink, create problem '100': sheet: ok, audit (best_effort): sink is unavailable",
+		},
+		{
+			name:     "required sink fails",
+			required: errUnavailable,
+			report: &fanout.Report{
+				Action:    "create",
+				ProblemID: "100",
+				Results: []fanout.Result{
+					{Sink: "sheet", Policy: fanout.PolicyRequired, Err: errUnavailable},
+					{Sink: "audit", Policy: fanout.PolicyBestEffort},
+				},
+			},
+		},
+		{
+			name:        "every sink fails",
+			required:    errUnavailable,
+			best_effort: errUnavailable,
+			report: &fanout.Report{
+				Action:    "create",
+				ProblemID: "100",
+				Results: []fanout.Result{
+					{Sink: "sheet", Policy: fanout.PolicyRequired, Err: errUnavailable},
+					{Sink: "audit", Policy: fanout.PolicyBestEffort, Err: errUnavailable},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			flags := log.Flags()
+			log.SetOutput(&logged)
+			log.SetFlags(0)
+			t.Cleanup(func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(flags)
+			})
+
+			required := &sink{err: test.required}
+			best_effort := &sink{err: test.best_effort}
+
+			repo, err := fanout.New(
+				fanout.Sink{Name: "sheet", Policy: fanout.PolicyRequired, Repo: required},
+				fanout.Sink{Name: "audit", Policy: fanout.PolicyBestEffort, Repo: best_effort},
+			)
+			if err != nil {
+				t.Fatalf("Failed create fanout: %s", err)
+			}
+
+			err = repo.Create(context.Background(), &entity.Problem{ProblemID: "100"})
+
+			var sink_err *fanout.Error
+			switch {
+			case test.report == nil && err != nil:
+				t.Fatalf("Create returned %v, want success", err)
+			case test.report != nil && !errors.As(err, &sink_err):
+				t.Fatalf("Create returned %v, want required sink error", err)
+			case test.report != nil && !reflect.DeepEqual(sink_err.Report, test.report):
+				t.Fatalf("Report is %+v, want %+v", *sink_err.Report, *test.report)
+			}
+
+			// failed sink does not stop writes to the others
+			if test.required == nil && len(required.written) != 1 || test.best_effort == nil && len(best_effort.written) != 1 {
+				t.Fatalf("Written are %q and %q, want create of every healthy sink", required.written, best_effort.written)
+			}
+
+			if strings.TrimSpace(logged.String()) != test.warning {
+				t.Fatalf("Logged %q, want %q", logged.String(), test.warning)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := fanout.Report{
+		Action:    "update",
+		ProblemID: "100",
+		Results: []fanout.Result{
+			{Sink: "sheet", Policy: fanout.PolicyRequired},
+			{Sink: "audit", Policy: fanout.PolicyBestEffort, Err: errUnavailable},
+		},
+	}
+
+	if !report.Failed() || report.RequiredFailed() {
+		t.Fatalf("Report failed %t, required failed %t, want only best effort failure", report.Failed(), report.RequiredFailed())
+	}
+
+	want := "update problem '100': sheet: ok, audit (best_effort): sink is unavailable"
+	if report.String() != want {
+		t.Fatalf("Report is %q, want %q", report.String(), want)
+	}
+}
+
+func TestNewValidatesSinks(t *testing.T) {
+	if _, err := fanout.New(); err == nil {
+		t.Fatalf("Fanout without sinks is created")
+	}
+
+	_, err := fanout.New(fanout.Sink{Name: "sheet", Policy: "sometimes", Repo: &sink{}})
+	if err == nil || !strings.Contains(err.Error(), "'sheet'") {
+		t.Fatalf("New returned %v, want error naming sink 'sheet'", err)
+	}
+}
+
+func TestCloseClosesEverySink(t *testing.T) {
+	failing := &sink{err: errUnavailable}
+	repo, err := fanout.New(
+		fanout.Sink{Name: "sheet", Policy: fanout.PolicyRequired, Repo: failing},
+		fanout.Sink{Name: "audit", Policy: fanout.PolicyBestEffort, Repo: &sink{}},
+	)
+	if err != nil {
+		t.Fatalf("Failed create fanout: %s", err)
+	}
+
+	err = repo.Close(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "'sheet'") {
+		t.Fatalf("Close returned %v, want error of sink 'sheet'", err)
+	}
+}
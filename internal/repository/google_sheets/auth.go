@@ -0,0 +1,61 @@
+package google_sheets
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+
+	freedb "github.com/FreeLeh/GoFreeDB"
+	"github.com/FreeLeh/GoFreeDB/google/auth"
+)
+
+// newAuth creates auth service of service account. If base url is set, every
+// request of GoFreeDB, sheets api and token exchange is sent to it instead of
+// google hosts, it is used to run against local stand-in of the api.
+func newAuth(cfg *config.Config) (*auth.Service, error) {
+	service_config := auth.ServiceConfig{}
+
+	if cfg.GoogleSheetsBaseURL != "" {
+		base, err := url.Parse(cfg.GoogleSheetsBaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parse google sheets base url: %s", err)
+		}
+		service_config.HTTPClient = &http.Client{Transport: &baseURLTransport{base: base}}
+	}
+
+	auth, err := auth.NewServiceFromFile(
+		cfg.GoogleSheetsServiceAccountCredentialsFile,
+		freedb.GoogleAuthScopes,
+		service_config,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed create new google sheets service: %s", err)
+	}
+
+	return auth, nil
+}
+
+// baseURLTransport rewrites scheme and host of requests to base url, path of
+// base url is prepended to path of request.
+type baseURLTransport struct {
+	base *url.URL
+}
+
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rewritten := req.Clone(req.Context())
+
+	rewritten.URL.Scheme = t.base.Scheme
+	rewritten.URL.Host = t.base.Host
+	rewritten.Host = t.base.Host
+
+	prefix := strings.TrimSuffix(t.base.Path, "/")
+	rewritten.URL.Path = prefix + req.URL.Path
+	if req.URL.RawPath != "" {
+		rewritten.URL.RawPath = prefix + req.URL.RawPath
+	}
+
+	return http.DefaultTransport.RoundTrip(rewritten)
+}
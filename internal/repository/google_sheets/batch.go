@@ -0,0 +1,128 @@
+package google_sheets
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+)
+
+// pendingWrite is writes of one problem merged into its final state.
+type pendingWrite struct {
+	problem *entity.Problem
+	// createOnly is true if every write is create, it is skipped if problem exists
+	createOnly bool
+	// deleted is true if the last write is delete
+	deleted bool
+}
+
+// mergeWrites merges writes of the same problem keeping order of their first write,
+// every write carries full state of problem so the one of the highest version
+// wins, writes without version are ordered by their position.
+func mergeWrites(writes []repository.Write) ([]string, map[string]*pendingWrite) {
+	var order []string
+	pending := map[string]*pendingWrite{}
+
+	for _, write := range writes {
+		id := write.Problem.ProblemID
+
+		merged, ok := pending[id]
+		if ok && write.Problem.Version != 0 && write.Problem.Version < merged.problem.Version {
+			log.Printf("Skipping stale %s of problem '%s' version %d, version %d is in the same batch", write.Action, id, write.Problem.Version, merged.problem.Version)
+			continue
+		}
+		if !ok {
+			merged = &pendingWrite{createOnly: true}
+			pending[id] = merged
+			order = append(order, id)
+		}
+
+		merged.problem = write.Problem
+		merged.deleted = write.Action == repository.ActionDelete
+		if write.Action != repository.ActionCreate {
+			merged.createOnly = false
+		}
+	}
+
+	return order, pending
+}
+
+// WriteBatch applies writes with at most five requests: rows verification,
+// index warm up if rows moved, update of existing rows, append of new ones
+// and delete of removed ones.
+func (gs *google_sheets) WriteBatch(ctx context.Context, writes []repository.Write) error {
+	for _, write := range writes {
+		if write.Problem == nil {
+			return fmt.Errorf("Failed write batch, problem is nil")
+		}
+	}
+
+	merged_order, pending := mergeWrites(writes)
+
+	var order []string
+	var written []*entity.Problem
+	for _, id := range merged_order {
+		if gs.skipStale("write", pending[id].problem) {
+			continue
+		}
+		order = append(order, id)
+		written = append(written, pending[id].problem)
+	}
+
+	rows, err := gs.findRows(ctx, order)
+	if err != nil {
+		return fmt.Errorf("Failed write batch of %d problems: %s", len(order), err)
+	}
+
+	var update_rows []int64
+	var updates []*entity.Problem
+	var appends []*entity.Problem
+	var delete_rows []int64
+
+	for _, id := range order {
+		write := pending[id]
+
+		row, ok := rows[id]
+		if write.deleted {
+			if ok {
+				delete_rows = append(delete_rows, row)
+			}
+			continue
+		}
+
+		if !ok {
+			appends = append(appends, write.problem)
+			continue
+		}
+
+		if write.createOnly {
+			log.Printf("Skipping create of problem '%s' in sheet '%s': %s", id, gs.sheet, repository.ErrAlreadyExists)
+			continue
+		}
+
+		update_rows = append(update_rows, row)
+		updates = append(updates, write.problem)
+	}
+
+	err = gs.updateRows(ctx, update_rows, updates)
+	if err != nil {
+		return fmt.Errorf("Failed update batch of %d problems: %s", len(updates), err)
+	}
+
+	err = gs.appendRows(ctx, appends)
+	if err != nil {
+		return fmt.Errorf("Failed append batch of %d problems: %s", len(appends), err)
+	}
+
+	// rows are deleted last, as it moves rows below
+	err = gs.deleteRows(ctx, delete_rows)
+	if err != nil {
+		return fmt.Errorf("Failed delete batch of %d problems: %s", len(delete_rows), err)
+	}
+
+	gs.index.written(written...)
+
+	return nil
+}
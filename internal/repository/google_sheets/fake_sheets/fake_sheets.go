@@ -0,0 +1,711 @@
+// Package fake_sheets is in-process stand-in of Google Sheets v4 endpoints
+// used by GoFreeDB and google_sheets repository, values get, batchGet,
+// update, append, batchUpdate and batchClear, spreadsheet get and
+// batchUpdate, and the gviz query path. It is meant for tests only.
+package fake_sheets
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const accessToken = "fake-access-token"
+
+// Server serves any spreadsheet id, spreadsheet is created on first request.
+// Set GoogleSheetsBaseURL to URL and credentials to file of WriteCredentials.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	spreadsheets map[string]*spreadsheet
+	requests     []string
+}
+
+type spreadsheet struct {
+	sheets  []*sheet
+	next_id int64
+}
+
+// sheet keeps values as they were entered, see display.
+type sheet struct {
+	id    int64
+	title string
+	rows  [][]string
+}
+
+func NewServer() *Server {
+	s := Server{spreadsheets: map[string]*spreadsheet{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return &s
+}
+
+// WriteCredentials writes service account credentials file with generated
+// key, its token exchange is answered by Server.
+func WriteCredentials(path string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "fake",
+		"private_key_id": "fake",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "fake@fake.iam.gserviceaccount.com",
+		"client_id":      "1",
+		"token_uri":      "https://oauth2.googleapis.com/token",
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, credentials, 0600)
+}
+
+// Values returns displayed values of sheet, nil if there is no such sheet.
+func (s *Server) Values(spreadsheet_id string, title string) [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh := s.spreadsheet(spreadsheet_id).sheet(title)
+	if sh == nil {
+		return nil
+	}
+
+	values := make([][]string, len(sh.rows))
+	for i, row := range sh.rows {
+		values[i] = make([]string, len(row))
+		for j, value := range row {
+			_, values[i][j] = display(i+1, value)
+		}
+	}
+	return values
+}
+
+// SetValues replaces values of sheet as operator would enter them, sheet is
+// created if missing.
+func (s *Server) SetValues(spreadsheet_id string, title string, rows [][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sp := s.spreadsheet(spreadsheet_id)
+	sh := sp.sheet(title)
+	if sh == nil {
+		sh = sp.addSheet(title)
+	}
+
+	sh.rows = make([][]string, len(rows))
+	for i, row := range rows {
+		sh.rows[i] = append([]string{}, row...)
+	}
+}
+
+// Requests returns served requests as "METHOD path", token exchange excluded.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+// ResetRequests forgets served requests.
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = nil
+}
+
+func (s *Server) spreadsheet(id string) *spreadsheet {
+	sp, ok := s.spreadsheets[id]
+	if !ok {
+		sp = &spreadsheet{}
+		s.spreadsheets[id] = sp
+	}
+	return sp
+}
+
+func (sp *spreadsheet) sheet(title string) *sheet {
+	for _, sh := range sp.sheets {
+		if sh.title == title {
+			return sh
+		}
+	}
+	return nil
+}
+
+func (sp *spreadsheet) sheetByID(id int64) *sheet {
+	for _, sh := range sp.sheets {
+		if sh.id == id {
+			return sh
+		}
+	}
+	return nil
+}
+
+func (sp *spreadsheet) addSheet(title string) *sheet {
+	sh := sheet{id: sp.next_id, title: title}
+	sp.next_id++
+	sp.sheets = append(sp.sheets, &sh)
+	return &sh
+}
+
+// apiError is error in format of google apis.
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func errorf(code int, format string, args ...interface{}) *apiError {
+	return &apiError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		writeJSON(w, map[string]interface{}{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+accessToken {
+		writeError(w, errorf(http.StatusUnauthorized, "Request is missing valid access token"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i := range segments {
+		segment, err := url.PathUnescape(segments[i])
+		if err != nil {
+			writeError(w, errorf(http.StatusBadRequest, "Invalid path: %s", err))
+			return
+		}
+		segments[i] = segment
+	}
+
+	var response interface{}
+	var err error
+
+	switch {
+	case len(segments) == 5 && segments[0] == "spreadsheets" && segments[1] == "d" && segments[3] == "gviz" && segments[4] == "tq":
+		s.serveQuery(w, r, s.spreadsheet(segments[2]))
+		return
+	case len(segments) < 3 || segments[0] != "v4" || segments[1] != "spreadsheets":
+		err = errorf(http.StatusNotFound, "Unknown endpoint %s %s", r.Method, r.URL.Path)
+	case len(segments) == 3:
+		id, method, _ := strings.Cut(segments[2], ":")
+		response, err = s.serveSpreadsheet(r, id, method)
+	case len(segments) == 4:
+		response, err = s.serveValues(r, s.spreadsheet(segments[2]), segments[3])
+	case len(segments) == 5 && segments[3] == "values":
+		// range may hold colons, method follows the last one
+		a1_range, method := segments[4], ""
+		if i := strings.LastIndex(a1_range, ":"); i >= 0 && isMethod(a1_range[i+1:]) {
+			a1_range, method = a1_range[:i], a1_range[i+1:]
+		}
+		response, err = s.serveRange(r, s.spreadsheet(segments[2]), a1_range, method)
+	default:
+		err = errorf(http.StatusNotFound, "Unknown endpoint %s %s", r.Method, r.URL.Path)
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, response)
+}
+
+func isMethod(s string) bool {
+	return s == "append" || s == "clear"
+}
+
+func (s *Server) serveSpreadsheet(r *http.Request, id string, method string) (interface{}, error) {
+	sp := s.spreadsheet(id)
+
+	switch {
+	case r.Method == http.MethodGet && method == "":
+		sheets := []interface{}{}
+		for i, sh := range sp.sheets {
+			sheets = append(sheets, map[string]interface{}{
+				"properties": sh.properties(i),
+			})
+		}
+		return map[string]interface{}{
+			"spreadsheetId": id,
+			"sheets":        sheets,
+		}, nil
+
+	case r.Method == http.MethodPost && method == "batchUpdate":
+		body := struct {
+			Requests []struct {
+				AddSheet *struct {
+					Properties struct {
+						Title string `json:"title"`
+					} `json:"properties"`
+				} `json:"addSheet"`
+				InsertDimension *struct {
+					Range dimensionRange `json:"range"`
+				} `json:"insertDimension"`
+				DeleteDimension *struct {
+					Range dimensionRange `json:"range"`
+				} `json:"deleteDimension"`
+			} `json:"requests"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, errorf(http.StatusBadRequest, "Invalid body: %s", err)
+		}
+
+		// requests are validated first as batch is applied atomically
+		for i, request := range body.Requests {
+			switch {
+			case request.AddSheet != nil:
+				if sp.sheet(request.AddSheet.Properties.Title) != nil {
+					return nil, errorf(http.StatusBadRequest, "Invalid requests[%d].addSheet: A sheet with the name \"%s\" already exists. Please enter another name.", i, request.AddSheet.Properties.Title)
+				}
+			case request.InsertDimension != nil:
+				if err := request.InsertDimension.Range.validate(sp); err != nil {
+					return nil, errorf(http.StatusBadRequest, "Invalid requests[%d].insertDimension: %s", i, err)
+				}
+			case request.DeleteDimension != nil:
+				if err := request.DeleteDimension.Range.validate(sp); err != nil {
+					return nil, errorf(http.StatusBadRequest, "Invalid requests[%d].deleteDimension: %s", i, err)
+				}
+			default:
+				return nil, errorf(http.StatusBadRequest, "Unsupported requests[%d]", i)
+			}
+		}
+
+		replies := []interface{}{}
+		for _, request := range body.Requests {
+			switch {
+			case request.AddSheet != nil:
+				sh := sp.addSheet(request.AddSheet.Properties.Title)
+				replies = append(replies, map[string]interface{}{
+					"addSheet": map[string]interface{}{"properties": sh.properties(len(sp.sheets) - 1)},
+				})
+			case request.InsertDimension != nil:
+				request.InsertDimension.Range.insert(sp)
+				replies = append(replies, map[string]interface{}{})
+			case request.DeleteDimension != nil:
+				request.DeleteDimension.Range.delete(sp)
+				replies = append(replies, map[string]interface{}{})
+			}
+		}
+
+		return map[string]interface{}{
+			"spreadsheetId": id,
+			"replies":       replies,
+		}, nil
+	}
+
+	return nil, errorf(http.StatusNotFound, "Unknown endpoint %s %s", r.Method, r.URL.Path)
+}
+
+func (sh *sheet) properties(index int) map[string]interface{} {
+	return map[string]interface{}{
+		"sheetId": sh.id,
+		"title":   sh.title,
+		"index":   index,
+	}
+}
+
+type dimensionRange struct {
+	SheetID    int64  `json:"sheetId"`
+	Dimension  string `json:"dimension"`
+	StartIndex int    `json:"startIndex"`
+	EndIndex   int    `json:"endIndex"`
+}
+
+func (d dimensionRange) validate(sp *spreadsheet) error {
+	if sp.sheetByID(d.SheetID) == nil {
+		return fmt.Errorf("No grid with id: %d", d.SheetID)
+	}
+	if d.Dimension != "ROWS" && d.Dimension != "COLUMNS" {
+		return fmt.Errorf("Unsupported dimension '%s'", d.Dimension)
+	}
+	if d.StartIndex < 0 || d.EndIndex <= d.StartIndex {
+		return fmt.Errorf("Invalid range %d:%d", d.StartIndex, d.EndIndex)
+	}
+	return nil
+}
+
+func (d dimensionRange) insert(sp *spreadsheet) {
+	sh := sp.sheetByID(d.SheetID)
+	count := d.EndIndex - d.StartIndex
+
+	if d.Dimension == "ROWS" {
+		if d.StartIndex < len(sh.rows) {
+			sh.rows = append(sh.rows[:d.StartIndex], append(make([][]string, count), sh.rows[d.StartIndex:]...)...)
+		}
+		return
+	}
+
+	for i, row := range sh.rows {
+		if d.StartIndex < len(row) {
+			sh.rows[i] = append(row[:d.StartIndex], append(make([]string, count), row[d.StartIndex:]...)...)
+		}
+	}
+}
+
+func (d dimensionRange) delete(sp *spreadsheet) {
+	sh := sp.sheetByID(d.SheetID)
+
+	if d.Dimension == "ROWS" {
+		if d.StartIndex < len(sh.rows) {
+			sh.rows = append(sh.rows[:d.StartIndex], sh.rows[min(d.EndIndex, len(sh.rows)):]...)
+		}
+		return
+	}
+
+	for i, row := range sh.rows {
+		if d.StartIndex < len(row) {
+			sh.rows[i] = append(row[:d.StartIndex], row[min(d.EndIndex, len(row)):]...)
+		}
+	}
+}
+
+type valueRange struct {
+	Range          string          `json:"range"`
+	MajorDimension string          `json:"majorDimension"`
+	Values         [][]interface{} `json:"values"`
+}
+
+func (s *Server) serveValues(r *http.Request, sp *spreadsheet, method string) (interface{}, error) {
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && method == "values:batchGet":
+		value_ranges := []interface{}{}
+		for _, a1_range := range query["ranges"] {
+			value_range, err := sp.get(a1_range)
+			if err != nil {
+				return nil, err
+			}
+			value_ranges = append(value_ranges, value_range)
+		}
+		return map[string]interface{}{"valueRanges": value_ranges}, nil
+
+	case r.Method == http.MethodPost && method == "values:batchUpdate":
+		body := struct {
+			Data             []valueRange `json:"data"`
+			ValueInputOption string       `json:"valueInputOption"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, errorf(http.StatusBadRequest, "Invalid body: %s", err)
+		}
+
+		responses := []interface{}{}
+		for _, data := range body.Data {
+			response, err := sp.update(data.Range, data.Values, body.ValueInputOption)
+			if err != nil {
+				return nil, err
+			}
+			responses = append(responses, response)
+		}
+		return map[string]interface{}{"responses": responses}, nil
+
+	case r.Method == http.MethodPost && method == "values:batchClear":
+		body := struct {
+			Ranges []string `json:"ranges"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, errorf(http.StatusBadRequest, "Invalid body: %s", err)
+		}
+
+		for _, a1_range := range body.Ranges {
+			if err := sp.clear(a1_range); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"clearedRanges": body.Ranges}, nil
+	}
+
+	return nil, errorf(http.StatusNotFound, "Unknown endpoint %s %s", r.Method, r.URL.Path)
+}
+
+func (s *Server) serveRange(r *http.Request, sp *spreadsheet, a1_range string, method string) (interface{}, error) {
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && method == "":
+		return sp.get(a1_range)
+
+	case r.Method == http.MethodPut && method == "":
+		body := valueRange{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, errorf(http.StatusBadRequest, "Invalid body: %s", err)
+		}
+		return sp.update(a1_range, body.Values, query.Get("valueInputOption"))
+
+	case r.Method == http.MethodPost && method == "append":
+		body := valueRange{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, errorf(http.StatusBadRequest, "Invalid body: %s", err)
+		}
+		return sp.append(a1_range, body.Values, query.Get("valueInputOption"))
+
+	case r.Method == http.MethodPost && method == "clear":
+		if err := sp.clear(a1_range); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"clearedRange": a1_range}, nil
+	}
+
+	return nil, errorf(http.StatusNotFound, "Unknown endpoint %s %s", r.Method, r.URL.Path)
+}
+
+func (sp *spreadsheet) get(a1_range string) (interface{}, error) {
+	rng, sh, err := sp.parseRange(a1_range)
+	if err != nil {
+		return nil, err
+	}
+
+	values := [][]interface{}{}
+	for row := rng.fromRow; row <= len(sh.rows) && (rng.toRow == 0 || row <= rng.toRow); row++ {
+		cells := sh.rows[row-1]
+
+		values_row := []interface{}{}
+		for col := rng.fromCol; col < len(cells) && (rng.toCol < 0 || col <= rng.toCol); col++ {
+			_, formatted := display(row, cells[col])
+			values_row = append(values_row, formatted)
+		}
+		for len(values_row) > 0 && values_row[len(values_row)-1] == "" {
+			values_row = values_row[:len(values_row)-1]
+		}
+		values = append(values, values_row)
+	}
+	for len(values) > 0 && len(values[len(values)-1]) == 0 {
+		values = values[:len(values)-1]
+	}
+
+	response := map[string]interface{}{
+		"range":          a1_range,
+		"majorDimension": "ROWS",
+	}
+	if len(values) > 0 {
+		response["values"] = values
+	}
+	return response, nil
+}
+
+// update writes values from the top left cell of range, null value keeps cell.
+func (sp *spreadsheet) update(a1_range string, values [][]interface{}, input_option string) (interface{}, error) {
+	rng, sh, err := sp.parseRange(a1_range)
+	if err != nil {
+		return nil, err
+	}
+	if input_option != "RAW" && input_option != "USER_ENTERED" {
+		return nil, errorf(http.StatusBadRequest, "Invalid valueInputOption: '%s'", input_option)
+	}
+
+	columns := 0
+	for i, values_row := range values {
+		row := rng.fromRow + i
+		if rng.toRow != 0 && row > rng.toRow {
+			return nil, errorf(http.StatusBadRequest, "Requested writing within range [%s], but tried writing to row [%d]", a1_range, row)
+		}
+
+		for j, value := range values_row {
+			col := rng.fromCol + j
+			if rng.toCol >= 0 && col > rng.toCol {
+				return nil, errorf(http.StatusBadRequest, "Requested writing within range [%s], but tried writing to column [%s]", a1_range, columnLetter(col))
+			}
+			if value == nil {
+				continue
+			}
+			sh.set(row, col, enter(value, input_option))
+		}
+		columns = max(columns, len(values_row))
+	}
+
+	return sh.updateResponse(rng.fromRow, rng.fromCol, len(values), columns, values), nil
+}
+
+// append writes values after the last row with values in columns of range.
+func (sp *spreadsheet) append(a1_range string, values [][]interface{}, input_option string) (interface{}, error) {
+	rng, sh, err := sp.parseRange(a1_range)
+	if err != nil {
+		return nil, err
+	}
+	if input_option != "RAW" && input_option != "USER_ENTERED" {
+		return nil, errorf(http.StatusBadRequest, "Invalid valueInputOption: '%s'", input_option)
+	}
+
+	first := rng.fromRow
+	for row := rng.fromRow; row <= len(sh.rows); row++ {
+		for col, value := range sh.rows[row-1] {
+			if col >= rng.fromCol && (rng.toCol < 0 || col <= rng.toCol) && value != "" {
+				first = row + 1
+				break
+			}
+		}
+	}
+
+	columns := 0
+	for i, values_row := range values {
+		for j, value := range values_row {
+			if value == nil {
+				continue
+			}
+			sh.set(first+i, rng.fromCol+j, enter(value, input_option))
+		}
+		columns = max(columns, len(values_row))
+	}
+
+	return map[string]interface{}{
+		"tableRange": a1_range,
+		"updates":    sh.updateResponse(first, rng.fromCol, len(values), columns, values),
+	}, nil
+}
+
+func (sp *spreadsheet) clear(a1_range string) error {
+	rng, sh, err := sp.parseRange(a1_range)
+	if err != nil {
+		return err
+	}
+
+	for row := rng.fromRow; row <= len(sh.rows) && (rng.toRow == 0 || row <= rng.toRow); row++ {
+		cells := sh.rows[row-1]
+		for col := rng.fromCol; col < len(cells) && (rng.toCol < 0 || col <= rng.toCol); col++ {
+			cells[col] = ""
+		}
+	}
+	return nil
+}
+
+func (sh *sheet) set(row int, col int, value string) {
+	for len(sh.rows) < row {
+		sh.rows = append(sh.rows, nil)
+	}
+	for len(sh.rows[row-1]) <= col {
+		sh.rows[row-1] = append(sh.rows[row-1], "")
+	}
+	sh.rows[row-1][col] = value
+}
+
+func (sh *sheet) updateResponse(row int, col int, rows int, columns int, values [][]interface{}) map[string]interface{} {
+	updated_range := fmt.Sprintf("%s!%s%d", quoteTitle(sh.title), columnLetter(col), row)
+	if rows > 1 || columns > 1 {
+		updated_range += fmt.Sprintf(":%s%d", columnLetter(col+max(columns, 1)-1), row+max(rows, 1)-1)
+	}
+
+	updated_values := [][]interface{}{}
+	for i := range values {
+		updated_row := []interface{}{}
+		for j := 0; j < columns; j++ {
+			value := ""
+			if row+i <= len(sh.rows) && col+j < len(sh.rows[row+i-1]) {
+				_, value = display(row+i, sh.rows[row+i-1][col+j])
+			}
+			updated_row = append(updated_row, value)
+		}
+		updated_values = append(updated_values, updated_row)
+	}
+
+	cells := 0
+	for _, values_row := range values {
+		cells += len(values_row)
+	}
+
+	return map[string]interface{}{
+		"updatedRange":   updated_range,
+		"updatedRows":    rows,
+		"updatedColumns": columns,
+		"updatedCells":   cells,
+		"updatedData": map[string]interface{}{
+			"range":          updated_range,
+			"majorDimension": "ROWS",
+			"values":         updated_values,
+		},
+	}
+}
+
+// enter converts value of request into value as it is typed into cell.
+func enter(value interface{}, input_option string) string {
+	var entered string
+	switch v := value.(type) {
+	case string:
+		entered = v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	default:
+		entered = fmt.Sprint(v)
+	}
+
+	if input_option == "RAW" && entered != "" {
+		return "'" + entered
+	}
+	return entered
+}
+
+// display returns typed and formatted value of cell at row. Leading quote
+// keeps text as is, "=ROW()" is the only supported formula.
+func display(row int, value string) (interface{}, string) {
+	switch {
+	case value == "":
+		return nil, ""
+	case strings.HasPrefix(value, "'"):
+		return value[1:], value[1:]
+	case strings.EqualFold(value, "=ROW()"):
+		return float64(row), strconv.Itoa(row)
+	case strings.HasPrefix(value, "="):
+		return "#NAME?", "#NAME?"
+	}
+
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number, value
+	}
+	return value, value
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	api_err, ok := err.(*apiError)
+	if !ok {
+		api_err = errorf(http.StatusInternalServerError, "%s", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(api_err.code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    api_err.code,
+			"message": api_err.message,
+			"status":  http.StatusText(api_err.code),
+		},
+	})
+}
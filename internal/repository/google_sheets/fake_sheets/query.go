@@ -0,0 +1,449 @@
+package fake_sheets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// query is subset of google visualization query language used by GoFreeDB:
+// select of columns or *, where with conditions joined by and, order by,
+// limit and offset.
+type query struct {
+	columns    []int
+	conditions []condition
+	order      []order
+	limit      int
+	offset     int
+}
+
+type condition struct {
+	col int
+	// op is one of "is null", "is not null", "=", "!="
+	op    string
+	value interface{}
+}
+
+type order struct {
+	col  int
+	desc bool
+}
+
+// serveQuery answers gviz query. Errors are written without curly brackets,
+// so GoFreeDB returns them instead of empty result.
+func (s *Server) serveQuery(w http.ResponseWriter, r *http.Request, sp *spreadsheet) {
+	params := r.URL.Query()
+
+	fail := func(format string, args ...interface{}) {
+		message := strings.NewReplacer("{", "(", "}", ")").Replace(fmt.Sprintf(format, args...))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(message))
+	}
+
+	sh := sp.sheet(params.Get("sheet"))
+	if sh == nil {
+		fail("Invalid sheet '%s'", params.Get("sheet"))
+		return
+	}
+
+	headers, err := strconv.Atoi(params.Get("headers"))
+	if err != nil || headers < 0 {
+		headers = 0
+	}
+
+	q, err := parseQuery(params.Get("tq"))
+	if err != nil {
+		fail("Invalid query '%s': %s", params.Get("tq"), err)
+		return
+	}
+
+	if q.columns == nil {
+		width := 0
+		for _, row := range sh.rows {
+			width = max(width, len(row))
+		}
+		for col := 0; col < width; col++ {
+			q.columns = append(q.columns, col)
+		}
+	}
+
+	cell := func(row int, col int) (interface{}, string) {
+		if row > len(sh.rows) || col >= len(sh.rows[row-1]) {
+			return nil, ""
+		}
+		return display(row, sh.rows[row-1][col])
+	}
+
+	var rows []int
+	for row := headers + 1; row <= len(sh.rows); row++ {
+		matches := true
+		for _, c := range q.conditions {
+			typed, formatted := cell(row, c.col)
+			if !c.matches(typed, formatted) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range q.order {
+			a, _ := cell(rows[i], o.col)
+			b, _ := cell(rows[j], o.col)
+			if c := compare(a, b); c != 0 {
+				return (c < 0) != o.desc
+			}
+		}
+		return false
+	})
+
+	rows = rows[min(q.offset, len(rows)):]
+	if q.limit > 0 && q.limit < len(rows) {
+		rows = rows[:q.limit]
+	}
+
+	cols := []interface{}{}
+	numeric := map[int]bool{}
+	for _, col := range q.columns {
+		numbers, others := 0, 0
+		for _, row := range rows {
+			switch typed, _ := cell(row, col); typed.(type) {
+			case nil:
+			case float64:
+				numbers++
+			default:
+				others++
+			}
+		}
+		numeric[col] = numbers > 0 && others == 0
+
+		col_type := "string"
+		if numeric[col] {
+			col_type = "number"
+		}
+		cols = append(cols, map[string]interface{}{"id": columnLetter(col), "label": "", "type": col_type})
+	}
+
+	table_rows := []interface{}{}
+	for _, row := range rows {
+		cells := []interface{}{}
+		for _, col := range q.columns {
+			typed, formatted := cell(row, col)
+			switch {
+			case typed == nil:
+				cells = append(cells, nil)
+			case numeric[col]:
+				cells = append(cells, map[string]interface{}{"v": typed, "f": formatted})
+			default:
+				cells = append(cells, map[string]interface{}{"v": formatted})
+			}
+		}
+		table_rows = append(table_rows, map[string]interface{}{"c": cells})
+	}
+
+	response, err := json.Marshal(map[string]interface{}{
+		"version": "0.6",
+		"reqId":   "0",
+		"status":  "ok",
+		"table": map[string]interface{}{
+			"cols":             cols,
+			"rows":             table_rows,
+			"parsedNumHeaders": headers,
+		},
+	})
+	if err != nil {
+		fail("Failed encode response: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	fmt.Fprintf(w, "/*O_o*/\ngoogle.visualization.Query.setResponse(%s);", response)
+}
+
+func (c condition) matches(typed interface{}, formatted string) bool {
+	switch c.op {
+	case "is null":
+		return formatted == ""
+	case "is not null":
+		return formatted != ""
+	}
+
+	var equal bool
+	switch value := c.value.(type) {
+	case string:
+		equal = formatted == value
+	case float64:
+		number, ok := typed.(float64)
+		equal = ok && number == value
+	case bool:
+		equal = formatted == strings.ToUpper(strconv.FormatBool(value))
+	}
+
+	if c.op == "!=" {
+		return !equal
+	}
+	return equal
+}
+
+// compare orders empty cells first, then numbers, then text.
+func compare(a interface{}, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case float64:
+			return 1
+		}
+		return 2
+	}
+
+	if rank(a) != rank(b) {
+		return rank(a) - rank(b)
+	}
+
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case nil:
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func parseQuery(s string) (*query, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	q := query{}
+
+	if !p.keyword("select") {
+		return nil, fmt.Errorf("query must start with select")
+	}
+
+	if p.symbol("*") {
+		q.columns = nil
+	} else {
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			q.columns = append(q.columns, col)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("where") {
+		for {
+			c, err := p.condition()
+			if err != nil {
+				return nil, err
+			}
+			q.conditions = append(q.conditions, c)
+			if !p.keyword("and") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("order") {
+		if !p.keyword("by") {
+			return nil, fmt.Errorf("expected by after order")
+		}
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			o := order{col: col}
+			if p.keyword("desc") {
+				o.desc = true
+			} else {
+				p.keyword("asc")
+			}
+			q.order = append(q.order, o)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("limit") {
+		q.limit, err = p.number()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.keyword("offset") {
+		q.offset, err = p.number()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("unsupported token '%s'", p.tokens[p.pos])
+	}
+
+	return &q, nil
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) keyword(keyword string) bool {
+	if strings.EqualFold(p.peek(), keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) symbol(symbol string) bool {
+	if p.peek() == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) column() (int, error) {
+	token := p.peek()
+	col := columnIndex(token)
+	if col < 0 {
+		return 0, fmt.Errorf("expected column, found '%s'", token)
+	}
+	p.pos++
+	return col, nil
+}
+
+func (p *parser) number() (int, error) {
+	token := p.peek()
+	n, err := strconv.Atoi(token)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected number, found '%s'", token)
+	}
+	p.pos++
+	return n, nil
+}
+
+func (p *parser) condition() (condition, error) {
+	col, err := p.column()
+	if err != nil {
+		return condition{}, err
+	}
+
+	c := condition{col: col}
+
+	if p.keyword("is") {
+		c.op = "is null"
+		if p.keyword("not") {
+			c.op = "is not null"
+		}
+		if !p.keyword("null") {
+			return condition{}, fmt.Errorf("expected null, found '%s'", p.peek())
+		}
+		return c, nil
+	}
+
+	switch {
+	case p.symbol("="):
+		c.op = "="
+	case p.symbol("!="), p.symbol("<>"):
+		c.op = "!="
+	default:
+		return condition{}, fmt.Errorf("unsupported operator '%s'", p.peek())
+	}
+
+	token := p.peek()
+	switch {
+	case strings.HasPrefix(token, `"`):
+		value, err := strconv.Unquote(token)
+		if err != nil {
+			return condition{}, fmt.Errorf("invalid string %s: %s", token, err)
+		}
+		c.value = value
+	case strings.EqualFold(token, "true"), strings.EqualFold(token, "false"):
+		c.value = strings.EqualFold(token, "true")
+	default:
+		number, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return condition{}, fmt.Errorf("expected value, found '%s'", token)
+		}
+		c.value = number
+	}
+	p.pos++
+
+	return c, nil
+}
+
+// tokenize splits query into words, double quoted strings and symbols.
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			quoted, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, quoted)
+			i += len(quoted)
+		case strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<>"):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune(",*=", r):
+			tokens = append(tokens, string(r))
+			i++
+		case r == '_' || r == '.' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || s[j] == '-' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unsupported character '%c' at %d", r, i)
+		}
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,119 @@
+package fake_sheets
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// a1Range is range of cells, rows are numbered from 1 and columns from 0.
+type a1Range struct {
+	fromRow int
+	// toRow is 0 if range is not bounded by row
+	toRow   int
+	fromCol int
+	// toCol is -1 if range is not bounded by column
+	toCol int
+}
+
+// parseRange parses A1 notation, e.g. "Sheet!A2:H", "Sheet!B5", "Sheet!1:1"
+// or "'My sheet'!A:A", sheet must exist.
+func (sp *spreadsheet) parseRange(s string) (a1Range, *sheet, error) {
+	rng := a1Range{fromRow: 1, toCol: -1}
+
+	title, cells := s, ""
+	if i := strings.LastIndex(s, "!"); i >= 0 {
+		title, cells = s[:i], s[i+1:]
+	}
+	if len(title) >= 2 && strings.HasPrefix(title, "'") && strings.HasSuffix(title, "'") {
+		title = strings.ReplaceAll(title[1:len(title)-1], "''", "'")
+	}
+
+	sh := sp.sheet(title)
+	if sh == nil {
+		return rng, nil, errorf(http.StatusBadRequest, "Unable to parse range: %s", s)
+	}
+
+	if cells == "" {
+		return rng, sh, nil
+	}
+
+	from, to, ok := strings.Cut(cells, ":")
+	if !ok {
+		to = from
+	}
+
+	from_col, from_row, ok_from := parseCell(from)
+	to_col, to_row, ok_to := parseCell(to)
+	if !ok_from || !ok_to {
+		return rng, nil, errorf(http.StatusBadRequest, "Unable to parse range: %s", s)
+	}
+
+	if from_col >= 0 {
+		rng.fromCol = from_col
+	}
+	if from_row > 0 {
+		rng.fromRow = from_row
+	}
+	rng.toCol = to_col
+	rng.toRow = to_row
+
+	return rng, sh, nil
+}
+
+// parseCell parses "B5", "B" or "5", absent column is -1 and absent row is 0.
+func parseCell(s string) (int, int, bool) {
+	letters := strings.TrimRightFunc(s, unicode.IsDigit)
+	digits := s[len(letters):]
+
+	col := -1
+	if letters != "" {
+		col = columnIndex(letters)
+		if col < 0 {
+			return 0, 0, false
+		}
+	}
+
+	row := 0
+	if digits != "" {
+		var err error
+		row, err = strconv.Atoi(digits)
+		if err != nil || row < 1 {
+			return 0, 0, false
+		}
+	}
+
+	return col, row, letters != "" || digits != ""
+}
+
+// columnIndex returns 0 for "A", 26 for "AA", -1 if s is not column.
+func columnIndex(s string) int {
+	index := 0
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return -1
+		}
+		index = index*26 + int(r-'A') + 1
+	}
+	return index - 1
+}
+
+// columnLetter returns "A" for 0, "AA" for 26.
+func columnLetter(index int) string {
+	letter := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		letter = string(rune('A'+(index-1)%26)) + letter
+	}
+	return letter
+}
+
+// quoteTitle quotes sheet title for A1 notation if it is not single word.
+func quoteTitle(title string) string {
+	for _, r := range title {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return "'" + strings.ReplaceAll(title, "'", "''") + "'"
+		}
+	}
+	return title
+}
@@ -3,163 +3,544 @@ package google_sheets
 import (
 	"context"
 	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
 	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
 
 	freedb "github.com/FreeLeh/GoFreeDB"
 	"github.com/FreeLeh/GoFreeDB/google/auth"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
 )
 
-type problemGS struct {
-	ProblemID   string `db:"ID проблемы (автоматически)"`
-	CameraID    string `db:"ID камеры (автоматически)"`
-	Description string `db:"Описание проблемы (автоматически)"`
-	StartedAt   string `db:"Время возникновения проблемы (автоматически)"`
-	IsResolved  string `db:"Статус проблемы (автоматически)"`
-	ResolvedAt  string `db:"Время устранения проблемы (автоматически)"`
+// google_sheets reads problems with GoFreeDB queries, while writes go
+// straight to rows found by row index.
+type google_sheets struct {
+	row_store      freedb.GoogleSheetRowStore
+	spreadsheets   *sheets.SpreadsheetsService
+	values         *sheets.SpreadsheetsValuesService
+	spreadsheet_id string
+	sheet          string
+	schema         *schema
+	location       *time.Location
+	index          *rowIndex
+	// sheet_id is id of the tab, it is read on first row delete
+	sheet_id *int64
 }
 
-func convertProblemToStruct(problem *entity.Problem) *problemGS {
-	if problem == nil {
-		return nil
+// New opens sheet, it is created with headers if missing. ctx bounds reading
+// of the sheet, GoFreeDB setup takes no context.
+func New(ctx context.Context, cfg *config.Config, sheet string, sheet_schema config.GoogleSheetsSchema) (*google_sheets, error) {
+	gs := google_sheets{}
+
+	layout, err := newSchema(sheet_schema)
+	if err != nil {
+		return nil, fmt.Errorf("Failed create schema of sheet '%s': %s", sheet, err)
 	}
+	gs.schema = layout
 
-	var is_resolved string
-	if problem.IsResolved {
-		is_resolved = "устранена"
-	} else {
-		is_resolved = "актуальна"
+	// times may be written without zone, read them back in the default zone
+	location, err := time.LoadLocation(cfg.TelegramTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("Failed load timezone: %s", err)
 	}
+	gs.location = location
 
-	var resolved_at string
-	if problem.ResolvedAt != nil {
-		resolved_at = problem.ResolvedAt.Format("02.01.2006 15:04:05")
+	auth, err := newAuth(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	return &problemGS{
-		ProblemID:   problem.ProblemID,
-		CameraID:    problem.CameraID,
-		Description: problem.Description,
-		StartedAt:   problem.StartedAt.Format("02.01.2006 15:04:05"),
-		IsResolved:  is_resolved,
-		ResolvedAt:  resolved_at,
+	service, err := sheets.NewService(ctx, option.WithHTTPClient(auth.HTTPClient()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed create new google sheets service: %s", err)
 	}
+	gs.spreadsheets = service.Spreadsheets
+	gs.values = service.Spreadsheets.Values
+	gs.spreadsheet_id = cfg.GoogleSheetsSpreadsheetID
+	gs.sheet = sheet
+
+	// GoFreeDB rewrites header row, so it is checked before
+	err = gs.checkLayout(ctx, cfg.GoogleSheetsRepairLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	row_store, err := newRowStore(auth, cfg.GoogleSheetsSpreadsheetID, sheet, layout.columnNames())
+	if err != nil {
+		return nil, err
+	}
+
+	gs.row_store = *row_store
+
+	var index_path string
+	if cfg.GoogleSheetsRowIndexDir != "" {
+		index_path = filepath.Join(cfg.GoogleSheetsRowIndexDir, sheet+".json")
+	}
+	gs.index = newRowIndex(index_path)
+
+	// rows may be written by the previous run after the index was saved or
+	// moved by operators, so index is always warmed, persisted one is used
+	// only if the sheet can not be read
+	err = gs.warm(ctx)
+	if err != nil {
+		loaded, load_err := gs.index.load()
+		if load_err != nil || !loaded {
+			return nil, err
+		}
+		log.Printf("WARNING: %s, using persisted row index", err)
+	}
+
+	return &gs, nil
 }
 
-func convertProblemToMap(problem *entity.Problem) map[string]interface{} {
-	if problem == nil {
+// newRowStore creates GoFreeDB store of sheet, the sheet and its headers are
+// created if missing. GoFreeDB panics on failure, it is returned as error as
+// sheets may be opened while running.
+func newRowStore(auth *auth.Service, spreadsheet_id string, sheet string, columns []string) (row_store *freedb.GoogleSheetRowStore, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Failed open sheet '%s': %v", sheet, r)
+		}
+	}()
+
+	row_store = freedb.NewGoogleSheetRowStore(
+		auth,
+		spreadsheet_id,
+		sheet,
+		freedb.GoogleSheetRowStoreConfig{Columns: columns},
+	)
+
+	return row_store, nil
+}
+
+func (gs *google_sheets) Close(ctx context.Context) error {
+	gs.saveIndex()
+	return gs.row_store.Close(ctx)
+}
+
+// saveIndex persists row index after rows are written, failure is only
+// logged as the rows are already in the sheet.
+func (gs *google_sheets) saveIndex() {
+	err := gs.index.save()
+	if err != nil {
+		log.Printf("Failed save row index of sheet '%s': %s", gs.sheet, err)
+	}
+}
+
+// warm rebuilds row index from problem id column, it is single request.
+func (gs *google_sheets) warm(ctx context.Context) error {
+	letter := gs.schema.letter(gs.schema.names[config.GoogleSheetsFieldProblemID])
+
+	result, err := gs.values.
+		Get(gs.spreadsheet_id, fmt.Sprintf("%s!%s2:%s", gs.sheet, letter, letter)).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("Failed read problem ids of sheet '%s': %s", gs.sheet, err)
+	}
+
+	rows := map[string]int64{}
+	for i, values := range result.Values {
+		if len(values) == 0 {
+			continue
+		}
+
+		id := fmt.Sprint(values[0])
+		if id == "" {
+			continue
+		}
+
+		if _, ok := rows[id]; ok {
+			log.Printf("WARNING: problem '%s' is duplicated in sheet '%s', only first row is updated", id, gs.sheet)
+			continue
+		}
+		rows[id] = int64(i) + 2
+	}
+
+	gs.index.reset(rows)
+	gs.saveIndex()
+
+	return nil
+}
+
+// findRows returns rows of problems that are in the sheet, rows from index
+// are verified with single request and index is warmed again if any row
+// holds other problem.
+func (gs *google_sheets) findRows(ctx context.Context, ids []string) (map[string]int64, error) {
+	letter := gs.schema.letter(gs.schema.names[config.GoogleSheetsFieldProblemID])
+
+	var indexed []string
+	var ranges []string
+	for _, id := range ids {
+		row, ok := gs.index.get(id)
+		if !ok {
+			continue
+		}
+		indexed = append(indexed, id)
+		ranges = append(ranges, fmt.Sprintf("%s!%s%d", gs.sheet, letter, row))
+	}
+
+	rows := map[string]int64{}
+	if len(indexed) == 0 {
+		return rows, nil
+	}
+
+	result, err := gs.values.
+		BatchGet(gs.spreadsheet_id).
+		Ranges(ranges...).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("Failed verify rows of problems: %s", err)
+	}
+
+	stale := false
+	for i, id := range indexed {
+		row, _ := gs.index.get(id)
+
+		values := result.ValueRanges[i].Values
+		if len(values) > 0 && len(values[0]) > 0 && fmt.Sprint(values[0][0]) == id {
+			rows[id] = row
+			continue
+		}
+
+		log.Printf("Row %d of sheet '%s' does not hold problem '%s' anymore", row, gs.sheet, id)
+		stale = true
+	}
+
+	if !stale {
+		return rows, nil
+	}
+
+	log.Printf("Warming row index of sheet '%s'", gs.sheet)
+
+	err = gs.warm(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range indexed {
+		if row, ok := gs.index.get(id); ok {
+			rows[id] = row
+		}
+	}
+	return rows, nil
+}
+
+// appendRows appends problems with single request and indexes their rows.
+func (gs *google_sheets) appendRows(ctx context.Context, problems []*entity.Problem) error {
+	if len(problems) == 0 {
 		return nil
 	}
 
-	var is_resolved string
-	if problem.IsResolved {
-		is_resolved = "устранена"
-	} else {
-		is_resolved = "актуальна"
+	values := make([][]interface{}, 0, len(problems))
+	for _, problem := range problems {
+		values = append(values, gs.schema.convertProblemToRow(problem))
 	}
 
-	var resolved_at string
-	if problem.ResolvedAt != nil {
-		resolved_at = problem.ResolvedAt.Format("02.01.2006 15:04:05")
+	result, err := gs.values.
+		Append(gs.spreadsheet_id, fmt.Sprintf("%s!A2:%s", gs.sheet, gs.schema.lastLetter()), &sheets.ValueRange{
+			MajorDimension: "ROWS",
+			Values:         values,
+		}).
+		InsertDataOption("OVERWRITE").
+		ValueInputOption("USER_ENTERED").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return err
 	}
 
-	return map[string]interface{}{
-		"ID проблемы (автоматически)":                  problem.ProblemID,
-		"ID камеры (автоматически)":                    problem.CameraID,
-		"Описание проблемы (автоматически)":            problem.Description,
-		"Время возникновения проблемы (автоматически)": problem.StartedAt.Format("02.01.2006 15:04:05"),
-		"Статус проблемы (автоматически)":              is_resolved,
-		"Время устранения проблемы (автоматически)":    resolved_at,
+	first, err := rangeRow(result.Updates.UpdatedRange)
+	if err != nil {
+		return err
 	}
+	for i, problem := range problems {
+		gs.index.set(problem.ProblemID, first+int64(i))
+	}
+	gs.saveIndex()
+
+	return nil
 }
 
-type google_sheets struct {
-	row_store freedb.GoogleSheetRowStore
+// updateRows writes automatic columns of problems to their rows with single request.
+func (gs *google_sheets) updateRows(ctx context.Context, rows []int64, problems []*entity.Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	data := make([]*sheets.ValueRange, 0, len(problems)*len(gs.schema.automatic))
+	for i, problem := range problems {
+		for name, value := range gs.schema.convertProblemToMap(problem) {
+			data = append(data, &sheets.ValueRange{
+				Range:  fmt.Sprintf("%s!%s%d", gs.sheet, gs.schema.letter(name), rows[i]),
+				Values: [][]interface{}{{value}},
+			})
+		}
+	}
+
+	_, err := gs.values.
+		BatchUpdate(gs.spreadsheet_id, &sheets.BatchUpdateValuesRequest{
+			Data:             data,
+			ValueInputOption: "USER_ENTERED",
+		}).
+		Context(ctx).
+		Do()
+	return err
 }
 
-func New(cfg *config.Config) (*google_sheets, error) {
-	gs := google_sheets{}
+// deleteRows removes rows with single request and shifts indexed rows below them.
+func (gs *google_sheets) deleteRows(ctx context.Context, rows []int64) error {
+	if len(rows) == 0 {
+		return nil
+	}
 
-	auth, err := auth.NewServiceFromFile(
-		cfg.GoogleSheetsServiceAccountCredentialsFile,
-		freedb.GoogleAuthScopes,
-		auth.ServiceConfig{},
-	)
+	sheet_id, err := gs.sheetID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// rows are deleted from the bottom, so rows above keep their numbers
+	sorted := append([]int64{}, rows...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	requests := make([]*sheets.Request, 0, len(sorted))
+	for _, row := range sorted {
+		requests = append(requests, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    sheet_id,
+					Dimension:  "ROWS",
+					StartIndex: row - 1,
+					EndIndex:   row,
+				},
+			},
+		})
+	}
 
+	_, err = gs.spreadsheets.
+		BatchUpdate(gs.spreadsheet_id, &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}).
+		Context(ctx).
+		Do()
 	if err != nil {
-		return nil, fmt.Errorf("Failed create new google sheets service: %s", err)
+		return err
 	}
 
-	row_store := freedb.NewGoogleSheetRowStore(
-		auth,
-		cfg.GoogleSheetsSpreadsheetID,
-		cfg.GoogleSheetsSheet,
-		freedb.GoogleSheetRowStoreConfig{Columns: []string{"ID проблемы (автоматически)", "ID камеры (автоматически)", "Описание проблемы (автоматически)", "Время возникновения проблемы (автоматически)", "Статус проблемы (автоматически)", "Время устранения проблемы (автоматически)"}},
-	)
+	for _, row := range sorted {
+		gs.index.removeRow(row)
+	}
+	gs.saveIndex()
 
-	gs.row_store = *row_store
+	return nil
+}
 
-	return &gs, nil
+// sheetID returns id of the tab, it is needed to delete rows.
+func (gs *google_sheets) sheetID(ctx context.Context) (int64, error) {
+	if gs.sheet_id != nil {
+		return *gs.sheet_id, nil
+	}
+
+	properties, err := gs.properties(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if properties == nil {
+		return 0, fmt.Errorf("Failed find sheet '%s' in spreadsheet", gs.sheet)
+	}
+
+	gs.sheet_id = &properties.SheetId
+	return properties.SheetId, nil
 }
 
-func (gs *google_sheets) Close(ctx context.Context) error {
-	return gs.row_store.Close(ctx)
+// rangeRow returns the first row of A1 range, e.g. 10 of "Sheet!A10:H12".
+func rangeRow(a1_range string) (int64, error) {
+	cells := a1_range[strings.LastIndex(a1_range, "!")+1:]
+	cells, _, _ = strings.Cut(cells, ":")
+
+	row, err := strconv.ParseInt(strings.TrimLeft(cells, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Failed parse row of range '%s': %s", a1_range, err)
+	}
+	return row, nil
 }
 
-func (gs *google_sheets) Create(problem *entity.Problem) error {
+func (gs *google_sheets) Create(ctx context.Context, problem *entity.Problem) error {
 	if problem == nil {
 		return fmt.Errorf("Failed create problem, problem is nil")
 	}
 
-	count, err := gs.row_store.
-		Count().
-		Where("ID проблемы (автоматически) = ?", problem.ProblemID).
-		Exec(context.Background())
+	if gs.skipStale(repository.ActionCreate, problem) {
+		return nil
+	}
 
+	rows, err := gs.findRows(ctx, []string{problem.ProblemID})
 	if err != nil {
 		return fmt.Errorf("Failed check problem is exists before create: %s", err)
 	}
 
-	if count > 0 {
-		return fmt.Errorf("Failed create problem, problem with '%s' id alredy exists", problem.ProblemID)
+	if _, ok := rows[problem.ProblemID]; ok {
+		return fmt.Errorf("Failed create problem with '%s' id: %w", problem.ProblemID, repository.ErrAlreadyExists)
 	}
 
-	err = gs.row_store.Insert(convertProblemToStruct(problem)).Exec(context.Background())
+	err = gs.appendRows(ctx, []*entity.Problem{problem})
 	if err != nil {
 		return fmt.Errorf("Failed create problem '%v', error: %s", *problem, err)
 	}
+	gs.index.written(problem)
 	return nil
 }
 
-func (gs *google_sheets) Update(problem *entity.Problem) error {
+func (gs *google_sheets) Update(ctx context.Context, problem *entity.Problem) error {
 	if problem == nil {
 		return fmt.Errorf("Failed update problem, problem is nil")
 	}
 
-	count, err := gs.row_store.
-		Count().
-		Where("ID проблемы (автоматически) = ?", problem.ProblemID).
-		Exec(context.Background())
+	if gs.skipStale(repository.ActionUpdate, problem) {
+		return nil
+	}
 
+	rows, err := gs.findRows(ctx, []string{problem.ProblemID})
 	if err != nil {
 		return fmt.Errorf("Failed check problem is exists before update: %s", err)
 	}
 
-	if count == 0 {
-		return gs.Create(problem)
+	row, ok := rows[problem.ProblemID]
+	if !ok {
+		err = gs.appendRows(ctx, []*entity.Problem{problem})
+		if err != nil {
+			return fmt.Errorf("Failed create problem '%v', error: %s", *problem, err)
+		}
+		gs.index.written(problem)
+		return nil
+	}
+
+	err = gs.updateRows(ctx, []int64{row}, []*entity.Problem{problem})
+	if err != nil {
+		return fmt.Errorf("Failed update problem '%v', error: %s", *problem, err)
+	}
+	gs.index.written(problem)
+	return nil
+}
+
+// Delete removes row of problem, problem that is not in the sheet is skipped.
+func (gs *google_sheets) Delete(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed delete problem, problem is nil")
 	}
 
-	if count != 1 {
-		return fmt.Errorf("Failed update problem, more than one problem with '%s' id is exists", problem.ProblemID)
+	if gs.skipStale(repository.ActionDelete, problem) {
+		return nil
 	}
 
-	err = gs.row_store.
-		Update(convertProblemToMap(problem)).
-		Where("ID проблемы (автоматически) = ?", problem.ProblemID).
-		Exec(context.Background())
+	rows, err := gs.findRows(ctx, []string{problem.ProblemID})
 	if err != nil {
-		return fmt.Errorf("Failed update problem '%v', error: %s", *problem, err)
+		return fmt.Errorf("Failed check problem is exists before delete: %s", err)
+	}
+
+	row, ok := rows[problem.ProblemID]
+	if !ok {
+		log.Printf("Skipping delete of problem '%s' in sheet '%s': %s", problem.ProblemID, gs.sheet, repository.ErrNotFound)
+		gs.index.written(problem)
+		return nil
+	}
+
+	err = gs.deleteRows(ctx, []int64{row})
+	if err != nil {
+		return fmt.Errorf("Failed delete problem '%s', error: %s", problem.ProblemID, err)
 	}
+	gs.index.written(problem)
 	return nil
 }
+
+// skipStale is true if newer version of problem is already written, e.g.
+// retried event that was overtaken by later change of the problem.
+func (gs *google_sheets) skipStale(action string, problem *entity.Problem) bool {
+	if !gs.index.stale(problem) {
+		return false
+	}
+	log.Printf("Skipping stale %s of problem '%s' version %d in sheet '%s'", action, problem.ProblemID, problem.Version, gs.sheet)
+	return true
+}
+
+func (gs *google_sheets) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	rows := []map[string]interface{}{}
+
+	err := gs.row_store.
+		Select(&rows).
+		Where(gs.schema.names[config.GoogleSheetsFieldProblemID]+" = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed get problem '%s': %s", id, err)
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Failed get problem '%s': %w", id, repository.ErrNotFound)
+	}
+
+	return gs.schema.convertMapToProblem(rows[0], gs.location)
+}
+
+func (gs *google_sheets) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	var conditions []string
+	var args []interface{}
+
+	where := func(field string, value string) error {
+		column, ok := gs.schema.column(field)
+		if !ok {
+			return fmt.Errorf("Failed list problems, sheet has no %s column", field)
+		}
+		conditions = append(conditions, column+" = ?")
+		args = append(args, value)
+		return nil
+	}
+
+	if filter.CameraID != "" {
+		if err := where(config.GoogleSheetsFieldCameraID, filter.CameraID); err != nil {
+			return nil, err
+		}
+	}
+	if filter.Source != "" {
+		if err := where(config.GoogleSheetsFieldSource, filter.Source); err != nil {
+			return nil, err
+		}
+	}
+	if filter.IsResolved != nil {
+		if err := where(config.GoogleSheetsFieldStatus, gs.schema.status(*filter.IsResolved)); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := []map[string]interface{}{}
+
+	stmt := gs.row_store.Select(&rows)
+	if len(conditions) > 0 {
+		stmt = stmt.Where(strings.Join(conditions, " AND "), args...)
+	}
+	if filter.Limit > 0 {
+		stmt = stmt.Limit(uint64(filter.Limit))
+	}
+
+	err := stmt.Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed list problems: %s", err)
+	}
+
+	problems := make([]*entity.Problem, 0, len(rows))
+	for _, row := range rows {
+		problem, err := gs.schema.convertMapToProblem(row, gs.location)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,908 @@
+package google_sheets_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/google_sheets"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/google_sheets/fake_sheets"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/local"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	spreadsheetID = "spreadsheet"
+	sheet         = "Проблемы"
+
+	headerProblemID = "ID проблемы (автоматически)"
+	statusActive    = "актуальна"
+	statusResolved  = "устранена"
+	statusWithdrawn = "отозвана"
+)
+
+var defaultHeaders = []string{
+	"_rid",
+	headerProblemID,
+	"ID камеры (автоматически)",
+	"Описание проблемы (автоматически)",
+	"Время возникновения проблемы (автоматически)",
+	"Статус проблемы (автоматически)",
+	"Время устранения проблемы (автоматически)",
+}
+
+// detailsSchema is default columns followed by every optional field.
+var detailsSchema = config.GoogleSheetsSchema{
+	Columns: []config.GoogleSheetsColumn{
+		{Field: config.GoogleSheetsFieldProblemID, Name: headerProblemID},
+		{Field: config.GoogleSheetsFieldCameraID, Name: "ID камеры (автоматически)"},
+		{Field: config.GoogleSheetsFieldDescription, Name: "Описание проблемы (автоматически)"},
+		{Field: config.GoogleSheetsFieldStartedAt, Name: "Время возникновения проблемы (автоматически)"},
+		{Field: config.GoogleSheetsFieldStatus, Name: "Статус проблемы (автоматически)"},
+		{Field: config.GoogleSheetsFieldResolvedAt, Name: "Время устранения проблемы (автоматически)"},
+		{Field: config.GoogleSheetsFieldSource, Name: "Источник"},
+		{Field: config.GoogleSheetsFieldAcknowledged, Name: "Подтверждена"},
+		{Field: config.GoogleSheetsFieldAcknowledgedBy, Name: "Подтвердил"},
+		{Field: config.GoogleSheetsFieldComments, Name: "Комментарии zabbix"},
+		{Field: config.GoogleSheetsFieldSeverity, Name: "Важность"},
+		{Field: config.GoogleSheetsFieldHost, Name: "Хост"},
+		{Field: config.GoogleSheetsFieldHostIP, Name: "IP хоста"},
+		{Field: config.GoogleSheetsFieldOperationalData, Name: "Оперативные данные"},
+		{Field: config.GoogleSheetsFieldTags, Name: "Теги"},
+	},
+}
+
+func newServer(t *testing.T) (*fake_sheets.Server, *config.Config) {
+	t.Helper()
+
+	server := fake_sheets.NewServer()
+	t.Cleanup(server.Close)
+
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	if err := fake_sheets.WriteCredentials(credentials); err != nil {
+		t.Fatalf("Failed write credentials: %s", err)
+	}
+
+	cfg := config.Config{
+		TelegramTimezone: "UTC",
+		GoogleSheetsServiceAccountCredentialsFile: credentials,
+		GoogleSheetsSpreadsheetID:                 spreadsheetID,
+		GoogleSheetsBaseURL:                       server.URL,
+	}
+
+	return server, &cfg
+}
+
+type testRepository interface {
+	repository.Repository
+	repository.BatchWriter
+}
+
+func open(t *testing.T, cfg *config.Config, sheet_schema config.GoogleSheetsSchema) testRepository {
+	t.Helper()
+
+	repo, err := google_sheets.New(context.Background(), cfg, sheet, sheet_schema)
+	if err != nil {
+		t.Fatalf("Failed open sheet: %s", err)
+	}
+	t.Cleanup(func() { repo.Close(context.Background()) })
+
+	return repo
+}
+
+func newProblem(id string) *entity.Problem {
+	return &entity.Problem{
+		ProblemID:   id,
+		CameraID:    "camera-" + id,
+		Description: "Camera " + id + " is unavailable",
+		StartedAt:   time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+	}
+}
+
+func resolve(problem *entity.Problem) *entity.Problem {
+	resolved := *problem
+	resolved_at := problem.StartedAt.Add(15 * time.Minute)
+	resolved.IsResolved = true
+	resolved.ResolvedAt = &resolved_at
+	return &resolved
+}
+
+// dataRows returns displayed rows of sheet without header row.
+func dataRows(server *fake_sheets.Server) [][]string {
+	values := server.Values(spreadsheetID, sheet)
+	if len(values) <= 1 {
+		return nil
+	}
+	return values[1:]
+}
+
+func cell(row []string, i int) string {
+	if i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+func assertProblem(t *testing.T, got *entity.Problem, want *entity.Problem) {
+	t.Helper()
+
+	if got.ProblemID != want.ProblemID ||
+		got.CameraID != want.CameraID ||
+		got.Description != want.Description ||
+		!got.StartedAt.Equal(want.StartedAt) ||
+		got.IsResolved != want.IsResolved ||
+		got.Source != want.Source {
+		t.Fatalf("Problem is %+v, want %+v", *got, *want)
+	}
+
+	if (got.ResolvedAt == nil) != (want.ResolvedAt == nil) ||
+		got.ResolvedAt != nil && !got.ResolvedAt.Equal(*want.ResolvedAt) {
+		t.Fatalf("Problem resolve time is %v, want %v", got.ResolvedAt, want.ResolvedAt)
+	}
+}
+
+func TestNewCreatesSheetWithHeaders(t *testing.T) {
+	server, cfg := newServer(t)
+
+	open(t, cfg, config.GoogleSheetsSchema{})
+
+	values := server.Values(spreadsheetID, sheet)
+	if len(values) != 1 || strings.Join(values[0], "|") != strings.Join(defaultHeaders, "|") {
+		t.Fatalf("Sheet is %q, want header row %q", values, defaultHeaders)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	problem := newProblem("100")
+	if err := repo.Create(ctx, problem); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	rows := dataRows(server)
+	want := []string{"2", "100", "camera-100", "Camera 100 is unavailable", "18.10.2026 09:30:00", statusActive, ""}
+	if len(rows) != 1 || strings.Join(rows[0], "|") != strings.Join(want, "|") {
+		t.Fatalf("Rows are %q, want %q", rows, want)
+	}
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	assertProblem(t, got, problem)
+}
+
+func TestCreateDuplicate(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	if err := repo.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	err := repo.Create(ctx, newProblem("100"))
+	if !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Fatalf("Create of duplicate returned %v, want %v", err, repository.ErrAlreadyExists)
+	}
+
+	if rows := dataRows(server); len(rows) != 1 {
+		t.Fatalf("Sheet has %d rows, want 1", len(rows))
+	}
+}
+
+func TestCreateDuplicateAfterRestart(t *testing.T) {
+	server, cfg := newServer(t)
+	ctx := context.Background()
+
+	first := open(t, cfg, config.GoogleSheetsSchema{})
+	if err := first.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	first.Close(ctx)
+
+	// row index is warmed from the sheet at start
+	second := open(t, cfg, config.GoogleSheetsSchema{})
+	err := second.Create(ctx, newProblem("100"))
+	if !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Fatalf("Create of duplicate returned %v, want %v", err, repository.ErrAlreadyExists)
+	}
+
+	if rows := dataRows(server); len(rows) != 1 {
+		t.Fatalf("Sheet has %d rows, want 1", len(rows))
+	}
+}
+
+func TestUpdateAfterRestartWithoutClose(t *testing.T) {
+	server, cfg := newServer(t)
+	cfg.GoogleSheetsRowIndexDir = t.TempDir()
+	ctx := context.Background()
+
+	first := open(t, cfg, config.GoogleSheetsSchema{})
+	if err := first.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	first.Close(ctx)
+
+	// the second run is killed without Close after its write
+	second := open(t, cfg, config.GoogleSheetsSchema{})
+	if err := second.Create(ctx, newProblem("101")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	third := open(t, cfg, config.GoogleSheetsSchema{})
+	if err := third.Update(ctx, resolve(newProblem("101"))); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	if len(rows) != 2 || cell(rows[1], 1) != "101" || cell(rows[1], 5) != statusResolved {
+		t.Fatalf("Rows are %q, want problem 101 resolved in place", rows)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, detailsSchema)
+	ctx := context.Background()
+
+	problem := newProblem("100")
+	problem.Source = "zabbix"
+	if err := repo.Create(ctx, problem); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	if err := repo.Create(ctx, newProblem("200")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	resolved := resolve(problem)
+	if err := repo.Update(ctx, resolved); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	if len(rows) != 2 {
+		t.Fatalf("Sheet has %d rows, want 2", len(rows))
+	}
+	if cell(rows[0], 5) != statusResolved || cell(rows[0], 6) != "18.10.2026 09:45:00" {
+		t.Fatalf("Row of resolved problem is %q", rows[0])
+	}
+	if cell(rows[1], 5) != statusActive {
+		t.Fatalf("Row of active problem is %q", rows[1])
+	}
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	assertProblem(t, got, resolved)
+
+	is_resolved := true
+	list, err := repo.List(ctx, repository.Filter{IsResolved: &is_resolved})
+	if err != nil {
+		t.Fatalf("Failed list: %s", err)
+	}
+	if len(list) != 1 || list[0].ProblemID != "100" {
+		t.Fatalf("Resolved problems are %v, want only 100", list)
+	}
+
+	list, err = repo.List(ctx, repository.Filter{CameraID: "camera-200"})
+	if err != nil {
+		t.Fatalf("Failed list: %s", err)
+	}
+	if len(list) != 1 || list[0].ProblemID != "200" {
+		t.Fatalf("Problems of camera-200 are %v, want only 200", list)
+	}
+}
+
+func TestUpdateMissingProblemAppendsRow(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	resolved := resolve(newProblem("100"))
+	if err := repo.Update(ctx, resolved); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	if len(rows) != 1 || cell(rows[0], 1) != "100" || cell(rows[0], 5) != statusResolved {
+		t.Fatalf("Rows are %q, want resolved problem 100", rows)
+	}
+}
+
+func TestGetMissingProblem(t *testing.T) {
+	_, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+
+	_, err := repo.Get(context.Background(), "100")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get of missing problem returned %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func TestUpdateAfterOperatorSortedRows(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	for _, id := range []string{"100", "200"} {
+		if err := repo.Create(ctx, newProblem(id)); err != nil {
+			t.Fatalf("Failed create: %s", err)
+		}
+	}
+
+	// operator swaps rows, row numbers follow the formula
+	values := server.Values(spreadsheetID, sheet)
+	values[1], values[2] = values[2], values[1]
+	for _, row := range values[1:] {
+		row[0] = "=ROW()"
+		for i := 1; i < len(row); i++ {
+			if row[i] != "" {
+				row[i] = "'" + row[i]
+			}
+		}
+	}
+	server.SetValues(spreadsheetID, sheet, values)
+
+	if err := repo.Update(ctx, resolve(newProblem("100"))); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	if cell(rows[0], 1) != "200" || cell(rows[0], 5) != statusActive {
+		t.Fatalf("Row 2 is %q, want active problem 200", rows[0])
+	}
+	if cell(rows[1], 1) != "100" || cell(rows[1], 5) != statusResolved {
+		t.Fatalf("Row 3 is %q, want resolved problem 100", rows[1])
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	if err := repo.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	server.ResetRequests()
+
+	err := repo.WriteBatch(ctx, []repository.Write{
+		{Action: repository.ActionCreate, Problem: newProblem("100")},
+		{Action: repository.ActionCreate, Problem: newProblem("200")},
+		{Action: repository.ActionCreate, Problem: newProblem("300")},
+		{Action: repository.ActionUpdate, Problem: resolve(newProblem("200"))},
+		{Action: repository.ActionUpdate, Problem: resolve(newProblem("100"))},
+	})
+	if err != nil {
+		t.Fatalf("Failed write batch: %s", err)
+	}
+
+	// rows verification, update of 100 and single append of 200 and 300
+	if requests := server.Requests(); len(requests) != 3 {
+		t.Fatalf("Batch made %d requests, want 3: %q", len(requests), requests)
+	}
+
+	rows := dataRows(server)
+	want := [][2]string{{"100", statusResolved}, {"200", statusResolved}, {"300", statusActive}}
+	if len(rows) != len(want) {
+		t.Fatalf("Rows are %q, want %v", rows, want)
+	}
+	for i, row := range rows {
+		if cell(row, 1) != want[i][0] || cell(row, 5) != want[i][1] {
+			t.Fatalf("Row %d is %q, want %v", i+2, row, want[i])
+		}
+	}
+}
+
+func TestWriteBatchSkipsStaleWrites(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	versioned := func(problem *entity.Problem, version uint64) *entity.Problem {
+		problem.Version = version
+		return problem
+	}
+
+	if err := repo.Create(ctx, versioned(newProblem("100"), 1)); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	// resolution overtook the earlier update, e.g. retried after failure
+	err := repo.WriteBatch(ctx, []repository.Write{
+		{Action: repository.ActionUpdate, Problem: versioned(resolve(newProblem("100")), 3)},
+		{Action: repository.ActionUpdate, Problem: versioned(newProblem("100"), 2)},
+		{Action: repository.ActionCreate, Problem: versioned(newProblem("200"), 4)},
+	})
+	if err != nil {
+		t.Fatalf("Failed write batch: %s", err)
+	}
+
+	// stale write of the next batch is skipped too
+	if err := repo.Update(ctx, versioned(newProblem("100"), 2)); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+	err = repo.WriteBatch(ctx, []repository.Write{
+		{Action: repository.ActionDelete, Problem: versioned(newProblem("200"), 3)},
+	})
+	if err != nil {
+		t.Fatalf("Failed write batch: %s", err)
+	}
+
+	rows := dataRows(server)
+	want := [][2]string{{"100", statusResolved}, {"200", statusActive}}
+	if len(rows) != len(want) {
+		t.Fatalf("Rows are %q, want %v", rows, want)
+	}
+	for i, row := range rows {
+		if cell(row, 1) != want[i][0] || cell(row, 5) != want[i][1] {
+			t.Fatalf("Row %d is %q, want %v", i+2, row, want[i])
+		}
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	withdrawn := newProblem("100")
+	if err := repo.Create(ctx, withdrawn); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	withdrawn.IsWithdrawn = true
+	if err := repo.Update(ctx, withdrawn); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	if len(rows) != 1 || cell(rows[0], 5) != statusWithdrawn {
+		t.Fatalf("Rows are %q, want withdrawn problem 100", rows)
+	}
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	if !got.IsWithdrawn || got.IsResolved {
+		t.Fatalf("Problem is %+v, want withdrawn", *got)
+	}
+}
+
+func TestAcknowledge(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, detailsSchema)
+	ctx := context.Background()
+
+	acknowledged := newProblem("100")
+	if err := repo.Create(ctx, acknowledged); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	acknowledged.IsAcknowledged = true
+	acknowledged.Acknowledger = "Admin"
+	acknowledged.Comments = []entity.Comment{
+		{Author: "Admin", Action: "acknowledged problem", Message: "restarting camera", Acknowledgement: entity.Acknowledged, CreatedAt: time.Date(2026, 10, 18, 9, 40, 0, 0, time.UTC)},
+		{Author: "Operator", Action: "commented", CreatedAt: time.Date(2026, 10, 18, 9, 45, 0, 0, time.UTC)},
+	}
+	if err := repo.Update(ctx, acknowledged); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	comments := "18.10.2026 09:40:00 Admin acknowledged problem: restarting camera\n18.10.2026 09:45:00 Operator commented"
+	if len(rows) != 1 || cell(rows[0], 8) != "да" || cell(rows[0], 9) != "Admin" || cell(rows[0], 10) != comments {
+		t.Fatalf("Rows are %q, want problem 100 acknowledged by Admin with comments", rows)
+	}
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	if !got.IsAcknowledged || got.Acknowledger != "Admin" {
+		t.Fatalf("Problem is %+v, want acknowledged by Admin", *got)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, detailsSchema)
+	ctx := context.Background()
+
+	problem := newProblem("100")
+	problem.Severity = "High"
+	problem.Host = "cam-100.local"
+	problem.HostIP = "10.0.0.100"
+	problem.OperationalData = "loss 100%"
+	problem.Tags = []entity.Tag{{Name: "scope", Value: "availability"}, {Name: "camera"}}
+	if err := repo.Create(ctx, problem); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	custom := newProblem("101")
+	custom.Severity = "Critical"
+	if err := repo.Create(ctx, custom); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	rows := dataRows(server)
+	want := []string{"4 High", "cam-100.local", "10.0.0.100", "loss 100%", "scope:availability, camera"}
+	if len(rows) != 2 || strings.Join(rows[0][11:], "|") != strings.Join(want, "|") {
+		t.Fatalf("Rows are %q, want details %q of problem 100", rows, want)
+	}
+	// severity without known level is written as is
+	if cell(rows[1], 11) != "Critical" {
+		t.Fatalf("Rows are %q, want severity Critical of problem 101", rows)
+	}
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	if got.Severity != "High" || got.Host != problem.Host || got.HostIP != problem.HostIP ||
+		got.OperationalData != problem.OperationalData || entity.FormatTags(got.Tags) != "scope:availability, camera" {
+		t.Fatalf("Problem is %+v, want details of %+v", *got, *problem)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	for _, id := range []string{"100", "200", "300"} {
+		if err := repo.Create(ctx, newProblem(id)); err != nil {
+			t.Fatalf("Failed create: %s", err)
+		}
+	}
+
+	if err := repo.Delete(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed delete: %s", err)
+	}
+	if err := repo.Delete(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed delete of missing problem: %s", err)
+	}
+
+	// rows below deleted one moved up, index follows them
+	if err := repo.Update(ctx, resolve(newProblem("300"))); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	want := [][2]string{{"200", statusActive}, {"300", statusResolved}}
+	if len(rows) != len(want) {
+		t.Fatalf("Rows are %q, want %v", rows, want)
+	}
+	for i, row := range rows {
+		if cell(row, 1) != want[i][0] || cell(row, 5) != want[i][1] {
+			t.Fatalf("Row %d is %q, want %v", i+2, row, want[i])
+		}
+	}
+}
+
+func TestWriteBatchWithDeletes(t *testing.T) {
+	server, cfg := newServer(t)
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+	ctx := context.Background()
+
+	for _, id := range []string{"100", "200", "300"} {
+		if err := repo.Create(ctx, newProblem(id)); err != nil {
+			t.Fatalf("Failed create: %s", err)
+		}
+	}
+
+	err := repo.WriteBatch(ctx, []repository.Write{
+		{Action: repository.ActionDelete, Problem: newProblem("100")},
+		{Action: repository.ActionUpdate, Problem: resolve(newProblem("300"))},
+		{Action: repository.ActionCreate, Problem: newProblem("400")},
+		{Action: repository.ActionDelete, Problem: newProblem("400")},
+		{Action: repository.ActionCreate, Problem: newProblem("500")},
+		{Action: repository.ActionDelete, Problem: newProblem("200")},
+	})
+	if err != nil {
+		t.Fatalf("Failed write batch: %s", err)
+	}
+
+	if err := repo.Update(ctx, resolve(newProblem("500"))); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	want := [][2]string{{"300", statusResolved}, {"500", statusResolved}}
+	if len(rows) != len(want) {
+		t.Fatalf("Rows are %q, want %v", rows, want)
+	}
+	for i, row := range rows {
+		if cell(row, 1) != want[i][0] || cell(row, 5) != want[i][1] {
+			t.Fatalf("Row %d is %q, want %v", i+2, row, want[i])
+		}
+	}
+}
+
+func TestManualColumnsAreKept(t *testing.T) {
+	server, cfg := newServer(t)
+	ctx := context.Background()
+
+	sheet_schema := config.GoogleSheetsSchema{
+		Columns: []config.GoogleSheetsColumn{
+			{Field: config.GoogleSheetsFieldProblemID, Name: "ID"},
+			{Annotation: entity.AnnotationAssignee, Name: "Ответственный"},
+			{Field: config.GoogleSheetsFieldStatus, Name: "Статус"},
+		},
+		StatusActive:   "open",
+		StatusResolved: "closed",
+	}
+	repo := open(t, cfg, sheet_schema)
+
+	if err := repo.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	values := server.Values(spreadsheetID, sheet)
+	values[1] = []string{"=ROW()", "'100", "Иванов", "'open"}
+	server.SetValues(spreadsheetID, sheet, values)
+
+	if err := repo.Update(ctx, resolve(newProblem("100"))); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	rows := dataRows(server)
+	want := []string{"2", "100", "Иванов", "closed"}
+	if len(rows) != 1 || strings.Join(rows[0], "|") != strings.Join(want, "|") {
+		t.Fatalf("Rows are %q, want %q", rows, want)
+	}
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	if got.Annotations[entity.AnnotationAssignee] != "Иванов" || !got.IsResolved {
+		t.Fatalf("Problem is %+v, want resolved with assignee", *got)
+	}
+}
+
+func TestDefaultLayoutOfEarlierVersion(t *testing.T) {
+	server, cfg := newServer(t)
+	ctx := context.Background()
+
+	// optional columns are not expected in sheets made with default columns
+	server.SetValues(spreadsheetID, sheet, [][]string{
+		defaultHeaders,
+		{"=ROW()", "'100", "'camera-100", "'Camera 100 is unavailable", "'18.10.2026 09:30:00", "'" + statusActive, ""},
+	})
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+
+	problem := resolve(newProblem("100"))
+	problem.Severity = "High"
+	if err := repo.Update(ctx, problem); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+
+	values := server.Values(spreadsheetID, sheet)
+	if strings.Join(values[0], "|") != strings.Join(defaultHeaders, "|") {
+		t.Fatalf("Header row is %q, want %q", values[0], defaultHeaders)
+	}
+	if rows := dataRows(server); len(rows) != 1 || len(rows[0]) != len(defaultHeaders) || cell(rows[0], 5) != statusResolved {
+		t.Fatalf("Rows are %q, want problem 100 resolved in default columns", rows)
+	}
+}
+
+func TestIncompatibleLayout(t *testing.T) {
+	server, cfg := newServer(t)
+
+	headers := append([]string{}, defaultHeaders...)
+	headers[3] = "Комментарий"
+	server.SetValues(spreadsheetID, sheet, [][]string{headers})
+
+	_, err := google_sheets.New(context.Background(), cfg, sheet, config.GoogleSheetsSchema{})
+	if err == nil || !strings.Contains(err.Error(), "column D: expected 'Описание проблемы (автоматически)', found 'Комментарий'") {
+		t.Fatalf("New returned %v, want layout difference of column D", err)
+	}
+
+	// headers must be left for operator to fix
+	if values := server.Values(spreadsheetID, sheet); values[0][3] != "Комментарий" {
+		t.Fatalf("Header row is changed to %q", values[0])
+	}
+}
+
+func TestRepairLayout(t *testing.T) {
+	server, cfg := newServer(t)
+	ctx := context.Background()
+
+	// sheet of older version without camera id column
+	server.SetValues(spreadsheetID, sheet, [][]string{
+		{"_rid", headerProblemID, "Описание проблемы (автоматически)"},
+		{"=ROW()", "'100", "'Camera 100 is unavailable"},
+	})
+
+	_, err := google_sheets.New(context.Background(), cfg, sheet, config.GoogleSheetsSchema{})
+	if err == nil || !strings.Contains(err.Error(), "column C: expected 'ID камеры (автоматически)'") {
+		t.Fatalf("New returned %v, want layout difference of column C", err)
+	}
+
+	cfg.GoogleSheetsRepairLayout = true
+	repo := open(t, cfg, config.GoogleSheetsSchema{})
+
+	values := server.Values(spreadsheetID, sheet)
+	if strings.Join(values[0], "|") != strings.Join(defaultHeaders, "|") {
+		t.Fatalf("Header row is %q, want %q", values[0], defaultHeaders)
+	}
+	if cell(values[1], 1) != "100" || cell(values[1], 2) != "" || cell(values[1], 3) != "Camera 100 is unavailable" {
+		t.Fatalf("Row 2 is %q, want description moved to column D", values[1])
+	}
+
+	if err := repo.Update(ctx, resolve(newProblem("100"))); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+	if rows := dataRows(server); len(rows) != 1 || cell(rows[0], 2) != "camera-100" {
+		t.Fatalf("Rows are %q, want problem 100 updated in place", rows)
+	}
+}
+
+func TestRotatingWritesToTabOfStartPeriod(t *testing.T) {
+	server, cfg := newServer(t)
+	ctx := context.Background()
+
+	repo, err := google_sheets.NewRotating(context.Background(), cfg, sheet+" ", config.GoogleSheetsSchema{}, "2006-01")
+	if err != nil {
+		t.Fatalf("Failed open rotating sheet: %s", err)
+	}
+	defer repo.Close(ctx)
+
+	october := newProblem("100")
+	november := newProblem("200")
+	november.StartedAt = time.Date(2026, 11, 2, 10, 0, 0, 0, time.UTC)
+
+	err = repo.WriteBatch(ctx, []repository.Write{
+		{Action: repository.ActionCreate, Problem: october},
+		{Action: repository.ActionCreate, Problem: november},
+		{Action: repository.ActionUpdate, Problem: resolve(october)},
+	})
+	if err != nil {
+		t.Fatalf("Failed write batch: %s", err)
+	}
+
+	for tab, id := range map[string]string{sheet + " 2026-10": "100", sheet + " 2026-11": "200"} {
+		values := server.Values(spreadsheetID, tab)
+		if len(values) != 2 || cell(values[1], 1) != id {
+			t.Fatalf("Tab '%s' is %q, want problem %s", tab, values, id)
+		}
+	}
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	assertProblem(t, got, resolve(october))
+
+	list, err := repo.List(ctx, repository.Filter{Limit: 1})
+	if err != nil {
+		t.Fatalf("Failed list: %s", err)
+	}
+	if len(list) != 1 || list[0].ProblemID != "200" {
+		t.Fatalf("Newest problems are %v, want only 200", list)
+	}
+}
+
+func TestRotatingOpensTabWithCallerContext(t *testing.T) {
+	server, cfg := newServer(t)
+
+	repo, err := google_sheets.NewRotating(context.Background(), cfg, sheet+" ", config.GoogleSheetsSchema{}, "2006-01")
+	if err != nil {
+		t.Fatalf("Failed open rotating sheet: %s", err)
+	}
+	defer repo.Close(context.Background())
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := repo.Create(cancelled, newProblem("100")); err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("Create returned %v, want context canceled", err)
+	}
+
+	// failed open is not kept, tab is opened once by concurrent writes
+	ctx := context.Background()
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- repo.Create(ctx, newProblem(fmt.Sprint(100+i)))
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Failed create: %s", err)
+		}
+	}
+
+	if values := server.Values(spreadsheetID, sheet+" 2026-10"); len(values) != 11 {
+		t.Fatalf("Tab has %d rows, want header and 10 problems", len(values))
+	}
+}
+
+func TestRotatingGetSkipsOldTabs(t *testing.T) {
+	server, cfg := newServer(t)
+	ctx := context.Background()
+
+	old := sheet + " 2020-01"
+	server.SetValues(spreadsheetID, old, [][]string{defaultHeaders})
+
+	repo, err := google_sheets.NewRotating(ctx, cfg, sheet+" ", config.GoogleSheetsSchema{}, "2006-01")
+	if err != nil {
+		t.Fatalf("Failed open rotating sheet: %s", err)
+	}
+	defer repo.Close(ctx)
+
+	server.ResetRequests()
+
+	if _, err := repo.Get(ctx, "100"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get returned %v, want not found", err)
+	}
+	for _, request := range server.Requests() {
+		if strings.Contains(request, "2020-01") {
+			t.Fatalf("Get opened old tab: %q", server.Requests())
+		}
+	}
+}
+
+func TestRotatingGetFindsProblemInTabOfStartMonth(t *testing.T) {
+	server, cfg := newServer(t)
+	ctx := context.Background()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "local.bolt.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed open db: %s", err)
+	}
+	defer db.Close()
+	store, err := local.New(db, sheet)
+	if err != nil {
+		t.Fatalf("Failed create local store: %s", err)
+	}
+
+	// problem started at the end of the month before the current one
+	problem := newProblem("100")
+	problem.StartedAt = time.Date(2025, 9, 30, 23, 0, 0, 0, time.UTC)
+	if err := store.Create(ctx, problem); err != nil {
+		t.Fatalf("Failed create in store: %s", err)
+	}
+	started, err := google_sheets.NewRotating(ctx, cfg, sheet+" ", config.GoogleSheetsSchema{}, "2006-01")
+	if err != nil {
+		t.Fatalf("Failed open rotating sheet: %s", err)
+	}
+	if err := started.Create(ctx, problem); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	started.Close(ctx)
+
+	// annotations of problem resolved in the next month are read after restart
+	repo, err := google_sheets.NewRotating(ctx, cfg, sheet+" ", config.GoogleSheetsSchema{}, "2006-01")
+	if err != nil {
+		t.Fatalf("Failed open rotating sheet: %s", err)
+	}
+	defer repo.Close(ctx)
+	repo.SetStore(store)
+
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	assertProblem(t, got, problem)
+
+	if _, err := repo.Get(ctx, "200"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get returned %v, want problem unknown to store not found", err)
+	}
+	if rows := server.Values(spreadsheetID, sheet+" 2025-09"); len(rows) != 2 {
+		t.Fatalf("Tab of start month has rows %q, want problem 100", rows)
+	}
+}
@@ -0,0 +1,195 @@
+package google_sheets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// rowNumberColumn is name of the first column of GoFreeDB.
+const rowNumberColumn = "_rid"
+
+// maxColumns is count of columns GoFreeDB is able to manage.
+const maxColumns = 26
+
+// layoutDiff is difference between configured and actual header row.
+type layoutDiff struct {
+	sheet    string
+	problems []string
+	// missing are positions of configured columns that are absent in the sheet
+	missing []int
+}
+
+func (d *layoutDiff) Error() string {
+	return fmt.Sprintf("Layout of sheet '%s' is incompatible with configured columns:\n  %s", d.sheet, strings.Join(d.problems, "\n  "))
+}
+
+// diffLayout compares header row with expected one. Empty header row is
+// compatible, it is written by GoFreeDB. Headers after the configured
+// columns are reported too, as GoFreeDB clears them.
+func diffLayout(sheet string, expected []string, actual []string) *layoutDiff {
+	empty := true
+	for _, header := range actual {
+		if header != "" {
+			empty = false
+		}
+	}
+	if empty {
+		return nil
+	}
+
+	diff := layoutDiff{sheet: sheet}
+
+	for i, header := range expected {
+		if i < len(actual) && actual[i] == header {
+			continue
+		}
+
+		if i >= len(actual) || actual[i] == "" {
+			diff.problems = append(diff.problems, fmt.Sprintf("column %s: expected '%s', column is missing", columnLetter(i), header))
+		} else {
+			diff.problems = append(diff.problems, fmt.Sprintf("column %s: expected '%s', found '%s'", columnLetter(i), header, actual[i]))
+		}
+
+		if !contains(actual, header) {
+			diff.missing = append(diff.missing, i)
+		}
+	}
+
+	for i := len(expected); i < len(actual) && i < maxColumns; i++ {
+		if actual[i] != "" {
+			diff.problems = append(diff.problems, fmt.Sprintf("column %s: unexpected '%s', add it to configured columns", columnLetter(i), actual[i]))
+		}
+	}
+
+	if len(diff.problems) == 0 {
+		return nil
+	}
+
+	return &diff
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLayout verifies header row of sheet before GoFreeDB rewrites it.
+// With repair, configured columns that are absent in the sheet are inserted
+// at their positions, other differences are never repaired.
+func (gs *google_sheets) checkLayout(ctx context.Context, repair bool) error {
+	expected := append([]string{rowNumberColumn}, gs.schema.columnNames()...)
+
+	properties, err := gs.properties(ctx)
+	if err != nil {
+		return err
+	}
+	if properties == nil {
+		// sheet and header row are created by GoFreeDB
+		return nil
+	}
+
+	actual, err := gs.readHeaders(ctx)
+	if err != nil {
+		return err
+	}
+
+	diff := diffLayout(gs.sheet, expected, actual)
+	if diff == nil {
+		return nil
+	}
+
+	if !repair || len(diff.missing) == 0 {
+		return diff
+	}
+
+	// sheet is changed only if inserting missing columns fixes every difference,
+	// missing columns after the last header are written by GoFreeDB
+	repaired := append([]string{}, actual...)
+	var inserts []int
+	for _, i := range diff.missing {
+		if i < len(repaired) {
+			repaired = append(repaired[:i], append([]string{expected[i]}, repaired[i:]...)...)
+			inserts = append(inserts, i)
+		} else {
+			repaired = append(repaired, expected[i])
+		}
+	}
+	if diffLayout(gs.sheet, expected, repaired) != nil {
+		return diff
+	}
+	if len(inserts) == 0 {
+		log.Printf("Repairing sheet '%s': appending missing columns", gs.sheet)
+		return nil
+	}
+
+	requests := make([]*sheets.Request, 0, len(inserts))
+	for _, i := range inserts {
+		requests = append(requests, &sheets.Request{
+			InsertDimension: &sheets.InsertDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    properties.SheetId,
+					Dimension:  "COLUMNS",
+					StartIndex: int64(i),
+					EndIndex:   int64(i) + 1,
+				},
+			},
+		})
+		log.Printf("Repairing sheet '%s': inserting column %s '%s'", gs.sheet, columnLetter(i), expected[i])
+	}
+
+	_, err = gs.spreadsheets.
+		BatchUpdate(gs.spreadsheet_id, &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("Failed insert missing columns of sheet '%s': %s", gs.sheet, err)
+	}
+
+	// headers of inserted columns are written by GoFreeDB
+	return nil
+}
+
+// properties returns properties of the tab, nil if it does not exist.
+func (gs *google_sheets) properties(ctx context.Context) (*sheets.SheetProperties, error) {
+	spreadsheet, err := gs.spreadsheets.
+		Get(gs.spreadsheet_id).
+		Fields("sheets.properties(sheetId,title)").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("Failed read sheets of spreadsheet: %s", err)
+	}
+
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties.Title == gs.sheet {
+			return sheet.Properties, nil
+		}
+	}
+	return nil, nil
+}
+
+func (gs *google_sheets) readHeaders(ctx context.Context) ([]string, error) {
+	result, err := gs.values.
+		Get(gs.spreadsheet_id, fmt.Sprintf("%s!A1:%s1", gs.sheet, columnLetter(maxColumns-1))).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("Failed read header row of sheet '%s': %s", gs.sheet, err)
+	}
+
+	var headers []string
+	if len(result.Values) > 0 {
+		for _, value := range result.Values[0] {
+			headers = append(headers, fmt.Sprint(value))
+		}
+	}
+	return headers, nil
+}
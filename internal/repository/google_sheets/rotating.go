@@ -0,0 +1,385 @@
+package google_sheets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// rotating is repository that writes problem to tab of its start period,
+// tab name is sheet followed by start time formatted with layout, e.g.
+// "2026-10" for empty sheet and "2006-01" layout. Tabs are opened on demand
+// and created with headers if missing.
+type rotating struct {
+	cfg          *config.Config
+	sheet        string
+	sheet_schema config.GoogleSheetsSchema
+	layout       string
+	location     *time.Location
+	spreadsheets *sheets.SpreadsheetsService
+	// store gives start time of problem for Get, it may be nil
+	store repository.Repository
+
+	mu       sync.Mutex
+	existing map[string]bool
+	tabs     map[string]*google_sheets
+	// opening holds tabs being opened, callers of the same tab wait for it
+	opening map[string]*tabOpening
+}
+
+// tabOpening is result of tab open, done is closed when it is set.
+type tabOpening struct {
+	done chan struct{}
+	tab  *google_sheets
+	err  error
+}
+
+func NewRotating(ctx context.Context, cfg *config.Config, sheet string, sheet_schema config.GoogleSheetsSchema, layout string) (*rotating, error) {
+	_, err := newSchema(sheet_schema)
+	if err != nil {
+		return nil, fmt.Errorf("Failed create schema of sheet '%s': %s", sheet, err)
+	}
+
+	location, err := time.LoadLocation(cfg.TelegramTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("Failed load timezone: %s", err)
+	}
+
+	auth, err := newAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := sheets.NewService(ctx, option.WithHTTPClient(auth.HTTPClient()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed create new google sheets service: %s", err)
+	}
+
+	r := rotating{
+		cfg:          cfg,
+		sheet:        sheet,
+		sheet_schema: sheet_schema,
+		layout:       layout,
+		location:     location,
+		spreadsheets: service.Spreadsheets,
+		existing:     map[string]bool{},
+		tabs:         map[string]*google_sheets{},
+		opening:      map[string]*tabOpening{},
+	}
+
+	err = r.listTabs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// SetStore sets repository that knows start time of problems, e.g. local
+// store, so Get finds problems in tabs of their start period.
+func (r *rotating) SetStore(store repository.Repository) {
+	r.store = store
+}
+
+// listTabs reads names of existing tabs of the spreadsheet.
+func (r *rotating) listTabs(ctx context.Context) error {
+	spreadsheet, err := r.spreadsheets.
+		Get(r.cfg.GoogleSheetsSpreadsheetID).
+		Fields("sheets.properties.title").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("Failed list sheets of spreadsheet: %s", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sheet := range spreadsheet.Sheets {
+		r.existing[sheet.Properties.Title] = true
+	}
+
+	return nil
+}
+
+func (r *rotating) tabName(t time.Time) string {
+	return r.sheet + t.In(r.location).Format(r.layout)
+}
+
+// tab opens tab, it is created if missing. Tab is opened once outside of
+// the lock, concurrent callers of the same tab wait for it. Failed open is
+// not kept, the next caller tries again.
+func (r *rotating) tab(ctx context.Context, name string) (*google_sheets, error) {
+	r.mu.Lock()
+	if tab, ok := r.tabs[name]; ok {
+		r.mu.Unlock()
+		return tab, nil
+	}
+	opening, ok := r.opening[name]
+	if !ok {
+		opening = &tabOpening{done: make(chan struct{})}
+		r.opening[name] = opening
+	}
+	r.mu.Unlock()
+
+	if ok {
+		select {
+		case <-opening.done:
+			return opening.tab, opening.err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Failed open sheet '%s': %w", name, ctx.Err())
+		}
+	}
+
+	opening.tab, opening.err = New(ctx, r.cfg, name, r.sheet_schema)
+
+	r.mu.Lock()
+	if opening.err == nil {
+		r.tabs[name] = opening.tab
+		r.existing[name] = true
+	}
+	delete(r.opening, name)
+	r.mu.Unlock()
+
+	close(opening.done)
+
+	return opening.tab, opening.err
+}
+
+// candidates returns names of tabs that may hold problem started at t.
+// Problem start may be computed from duration of resolved alert, so tabs of
+// neighbouring days are candidates too.
+func (r *rotating) candidates(t time.Time) []string {
+	return []string{
+		r.tabName(t),
+		r.tabName(t.Add(-24 * time.Hour)),
+		r.tabName(t.Add(24 * time.Hour)),
+	}
+}
+
+// rotatedTabs returns names of existing tabs of the sheet, the newest first.
+func (r *rotating) rotatedTabs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	periods := map[string]time.Time{}
+	for name := range r.existing {
+		period, ok := strings.CutPrefix(name, r.sheet)
+		if !ok {
+			continue
+		}
+		started_at, err := time.Parse(r.layout, period)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+		periods[name] = started_at
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return periods[names[i]].After(periods[names[j]])
+	})
+
+	return names
+}
+
+// tabOf returns candidate tab holding problem, tab of start period is
+// returned if problem is not found.
+func (r *rotating) tabOf(ctx context.Context, problem *entity.Problem) (*google_sheets, error) {
+	tab, err := r.find(ctx, problem.ProblemID, r.candidates(problem.StartedAt))
+	if err != nil || tab != nil {
+		return tab, err
+	}
+	return r.tab(ctx, r.tabName(problem.StartedAt))
+}
+
+// find returns existing tab of names holding problem, nil if not found.
+func (r *rotating) find(ctx context.Context, id string, names []string) (*google_sheets, error) {
+	for _, name := range names {
+		r.mu.Lock()
+		exists := r.existing[name]
+		r.mu.Unlock()
+
+		if !exists {
+			continue
+		}
+
+		tab, err := r.tab(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tab.index.get(id); ok {
+			return tab, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *rotating) Create(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed create problem, problem is nil")
+	}
+
+	tab, err := r.tabOf(ctx, problem)
+	if err != nil {
+		return err
+	}
+	return tab.Create(ctx, problem)
+}
+
+func (r *rotating) Update(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed update problem, problem is nil")
+	}
+
+	tab, err := r.tabOf(ctx, problem)
+	if err != nil {
+		return err
+	}
+	return tab.Update(ctx, problem)
+}
+
+func (r *rotating) Delete(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed delete problem, problem is nil")
+	}
+
+	tab, err := r.tabOf(ctx, problem)
+	if err != nil {
+		return err
+	}
+	return tab.Delete(ctx, problem)
+}
+
+// WriteBatch splits writes by tab of the first write of every problem, so
+// all writes of problem go to one tab in order.
+func (r *rotating) WriteBatch(ctx context.Context, writes []repository.Write) error {
+	var order []*google_sheets
+	tabs := map[string]*google_sheets{}
+	batches := map[*google_sheets][]repository.Write{}
+
+	for _, write := range writes {
+		if write.Problem == nil {
+			return fmt.Errorf("Failed write batch, problem is nil")
+		}
+
+		tab, ok := tabs[write.Problem.ProblemID]
+		if !ok {
+			var err error
+			tab, err = r.tabOf(ctx, write.Problem)
+			if err != nil {
+				return err
+			}
+			tabs[write.Problem.ProblemID] = tab
+		}
+
+		if _, ok := batches[tab]; !ok {
+			order = append(order, tab)
+		}
+		batches[tab] = append(batches[tab], write)
+	}
+
+	for _, tab := range order {
+		err := tab.WriteBatch(ctx, batches[tab])
+		if err != nil {
+			return fmt.Errorf("Failed write batch to sheet '%s': %s", tab.sheet, err)
+		}
+	}
+
+	return nil
+}
+
+// Get looks for problem in opened tabs, then in candidate tabs of its start
+// time from store. Problem unknown to store is looked for in candidate tabs
+// of problems started now, older tabs are not opened for it.
+func (r *rotating) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	r.mu.Lock()
+	opened := make([]*google_sheets, 0, len(r.tabs))
+	for _, tab := range r.tabs {
+		opened = append(opened, tab)
+	}
+	r.mu.Unlock()
+
+	for _, tab := range opened {
+		if _, ok := tab.index.get(id); ok {
+			return tab.Get(ctx, id)
+		}
+	}
+
+	started_at := time.Now()
+	if r.store != nil {
+		known, err := r.store.Get(ctx, id)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, fmt.Errorf("Failed get start time of problem '%s': %s", id, err)
+		}
+		if known != nil {
+			started_at = known.StartedAt
+		}
+	}
+
+	tab, err := r.find(ctx, id, r.candidates(started_at))
+	if err != nil {
+		return nil, err
+	}
+	if tab != nil {
+		return tab.Get(ctx, id)
+	}
+
+	return nil, fmt.Errorf("Failed get problem '%s': %w", id, repository.ErrNotFound)
+}
+
+// List returns problems of tabs from the newest.
+func (r *rotating) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	problems := []*entity.Problem{}
+
+	for _, name := range r.rotatedTabs() {
+		tab, err := r.tab(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		tab_filter := filter
+		if filter.Limit > 0 {
+			tab_filter.Limit = filter.Limit - len(problems)
+		}
+
+		tab_problems, err := tab.List(ctx, tab_filter)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, tab_problems...)
+
+		if filter.Limit > 0 && len(problems) >= filter.Limit {
+			break
+		}
+	}
+
+	return problems, nil
+}
+
+func (r *rotating) Close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var first error
+	for _, tab := range r.tabs {
+		err := tab.Close(ctx)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
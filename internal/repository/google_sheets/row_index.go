@@ -0,0 +1,143 @@
+package google_sheets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+)
+
+// rowIndex maps problem id to number of its row in the sheet, so writes go
+// straight to the row instead of querying the sheet. It may be stale when
+// operators move rows, so row is verified before write. Index is warmed from
+// the sheet at start, persisted index is only a fallback if the sheet can not
+// be read, it is saved after every write. Index also keeps the last written
+// version of every problem, it is kept in memory only.
+type rowIndex struct {
+	mu       sync.Mutex
+	path     string
+	rows     map[string]int64
+	versions map[string]uint64
+}
+
+// newRowIndex creates index persisted to path, empty path keeps it in memory only.
+func newRowIndex(path string) *rowIndex {
+	return &rowIndex{
+		path:     path,
+		rows:     map[string]int64{},
+		versions: map[string]uint64{},
+	}
+}
+
+func (i *rowIndex) get(id string) (int64, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	row, ok := i.rows[id]
+	return row, ok
+}
+
+func (i *rowIndex) set(id string, row int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.rows[id] = row
+}
+
+// stale is true if newer version of problem is already written, problems
+// without version are never stale.
+func (i *rowIndex) stale(problem *entity.Problem) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return problem.Version != 0 && problem.Version < i.versions[problem.ProblemID]
+}
+
+// written remembers version of written problems, version of deleted
+// problem is kept too, so stale writes do not bring it back.
+func (i *rowIndex) written(problems ...*entity.Problem) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, problem := range problems {
+		if problem.Version > i.versions[problem.ProblemID] {
+			i.versions[problem.ProblemID] = problem.Version
+		}
+	}
+}
+
+// removeRow forgets problem of deleted row, rows below it move up.
+func (i *rowIndex) removeRow(row int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for id, indexed := range i.rows {
+		switch {
+		case indexed == row:
+			delete(i.rows, id)
+		case indexed > row:
+			i.rows[id] = indexed - 1
+		}
+	}
+}
+
+// reset replaces the whole index.
+func (i *rowIndex) reset(rows map[string]int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.rows = rows
+}
+
+// load reads persisted index, false if there is none.
+func (i *rowIndex) load() (bool, error) {
+	if i.path == "" {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(i.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed read row index: %s", err)
+	}
+
+	rows := map[string]int64{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return false, fmt.Errorf("Failed decode row index: %s", err)
+	}
+
+	i.reset(rows)
+
+	return true, nil
+}
+
+func (i *rowIndex) save() error {
+	if i.path == "" {
+		return nil
+	}
+
+	i.mu.Lock()
+	data, err := json.Marshal(i.rows)
+	i.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Failed encode row index: %s", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(i.path), 0700)
+	if err != nil {
+		return fmt.Errorf("Failed create directory of row index: %s", err)
+	}
+
+	err = os.WriteFile(i.path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed write row index: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,358 @@
+package google_sheets
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+)
+
+var defaultColumns = []config.GoogleSheetsColumn{
+	{Field: config.GoogleSheetsFieldProblemID, Name: "ID проблемы (автоматически)"},
+	{Field: config.GoogleSheetsFieldCameraID, Name: "ID камеры (автоматически)"},
+	{Field: config.GoogleSheetsFieldDescription, Name: "Описание проблемы (автоматически)"},
+	{Field: config.GoogleSheetsFieldStartedAt, Name: "Время возникновения проблемы (автоматически)"},
+	{Field: config.GoogleSheetsFieldStatus, Name: "Статус проблемы (автоматически)"},
+	{Field: config.GoogleSheetsFieldResolvedAt, Name: "Время устранения проблемы (автоматически)"},
+}
+
+// optionalFields are written only if configured in columns, so sheets made
+// with default columns keep their layout when fields are added.
+var optionalFields = []string{
+	config.GoogleSheetsFieldSource,
+	config.GoogleSheetsFieldAcknowledged,
+	config.GoogleSheetsFieldAcknowledgedBy,
+	config.GoogleSheetsFieldComments,
+	config.GoogleSheetsFieldSeverity,
+	config.GoogleSheetsFieldHost,
+	config.GoogleSheetsFieldHostIP,
+	config.GoogleSheetsFieldOperationalData,
+	config.GoogleSheetsFieldTags,
+}
+
+// rowNumberFormula is value of row number column of GoFreeDB.
+const rowNumberFormula = "=ROW()"
+
+// acknowledgedValue is value of acknowledged column of acknowledged problem,
+// it is empty otherwise.
+const acknowledgedValue = "да"
+
+const (
+	defaultStatusActive    = "актуальна"
+	defaultStatusResolved  = "устранена"
+	defaultStatusWithdrawn = "отозвана"
+	defaultTimeFormat      = "02.01.2006 15:04:05"
+)
+
+// schema is the single definition of sheet layout, row for insert and column
+// values for update are both generated from it. Only automatic columns are
+// written, so manual columns are never overwritten.
+type schema struct {
+	columns         []config.GoogleSheetsColumn
+	automatic       []config.GoogleSheetsColumn
+	names           map[string]string
+	annotations     map[string]string
+	statusActive    string
+	statusResolved  string
+	statusWithdrawn string
+	timeFormat      string
+}
+
+func newSchema(cfg config.GoogleSheetsSchema) (*schema, error) {
+	s := schema{
+		columns:         cfg.Columns,
+		names:           map[string]string{},
+		annotations:     map[string]string{},
+		statusActive:    cfg.StatusActive,
+		statusResolved:  cfg.StatusResolved,
+		statusWithdrawn: cfg.StatusWithdrawn,
+		timeFormat:      cfg.TimeFormat,
+	}
+
+	if len(s.columns) == 0 {
+		s.columns = defaultColumns
+	}
+	if s.statusActive == "" {
+		s.statusActive = defaultStatusActive
+	}
+	if s.statusResolved == "" {
+		s.statusResolved = defaultStatusResolved
+	}
+	if s.statusWithdrawn == "" {
+		s.statusWithdrawn = defaultStatusWithdrawn
+	}
+	if s.timeFormat == "" {
+		s.timeFormat = defaultTimeFormat
+	}
+
+	if s.statusActive == s.statusResolved {
+		return nil, fmt.Errorf("Invalid sheet schema: active and resolved statuses are both '%s'", s.statusActive)
+	}
+	if s.statusWithdrawn == s.statusActive || s.statusWithdrawn == s.statusResolved {
+		return nil, fmt.Errorf("Invalid sheet schema: withdrawn status '%s' is the same as active or resolved one", s.statusWithdrawn)
+	}
+
+	// the first column is row number of GoFreeDB
+	if len(s.columns) >= maxColumns {
+		return nil, fmt.Errorf("Invalid sheet schema: %d columns, at most %d are supported", len(s.columns), maxColumns-1)
+	}
+
+	known := map[string]bool{}
+	for _, column := range defaultColumns {
+		known[column.Field] = true
+	}
+	for _, field := range optionalFields {
+		known[field] = true
+	}
+
+	taken := map[string]bool{}
+
+	for i, column := range s.columns {
+		if column.Name == "" {
+			return nil, fmt.Errorf("Invalid sheet schema: name of column %d is empty", i)
+		}
+		if taken[column.Name] {
+			return nil, fmt.Errorf("Invalid sheet schema: column name '%s' is duplicated", column.Name)
+		}
+		taken[column.Name] = true
+
+		if column.Field != "" && column.Annotation != "" {
+			return nil, fmt.Errorf("Invalid sheet schema: column '%s' has both field and annotation", column.Name)
+		}
+
+		if column.Annotation != "" {
+			if _, ok := s.annotations[column.Annotation]; ok {
+				return nil, fmt.Errorf("Invalid sheet schema: annotation '%s' is duplicated", column.Annotation)
+			}
+			s.annotations[column.Annotation] = column.Name
+			continue
+		}
+
+		if !known[column.Field] {
+			return nil, fmt.Errorf("Invalid sheet schema: unknown field '%s' of column %d", column.Field, i)
+		}
+		if _, ok := s.names[column.Field]; ok {
+			return nil, fmt.Errorf("Invalid sheet schema: field '%s' is duplicated", column.Field)
+		}
+		s.names[column.Field] = column.Name
+		s.automatic = append(s.automatic, column)
+	}
+
+	if _, ok := s.names[config.GoogleSheetsFieldProblemID]; !ok {
+		return nil, fmt.Errorf("Invalid sheet schema: %s column is required", config.GoogleSheetsFieldProblemID)
+	}
+
+	return &s, nil
+}
+
+// columnNames returns names of automatic and manual columns in order.
+func (s *schema) columnNames() []string {
+	names := make([]string, 0, len(s.columns))
+	for _, column := range s.columns {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+// letter returns letter of column with the given name, the first column of
+// the sheet is row number column added by GoFreeDB.
+func (s *schema) letter(name string) string {
+	for i, column := range s.columns {
+		if column.Name == name {
+			return columnLetter(i + 1)
+		}
+	}
+	return ""
+}
+
+// lastLetter returns letter of the last column of the sheet.
+func (s *schema) lastLetter() string {
+	return columnLetter(len(s.columns))
+}
+
+// columnLetter returns A1 notation letter of zero based column index.
+func columnLetter(index int) string {
+	letter := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		letter = string(rune('A'+(index-1)%26)) + letter
+	}
+	return letter
+}
+
+// column returns name of field column, false if field is not in the sheet.
+func (s *schema) column(field string) (string, bool) {
+	name, ok := s.names[field]
+	return name, ok
+}
+
+func (s *schema) status(is_resolved bool) string {
+	if is_resolved {
+		return s.statusResolved
+	}
+	return s.statusActive
+}
+
+// problemStatus is status of problem, withdrawn one is neither active nor resolved.
+func (s *schema) problemStatus(problem *entity.Problem) string {
+	if problem.IsWithdrawn {
+		return s.statusWithdrawn
+	}
+	return s.status(problem.IsResolved)
+}
+
+func (s *schema) values(problem *entity.Problem) map[string]string {
+	var resolved_at string
+	if problem.ResolvedAt != nil {
+		resolved_at = problem.ResolvedAt.Format(s.timeFormat)
+	}
+
+	var acknowledged string
+	if problem.IsAcknowledged {
+		acknowledged = acknowledgedValue
+	}
+
+	return map[string]string{
+		config.GoogleSheetsFieldProblemID:       problem.ProblemID,
+		config.GoogleSheetsFieldCameraID:        problem.CameraID,
+		config.GoogleSheetsFieldDescription:     problem.Description,
+		config.GoogleSheetsFieldStartedAt:       problem.StartedAt.Format(s.timeFormat),
+		config.GoogleSheetsFieldStatus:          s.problemStatus(problem),
+		config.GoogleSheetsFieldResolvedAt:      resolved_at,
+		config.GoogleSheetsFieldSource:          problem.Source,
+		config.GoogleSheetsFieldAcknowledged:    acknowledged,
+		config.GoogleSheetsFieldAcknowledgedBy:  problem.Acknowledger,
+		config.GoogleSheetsFieldComments:        s.comments(problem.Comments),
+		config.GoogleSheetsFieldSeverity:        formatSeverity(problem.Severity),
+		config.GoogleSheetsFieldHost:            problem.Host,
+		config.GoogleSheetsFieldHostIP:          problem.HostIP,
+		config.GoogleSheetsFieldOperationalData: problem.OperationalData,
+		config.GoogleSheetsFieldTags:            entity.FormatTags(problem.Tags),
+	}
+}
+
+// formatSeverity prefixes known severity with its level, e.g. "4 High", so
+// operators can sort rows by severity, unknown severity is written as is.
+func formatSeverity(severity string) string {
+	for level, name := range entity.Severities {
+		if strings.EqualFold(name, severity) {
+			return fmt.Sprintf("%d %s", level, name)
+		}
+	}
+	return severity
+}
+
+// parseSeverity removes level added by formatSeverity.
+func parseSeverity(value string) string {
+	for level, name := range entity.Severities {
+		if value == fmt.Sprintf("%d %s", level, name) {
+			return name
+		}
+	}
+	return value
+}
+
+// comments formats comment history one comment per line, e.g.
+// "02.01.2006 15:04:05 Admin acknowledged: restarting camera".
+func (s *schema) comments(comments []entity.Comment) string {
+	lines := make([]string, 0, len(comments))
+	for _, comment := range comments {
+		line := fmt.Sprintf("%s %s %s", comment.CreatedAt.Format(s.timeFormat), comment.Author, comment.Action)
+		if comment.Message != "" {
+			line += ": " + comment.Message
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// convertProblemToRow returns row for insert, strings are escaped so sheets
+// keeps them as is, manual columns are left empty.
+func (s *schema) convertProblemToRow(problem *entity.Problem) []interface{} {
+	values := s.values(problem)
+
+	row := make([]interface{}, len(s.columns)+1)
+	row[0] = rowNumberFormula
+	for i, column := range s.columns {
+		if column.Annotation != "" {
+			continue
+		}
+		row[i+1] = escape(values[column.Field])
+	}
+
+	return row
+}
+
+// convertProblemToMap returns escaped values of automatic columns for update.
+func (s *schema) convertProblemToMap(problem *entity.Problem) map[string]interface{} {
+	values := s.values(problem)
+
+	row := make(map[string]interface{}, len(s.automatic))
+	for _, column := range s.automatic {
+		row[column.Name] = escape(values[column.Field])
+	}
+
+	return row
+}
+
+// escape makes sheets keep value as string, same as GoFreeDB does.
+func escape(value string) string {
+	return "'" + value
+}
+
+func (s *schema) convertMapToProblem(row map[string]interface{}, location *time.Location) (*entity.Problem, error) {
+	value := func(field string) string {
+		name, ok := s.names[field]
+		if !ok || row[name] == nil {
+			return ""
+		}
+		return fmt.Sprint(row[name])
+	}
+
+	problem := entity.Problem{
+		ProblemID:   value(config.GoogleSheetsFieldProblemID),
+		CameraID:    value(config.GoogleSheetsFieldCameraID),
+		Description: value(config.GoogleSheetsFieldDescription),
+		IsResolved:  value(config.GoogleSheetsFieldStatus) == s.statusResolved,
+		IsWithdrawn: value(config.GoogleSheetsFieldStatus) == s.statusWithdrawn,
+		Source:      value(config.GoogleSheetsFieldSource),
+	}
+
+	problem.IsAcknowledged = value(config.GoogleSheetsFieldAcknowledged) == acknowledgedValue
+	problem.Acknowledger = value(config.GoogleSheetsFieldAcknowledgedBy)
+
+	problem.Severity = parseSeverity(value(config.GoogleSheetsFieldSeverity))
+	problem.Host = value(config.GoogleSheetsFieldHost)
+	problem.HostIP = value(config.GoogleSheetsFieldHostIP)
+	problem.OperationalData = value(config.GoogleSheetsFieldOperationalData)
+	problem.Tags = entity.ParseTags(value(config.GoogleSheetsFieldTags))
+
+	for annotation, name := range s.annotations {
+		if row[name] == nil || fmt.Sprint(row[name]) == "" {
+			continue
+		}
+		if problem.Annotations == nil {
+			problem.Annotations = map[string]string{}
+		}
+		problem.Annotations[annotation] = fmt.Sprint(row[name])
+	}
+
+	if started_at_value := value(config.GoogleSheetsFieldStartedAt); started_at_value != "" {
+		started_at, err := time.ParseInLocation(s.timeFormat, started_at_value, location)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parse start time of problem '%s': %s", problem.ProblemID, err)
+		}
+		problem.StartedAt = started_at
+	}
+
+	if resolved_at_value := value(config.GoogleSheetsFieldResolvedAt); resolved_at_value != "" {
+		resolved_at, err := time.ParseInLocation(s.timeFormat, resolved_at_value, location)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parse resolve time of problem '%s': %s", problem.ProblemID, err)
+		}
+		problem.ResolvedAt = &resolved_at
+	}
+
+	return &problem, nil
+}
@@ -0,0 +1,189 @@
+package jsonl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+)
+
+// Record is one line of the audit file.
+type Record struct {
+	Action     string         `json:"action"`
+	Problem    entity.Problem `json:"problem"`
+	RecordedAt time.Time      `json:"recorded_at"`
+}
+
+// jsonl is append only audit file of problem changes in JSON lines format,
+// the current state of problem is its last record.
+type jsonl struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	closed bool
+}
+
+func New(path string) (*jsonl, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Failed create directory of audit file: %s", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed open audit file: %s", err)
+	}
+
+	return &jsonl{
+		path: path,
+		file: file,
+	}, nil
+}
+
+func (j *jsonl) Create(ctx context.Context, problem *entity.Problem) error {
+	return j.append("create", problem)
+}
+
+func (j *jsonl) Update(ctx context.Context, problem *entity.Problem) error {
+	return j.append("update", problem)
+}
+
+// Delete appends delete record, problem is absent in the state after it.
+func (j *jsonl) Delete(ctx context.Context, problem *entity.Problem) error {
+	return j.append("delete", problem)
+}
+
+func (j *jsonl) append(action string, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed %s problem, problem is nil", action)
+	}
+
+	line, err := json.Marshal(Record{
+		Action:     action,
+		Problem:    *problem,
+		RecordedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed encode problem '%s': %s", problem.ProblemID, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return fmt.Errorf("Failed write problem '%s', audit file is closed", problem.ProblemID)
+	}
+
+	_, err = j.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("Failed write problem '%s' to audit file: %s", problem.ProblemID, err)
+	}
+
+	return nil
+}
+
+func (j *jsonl) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	problems, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+
+	problem, ok := problems[id]
+	if !ok {
+		return nil, fmt.Errorf("Failed get problem '%s': %w", id, repository.ErrNotFound)
+	}
+
+	return problem, nil
+}
+
+// List returns problems ordered by id.
+func (j *jsonl) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	problems, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(problems))
+	for id := range problems {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	result := []*entity.Problem{}
+	for _, id := range ids {
+		problem := problems[id]
+
+		if filter.CameraID != "" && problem.CameraID != filter.CameraID {
+			continue
+		}
+		if filter.Source != "" && problem.Source != filter.Source {
+			continue
+		}
+		if filter.IsResolved != nil && (problem.IsWithdrawn || problem.IsResolved != *filter.IsResolved) {
+			continue
+		}
+
+		result = append(result, problem)
+
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// read replays the audit file into the current state of problems.
+func (j *jsonl) read() (map[string]*entity.Problem, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed open audit file: %s", err)
+	}
+	defer file.Close()
+
+	problems := map[string]*entity.Problem{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("Failed decode audit file: %s", err)
+		}
+		if record.Action == "delete" {
+			delete(problems, record.Problem.ProblemID)
+			continue
+		}
+		problems[record.Problem.ProblemID] = &record.Problem
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed read audit file: %s", err)
+	}
+
+	return problems, nil
+}
+
+// Close closes the audit file, it may be called several times as the file is
+// shared by sinks of every sheet.
+func (j *jsonl) Close(ctx context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+
+	return j.file.Close()
+}
@@ -0,0 +1,114 @@
+package jsonl_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/jsonl"
+)
+
+func ids(problems []*entity.Problem) []string {
+	result := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		result = append(result, problem.ProblemID)
+	}
+	return result
+}
+
+func TestStateIsLastRecordOfProblem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "problems.jsonl")
+	ctx := context.Background()
+
+	audit, err := jsonl.New(path)
+	if err != nil {
+		t.Fatalf("Failed create audit file: %s", err)
+	}
+
+	writes := []struct {
+		write   func(context.Context, *entity.Problem) error
+		problem entity.Problem
+	}{
+		{audit.Create, entity.Problem{ProblemID: "200", CameraID: "cam-2"}},
+		{audit.Create, entity.Problem{ProblemID: "100", CameraID: "cam-1"}},
+		{audit.Create, entity.Problem{ProblemID: "300", CameraID: "cam-3"}},
+		{audit.Update, entity.Problem{ProblemID: "100", CameraID: "cam-1", IsResolved: true}},
+		{audit.Delete, entity.Problem{ProblemID: "300", CameraID: "cam-3"}},
+	}
+	for _, write := range writes {
+		if err := write.write(ctx, &write.problem); err != nil {
+			t.Fatalf("Failed write problem '%s': %s", write.problem.ProblemID, err)
+		}
+	}
+
+	problem, err := audit.Get(ctx, "100")
+	if err != nil || !problem.IsResolved {
+		t.Fatalf("Get returned %+v, %v, want resolved problem 100", problem, err)
+	}
+	if _, err := audit.Get(ctx, "300"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get returned %v, want deleted problem not found", err)
+	}
+
+	if err := audit.Close(ctx); err != nil {
+		t.Fatalf("Failed close: %s", err)
+	}
+	// file is shared by sinks of every sheet
+	if err := audit.Close(ctx); err != nil {
+		t.Fatalf("Failed close again: %s", err)
+	}
+	if err := audit.Create(ctx, &entity.Problem{ProblemID: "400"}); err == nil {
+		t.Fatalf("Create succeeded after close")
+	}
+
+	// records are appended to the file of previous run
+	audit, err = jsonl.New(path)
+	if err != nil {
+		t.Fatalf("Failed reopen audit file: %s", err)
+	}
+	defer audit.Close(ctx)
+
+	if err := audit.Create(ctx, &entity.Problem{ProblemID: "400", CameraID: "cam-1"}); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	resolved, active := true, false
+	tests := []struct {
+		name   string
+		filter repository.Filter
+		want   []string
+	}{
+		{name: "every problem by id", want: []string{"100", "200", "400"}},
+		{name: "camera", filter: repository.Filter{CameraID: "cam-1"}, want: []string{"100", "400"}},
+		{name: "resolved", filter: repository.Filter{IsResolved: &resolved}, want: []string{"100"}},
+		{name: "active", filter: repository.Filter{IsResolved: &active}, want: []string{"200", "400"}},
+		{name: "limit", filter: repository.Filter{Limit: 2}, want: []string{"100", "200"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems, err := audit.List(ctx, test.filter)
+			if err != nil {
+				t.Fatalf("Failed list: %s", err)
+			}
+			if got := ids(problems); !slices.Equal(got, test.want) {
+				t.Fatalf("Listed %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNilProblemIsRejected(t *testing.T) {
+	audit, err := jsonl.New(filepath.Join(t.TempDir(), "problems.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed create audit file: %s", err)
+	}
+	defer audit.Close(context.Background())
+
+	if err := audit.Update(context.Background(), nil); err == nil {
+		t.Fatalf("Update of nil problem succeeded")
+	}
+}
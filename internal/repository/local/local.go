@@ -0,0 +1,516 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+const bucketPrefix = "problems_"
+
+var (
+	problemsBucket = []byte("by_id")
+	cameraBucket   = []byte("by_camera")
+	statusBucket   = []byte("by_status")
+	historyBucket  = []byte("history")
+)
+
+const (
+	statusActive    = "active"
+	statusResolved  = "resolved"
+	statusWithdrawn = "withdrawn"
+)
+
+// Event is recorded change of problem.
+type Event struct {
+	ID         uint64         `json:"-"`
+	Action     string         `json:"action"`
+	Problem    entity.Problem `json:"problem"`
+	RecordedAt time.Time      `json:"recorded_at"`
+}
+
+// local is repository of problems in bbolt, it is the system of record:
+// problems are deduplicated and queried here, while changes are passed on to
+// projections, e.g. outbox of google sheets. Change is saved only after
+// projections accept it, so failed write is tried again in full on
+// redelivery of the message. Every change is kept in history.
+type local struct {
+	db          *bbolt.DB
+	name        string
+	bucket      []byte
+	projections []repository.Repository
+	// locks serialise changes of the same problem from check to save,
+	// projection runs between them outside of bbolt transactions
+	locks problemLocks
+}
+
+// problemLocks are mutexes by problem id, mutex is kept while it is used.
+type problemLocks struct {
+	mu    sync.Mutex
+	locks map[string]*problemLock
+}
+
+type problemLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks problem id and returns its unlock.
+func (p *problemLocks) lock(id string) func() {
+	p.mu.Lock()
+	lock, ok := p.locks[id]
+	if !ok {
+		lock = &problemLock{}
+		p.locks[id] = lock
+	}
+	lock.refs++
+	p.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		p.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(p.locks, id)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// New opens store with the given name in db, db is shared and is not closed by Close.
+func New(db *bbolt.DB, name string, projections ...repository.Repository) (*local, error) {
+	l := local{
+		db:          db,
+		name:        name,
+		bucket:      []byte(bucketPrefix + name),
+		projections: projections,
+		locks:       problemLocks{locks: map[string]*problemLock{}},
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(l.bucket)
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{problemsBucket, cameraBucket, statusBucket, historyBucket} {
+			_, err := bucket.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed create local store '%s': %s", name, err)
+	}
+
+	return &l, nil
+}
+
+func (l *local) Create(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed create problem, problem is nil")
+	}
+
+	unlock := l.locks.lock(problem.ProblemID)
+	defer unlock()
+
+	exists := func(bucket *bbolt.Bucket) error {
+		if bucket.Bucket(problemsBucket).Get([]byte(problem.ProblemID)) != nil {
+			return fmt.Errorf("Failed create problem with '%s' id: %w", problem.ProblemID, repository.ErrAlreadyExists)
+		}
+		return nil
+	}
+
+	versioned, err := l.version(problem, exists)
+	if err != nil {
+		return err
+	}
+
+	err = l.project(ctx, ActionCreate, versioned)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(l.bucket)
+
+		err := exists(bucket)
+		if err != nil {
+			return err
+		}
+
+		return put(bucket, ActionCreate, versioned)
+	})
+}
+
+// Update replaces problem, problem is created if it does not exist.
+func (l *local) Update(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed update problem, problem is nil")
+	}
+
+	unlock := l.locks.lock(problem.ProblemID)
+	defer unlock()
+
+	versioned, err := l.version(problem, nil)
+	if err != nil {
+		return err
+	}
+
+	err = l.project(ctx, ActionUpdate, versioned)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		return put(tx.Bucket(l.bucket), ActionUpdate, versioned)
+	})
+}
+
+// Delete removes problem, its history is kept and ends with delete event.
+// Projections get stored state of problem.
+func (l *local) Delete(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed delete problem, problem is nil")
+	}
+
+	unlock := l.locks.lock(problem.ProblemID)
+	defer unlock()
+
+	known, err := l.Get(ctx, problem.ProblemID)
+	if err != nil {
+		return fmt.Errorf("Failed delete problem '%s': %w", problem.ProblemID, err)
+	}
+
+	known, err = l.version(known, nil)
+	if err != nil {
+		return err
+	}
+
+	err = l.project(ctx, ActionDelete, known)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(l.bucket)
+
+		known, err := get(bucket, []byte(problem.ProblemID))
+		if err != nil {
+			return err
+		}
+		if known == nil {
+			return fmt.Errorf("Failed delete problem '%s': %w", problem.ProblemID, repository.ErrNotFound)
+		}
+
+		err = unindex(bucket, known)
+		if err != nil {
+			return err
+		}
+		err = bucket.Bucket(problemsBucket).Delete([]byte(problem.ProblemID))
+		if err != nil {
+			return err
+		}
+
+		return record(bucket, ActionDelete, known)
+	})
+}
+
+// version returns copy of problem with the next version of the store, check
+// is run in the same transaction before version is taken. Version of failed
+// change is not reused, so versions grow but may have gaps.
+func (l *local) version(problem *entity.Problem, check func(bucket *bbolt.Bucket) error) (*entity.Problem, error) {
+	versioned := *problem
+
+	err := l.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(l.bucket)
+
+		if check != nil {
+			err := check(bucket)
+			if err != nil {
+				return err
+			}
+		}
+
+		version, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		versioned.Version = version
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &versioned, nil
+}
+
+// project passes change to projections before it is saved, change is not
+// saved if any projection fails. Projections that accepted it get it again
+// on retry, so they must tolerate repeated writes.
+func (l *local) project(ctx context.Context, action string, problem *entity.Problem) error {
+	for _, projection := range l.projections {
+		var err error
+		switch action {
+		case ActionCreate:
+			err = projection.Create(ctx, problem)
+		case ActionDelete:
+			err = projection.Delete(ctx, problem)
+		default:
+			err = projection.Update(ctx, problem)
+		}
+		if err != nil {
+			return fmt.Errorf("Problem '%s' is not saved in local store '%s', failed %s projection: %w", problem.ProblemID, l.name, action, err)
+		}
+	}
+	return nil
+}
+
+func (l *local) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	var problem *entity.Problem
+
+	err := l.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		problem, err = get(tx.Bucket(l.bucket), []byte(id))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed get problem '%s': %s", id, err)
+	}
+
+	if problem == nil {
+		return nil, fmt.Errorf("Failed get problem '%s': %w", id, repository.ErrNotFound)
+	}
+
+	return problem, nil
+}
+
+// List returns problems ordered by id, camera and status filters are served by indexes.
+func (l *local) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	problems := []*entity.Problem{}
+
+	err := l.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(l.bucket)
+
+		match := func(id []byte) (bool, error) {
+			problem, err := get(bucket, id)
+			if err != nil {
+				return false, err
+			}
+			if problem == nil {
+				return true, nil
+			}
+
+			if filter.CameraID != "" && problem.CameraID != filter.CameraID {
+				return true, nil
+			}
+			if filter.Source != "" && problem.Source != filter.Source {
+				return true, nil
+			}
+			if filter.IsResolved != nil && (problem.IsWithdrawn || problem.IsResolved != *filter.IsResolved) {
+				return true, nil
+			}
+
+			problems = append(problems, problem)
+
+			return filter.Limit <= 0 || len(problems) < filter.Limit, nil
+		}
+
+		switch {
+		case filter.CameraID != "":
+			return scanIndex(bucket.Bucket(cameraBucket), filter.CameraID, match)
+		case filter.IsResolved != nil:
+			return scanIndex(bucket.Bucket(statusBucket), status(*filter.IsResolved), match)
+		}
+
+		cursor := bucket.Bucket(problemsBucket).Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			next, err := match(k)
+			if err != nil || !next {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed list problems: %s", err)
+	}
+
+	return problems, nil
+}
+
+// History returns every recorded change of problem, oldest first.
+func (l *local) History(ctx context.Context, id string) ([]Event, error) {
+	events := []Event{}
+
+	err := l.db.View(func(tx *bbolt.Tx) error {
+		prefix := indexKey(id, nil)
+
+		cursor := tx.Bucket(l.bucket).Bucket(historyBucket).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			event := Event{}
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			event.ID = binary.BigEndian.Uint64(k[len(prefix):])
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed read history of problem '%s': %s", id, err)
+	}
+
+	return events, nil
+}
+
+// Close closes projections, db is left open.
+func (l *local) Close(ctx context.Context) error {
+	for _, projection := range l.projections {
+		err := projection.Close(ctx)
+		if err != nil {
+			log.Printf("Failed close projection of local store '%s': %s", l.name, err)
+		}
+	}
+	return nil
+}
+
+func put(bucket *bbolt.Bucket, action string, problem *entity.Problem) error {
+	id := []byte(problem.ProblemID)
+
+	known, err := get(bucket, id)
+	if err != nil {
+		return err
+	}
+	if known != nil {
+		err = unindex(bucket, known)
+		if err != nil {
+			return err
+		}
+	}
+
+	value, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Bucket(problemsBucket).Put(id, value)
+	if err != nil {
+		return err
+	}
+	err = bucket.Bucket(cameraBucket).Put(indexKey(problem.CameraID, id), nil)
+	if err != nil {
+		return err
+	}
+	err = bucket.Bucket(statusBucket).Put(indexKey(problemStatus(problem), id), nil)
+	if err != nil {
+		return err
+	}
+
+	return record(bucket, action, problem)
+}
+
+// unindex removes problem from camera and status indexes.
+func unindex(bucket *bbolt.Bucket, problem *entity.Problem) error {
+	id := []byte(problem.ProblemID)
+	err := bucket.Bucket(cameraBucket).Delete(indexKey(problem.CameraID, id))
+	if err != nil {
+		return err
+	}
+	return bucket.Bucket(statusBucket).Delete(indexKey(problemStatus(problem), id))
+}
+
+// record appends event to history of problem.
+func record(bucket *bbolt.Bucket, action string, problem *entity.Problem) error {
+	history := bucket.Bucket(historyBucket)
+
+	seq, err := history.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(Event{
+		Action:     action,
+		Problem:    *problem,
+		RecordedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// history is keyed by problem id and sequence, so changes of problem are adjacent and ordered
+	key := indexKey(problem.ProblemID, binary.BigEndian.AppendUint64(nil, seq))
+
+	return history.Put(key, event)
+}
+
+func get(bucket *bbolt.Bucket, id []byte) (*entity.Problem, error) {
+	value := bucket.Bucket(problemsBucket).Get(id)
+	if value == nil {
+		return nil, nil
+	}
+
+	problem := entity.Problem{}
+	if err := json.Unmarshal(value, &problem); err != nil {
+		return nil, fmt.Errorf("Failed decode problem '%s': %s", id, err)
+	}
+	return &problem, nil
+}
+
+// scanIndex calls match for ids indexed under value until match returns false.
+func scanIndex(index *bbolt.Bucket, value string, match func(id []byte) (bool, error)) error {
+	prefix := indexKey(value, nil)
+
+	cursor := index.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		next, err := match(k[len(prefix):])
+		if err != nil || !next {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexKey is value and id separated by zero byte, so ids of one value are adjacent.
+func indexKey(value string, id []byte) []byte {
+	key := make([]byte, 0, len(value)+1+len(id))
+	key = append(key, value...)
+	key = append(key, 0)
+	return append(key, id...)
+}
+
+// problemStatus is status of problem in index, withdrawn problem is neither active nor resolved.
+func problemStatus(problem *entity.Problem) string {
+	if problem.IsWithdrawn {
+		return statusWithdrawn
+	}
+	return status(problem.IsResolved)
+}
+
+func status(is_resolved bool) string {
+	if is_resolved {
+		return statusResolved
+	}
+	return statusActive
+}
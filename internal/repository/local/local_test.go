@@ -0,0 +1,272 @@
+package local_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/fanout"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/local"
+
+	"go.etcd.io/bbolt"
+)
+
+// flakySink fails the first failures writes, then keeps problems in memory,
+// writes counts accepted writes, every write takes delay.
+type flakySink struct {
+	failures int
+	delay    time.Duration
+	writes   int
+	problems map[string]*entity.Problem
+}
+
+func (s *flakySink) write(problem *entity.Problem) error {
+	time.Sleep(s.delay)
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink is unavailable")
+	}
+	s.writes++
+	stored := *problem
+	s.problems[problem.ProblemID] = &stored
+	return nil
+}
+
+func (s *flakySink) Create(ctx context.Context, problem *entity.Problem) error {
+	return s.write(problem)
+}
+
+func (s *flakySink) Update(ctx context.Context, problem *entity.Problem) error {
+	return s.write(problem)
+}
+
+func (s *flakySink) Delete(ctx context.Context, problem *entity.Problem) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink is unavailable")
+	}
+	delete(s.problems, problem.ProblemID)
+	return nil
+}
+
+func (s *flakySink) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	problem, ok := s.problems[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return problem, nil
+}
+
+func (s *flakySink) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	return nil, nil
+}
+
+func (s *flakySink) Close(ctx context.Context) error {
+	return nil
+}
+
+func open(t *testing.T, sink *flakySink) repository.Repository {
+	t.Helper()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "local.bolt.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed open db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	sinks, err := fanout.New(fanout.Sink{Name: "sheet", Policy: fanout.PolicyRequired, Repo: sink})
+	if err != nil {
+		t.Fatalf("Failed create fanout: %s", err)
+	}
+
+	store, err := local.New(db, "problems", sinks)
+	if err != nil {
+		t.Fatalf("Failed create local store: %s", err)
+	}
+
+	return store
+}
+
+func newProblem(id string) *entity.Problem {
+	return &entity.Problem{
+		ProblemID:   id,
+		CameraID:    "camera-" + id,
+		Description: "Camera " + id + " is unavailable",
+		StartedAt:   time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestRequiredSinkRecoversOnRetry(t *testing.T) {
+	sink := &flakySink{failures: 1, problems: map[string]*entity.Problem{}}
+	store := open(t, sink)
+	ctx := context.Background()
+
+	var sink_err *fanout.Error
+	if err := store.Create(ctx, newProblem("100")); !errors.As(err, &sink_err) {
+		t.Fatalf("Create returned %v, want required sink error", err)
+	}
+	if _, err := store.Get(ctx, "100"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get returned %v, want problem not saved after sink failure", err)
+	}
+
+	// redelivered message creates problem again
+	if err := store.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create on retry: %s", err)
+	}
+	if _, err := store.Get(ctx, "100"); err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	if _, ok := sink.problems["100"]; !ok {
+		t.Fatalf("Required sink has no problem 100 after retry")
+	}
+
+	if err := store.Create(ctx, newProblem("100")); !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Fatalf("Create returned %v, want already exists", err)
+	}
+
+	sink.failures = 1
+	if err := store.Delete(ctx, newProblem("100")); !errors.As(err, &sink_err) {
+		t.Fatalf("Delete returned %v, want required sink error", err)
+	}
+	if _, err := store.Get(ctx, "100"); err != nil {
+		t.Fatalf("Problem is deleted after sink failure: %s", err)
+	}
+	if err := store.Delete(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed delete on retry: %s", err)
+	}
+	if _, ok := sink.problems["100"]; ok {
+		t.Fatalf("Required sink keeps problem 100 after delete")
+	}
+}
+
+func TestChangesAreVersioned(t *testing.T) {
+	sink := &flakySink{problems: map[string]*entity.Problem{}}
+	store := open(t, sink)
+	ctx := context.Background()
+
+	if err := store.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	created, err := store.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+
+	if err := store.Update(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+	updated, err := store.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+
+	if created.Version == 0 || updated.Version <= created.Version {
+		t.Fatalf("Versions are %d then %d, want growing versions", created.Version, updated.Version)
+	}
+	if sink.problems["100"].Version != updated.Version {
+		t.Fatalf("Sink got version %d, want %d", sink.problems["100"].Version, updated.Version)
+	}
+}
+
+func TestConcurrentCreatesProjectOnce(t *testing.T) {
+	sink := &flakySink{delay: 10 * time.Millisecond, problems: map[string]*entity.Problem{}}
+	store := open(t, sink)
+	ctx := context.Background()
+
+	// redelivered message is handled again while the first one is projected
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = store.Create(ctx, newProblem("100"))
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, repository.ErrAlreadyExists):
+			t.Fatalf("Create returned %v, want already exists", err)
+		}
+	}
+	if created != 1 || sink.writes != 1 {
+		t.Fatalf("Problem is created %d times and projected %d times, want once", created, sink.writes)
+	}
+}
+
+func TestFailedRequiredSinkLeavesStoreUnchanged(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "local.bolt.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed open db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	required := &flakySink{failures: 1, problems: map[string]*entity.Problem{}}
+	best_effort := &flakySink{failures: 1, problems: map[string]*entity.Problem{}}
+
+	sinks, err := fanout.New(
+		fanout.Sink{Name: "sheet", Policy: fanout.PolicyRequired, Repo: required},
+		fanout.Sink{Name: "audit", Policy: fanout.PolicyBestEffort, Repo: best_effort},
+	)
+	if err != nil {
+		t.Fatalf("Failed create fanout: %s", err)
+	}
+
+	store, err := local.New(db, "problems", sinks)
+	if err != nil {
+		t.Fatalf("Failed create local store: %s", err)
+	}
+	ctx := context.Background()
+
+	// both sinks fail, only failure of required one fails the write
+	var sink_err *fanout.Error
+	if err := store.Create(ctx, newProblem("100")); !errors.As(err, &sink_err) {
+		t.Fatalf("Create returned %v, want required sink error", err)
+	}
+	if _, err := store.Get(ctx, "100"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("Get returned %v, want problem not saved", err)
+	}
+	if problems, err := store.List(ctx, repository.Filter{}); err != nil || len(problems) != 0 {
+		t.Fatalf("List returned %d problems, %v, want none", len(problems), err)
+	}
+	if events, err := store.History(ctx, "100"); err != nil || len(events) != 0 {
+		t.Fatalf("History returned %+v, %v, want no events", events, err)
+	}
+
+	if err := store.Create(ctx, newProblem("100")); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+
+	// failed update keeps the saved problem and its history
+	required.failures = 1
+	resolved := newProblem("100")
+	resolved.IsResolved = true
+	if err := store.Update(ctx, resolved); !errors.As(err, &sink_err) {
+		t.Fatalf("Update returned %v, want required sink error", err)
+	}
+	if problem, err := store.Get(ctx, "100"); err != nil || problem.IsResolved {
+		t.Fatalf("Get returned %+v, %v, want active problem", problem, err)
+	}
+	if events, err := store.History(ctx, "100"); err != nil || len(events) != 1 || events[0].Action != repository.ActionCreate {
+		t.Fatalf("History returned %+v, %v, want only create", events, err)
+	}
+
+	// failure of best effort sink alone does not stop the write
+	best_effort.failures = 1
+	if err := store.Update(ctx, resolved); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+	if problem, err := store.Get(ctx, "100"); err != nil || !problem.IsResolved {
+		t.Fatalf("Get returned %+v, %v, want resolved problem", problem, err)
+	}
+}
@@ -0,0 +1,631 @@
+package outbox
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+const (
+	bucketPrefix = "outbox_"
+	// deadPrefix is prefix of buckets of dead letters, entries that failed
+	// MaxAttempts times and are parked until requeued
+	deadPrefix = "dead_letter_"
+	minBackoff = time.Second
+	maxBackoff = 10 * time.Minute
+)
+
+// Entry is problem event waiting to be written to the target repository.
+type Entry struct {
+	ID            uint64         `json:"-"`
+	Action        string         `json:"action"`
+	Problem       entity.Problem `json:"problem"`
+	Attempts      int            `json:"attempts"`
+	LastError     string         `json:"last_error"`
+	CreatedAt     time.Time      `json:"created_at"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	// DeadAt is when entry was parked as dead letter, zero for pending entry.
+	DeadAt time.Time `json:"dead_at,omitempty"`
+}
+
+const (
+	defaultBatchSize   = 100
+	defaultMaxAttempts = 20
+)
+
+// Options configure batching of events for target repository that implements
+// repository.BatchWriter, they are ignored for other repositories.
+type Options struct {
+	// BatchSize is max count of events written at once, default is 100.
+	BatchSize int
+	// BatchWindow is how long events are collected before write if there
+	// are less than BatchSize of them.
+	BatchWindow time.Duration
+	// MaxAttempts is count of failed writes after which event is parked as
+	// dead letter, so it does not block later events, default is 20. The
+	// last attempt is made without batch, so only the failing event is parked.
+	MaxAttempts int
+}
+
+// Outbox is repository that durably records problem events in bbolt and
+// writes them to the target repository in background, retrying failed writes
+// with exponential backoff. Events are written in order they were recorded,
+// one by one or in batches if target repository supports it. Event that keeps
+// failing is parked as dead letter until requeued.
+type Outbox struct {
+	db      *bbolt.DB
+	name    string
+	bucket  []byte
+	dead    []byte
+	repo    repository.Repository
+	options Options
+	notify  chan struct{}
+}
+
+func New(db *bbolt.DB, name string, repo repository.Repository, options Options) (*Outbox, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultMaxAttempts
+	}
+
+	o := Outbox{
+		db:      db,
+		name:    name,
+		bucket:  []byte(bucketPrefix + name),
+		dead:    []byte(deadPrefix + name),
+		repo:    repo,
+		options: options,
+		notify:  make(chan struct{}, 1),
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(o.bucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(o.dead)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed create outbox '%s': %s", name, err)
+	}
+
+	return &o, nil
+}
+
+func (o *Outbox) Create(ctx context.Context, problem *entity.Problem) error {
+	return o.enqueue(ActionCreate, problem)
+}
+
+func (o *Outbox) Update(ctx context.Context, problem *entity.Problem) error {
+	return o.enqueue(ActionUpdate, problem)
+}
+
+func (o *Outbox) Delete(ctx context.Context, problem *entity.Problem) error {
+	return o.enqueue(ActionDelete, problem)
+}
+
+// Get reads the target repository, pending events are not visible yet.
+func (o *Outbox) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	return o.repo.Get(ctx, id)
+}
+
+// List reads the target repository, pending events are not visible yet.
+func (o *Outbox) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	return o.repo.List(ctx, filter)
+}
+
+// Close closes the target repository, Run must be stopped before.
+func (o *Outbox) Close(ctx context.Context) error {
+	return o.repo.Close(ctx)
+}
+
+func (o *Outbox) enqueue(action string, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed %s problem, problem is nil", action)
+	}
+
+	entry := Entry{
+		Action:    action,
+		Problem:   *problem,
+		CreatedAt: time.Now(),
+	}
+
+	var behind *Entry
+	err := o.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(o.bucket)
+		dead := tx.Bucket(o.dead)
+
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		// events of problem are kept in order, so event is parked behind
+		// dead letter of the problem until they are requeued together
+		behind, err = firstOf(dead, problem.ProblemID)
+		if err != nil {
+			return err
+		}
+		if behind != nil {
+			park(&entry, behind, entry.CreatedAt)
+			return putEntry(dead, id, &entry)
+		}
+
+		return putEntry(bucket, id, &entry)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed record problem '%s' in outbox '%s': %s", problem.ProblemID, o.name, err)
+	}
+
+	if behind != nil {
+		log.Printf("WARNING: %s of problem '%s' is parked in outbox '%s' behind dead letter #%d", action, problem.ProblemID, o.name, behind.ID)
+		return nil
+	}
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run writes recorded events until ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+	batch_writer, batching := o.repo.(repository.BatchWriter)
+
+	for {
+		entry, err := o.first()
+		if err != nil {
+			log.Printf("Failed read outbox '%s': %s", o.name, err)
+			if !sleep(ctx, minBackoff) {
+				return
+			}
+			continue
+		}
+
+		if entry == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.notify:
+			}
+			continue
+		}
+
+		if !sleep(ctx, time.Until(entry.NextAttemptAt)) {
+			return
+		}
+
+		// the last attempt is made alone, so failing event of batch is found
+		if batching && entry.Attempts < o.options.MaxAttempts-1 {
+			o.runBatch(ctx, batch_writer)
+			continue
+		}
+
+		err = o.apply(ctx, entry)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			log.Printf("Dropping %s of problem '%s' from outbox '%s': %s", entry.Action, entry.Problem.ProblemID, o.name, err)
+			err = nil
+		}
+
+		if err == nil {
+			err = o.delete(entry)
+		} else {
+			err = o.retryLater([]*Entry{entry}, err)
+		}
+
+		if err != nil {
+			log.Printf("Failed save outbox '%s': %s", o.name, err)
+		}
+	}
+}
+
+func (o *Outbox) apply(ctx context.Context, entry *Entry) error {
+	switch entry.Action {
+	case ActionCreate:
+		return o.repo.Create(ctx, &entry.Problem)
+	case ActionDelete:
+		return o.repo.Delete(ctx, &entry.Problem)
+	}
+	return o.repo.Update(ctx, &entry.Problem)
+}
+
+// runBatch writes the first events at once, events of batch are deleted or
+// retried together so order is kept.
+func (o *Outbox) runBatch(ctx context.Context, batch_writer repository.BatchWriter) {
+	if o.options.BatchWindow > 0 {
+		pending, err := o.Pending()
+		if err == nil && pending < o.options.BatchSize && !sleep(ctx, o.options.BatchWindow) {
+			return
+		}
+	}
+
+	entries, err := o.firstN(o.options.BatchSize)
+	if err != nil {
+		log.Printf("Failed read outbox '%s': %s", o.name, err)
+		sleep(ctx, minBackoff)
+		return
+	}
+
+	writes := make([]repository.Write, 0, len(entries))
+	for _, entry := range entries {
+		writes = append(writes, repository.Write{
+			Action:  entry.Action,
+			Problem: &entry.Problem,
+		})
+	}
+
+	err = batch_writer.WriteBatch(ctx, writes)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err == nil {
+		err = o.delete(entries...)
+	} else {
+		err = o.retryLater(entries, err)
+	}
+
+	if err != nil {
+		log.Printf("Failed save outbox '%s': %s", o.name, err)
+	}
+}
+
+// retryLater schedules the next attempt of entries, entries that failed
+// MaxAttempts times are moved to dead letters together with later pending
+// entries of the same problem.
+func (o *Outbox) retryLater(entries []*Entry, cause error) error {
+	now := time.Now()
+	for _, entry := range entries {
+		entry.Attempts++
+		entry.LastError = cause.Error()
+		entry.NextAttemptAt = now.Add(backoff(entry.Attempts))
+		if entry.Attempts >= o.options.MaxAttempts {
+			entry.DeadAt = now
+		}
+	}
+
+	err := o.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(o.bucket)
+		dead := tx.Bucket(o.dead)
+		for _, entry := range entries {
+			if entry.DeadAt.IsZero() {
+				err := putEntry(bucket, entry.ID, entry)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := bucket.Delete(encodeID(entry.ID))
+			if err != nil {
+				return err
+			}
+			err = putEntry(dead, entry.ID, entry)
+			if err != nil {
+				return err
+			}
+
+			err = parkLater(bucket, dead, entry, now)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// counts are logged as the database can not be read by outbox command
+	// while the service is running
+	pending, dead, err := o.Counts()
+	if err != nil {
+		log.Printf("Failed count outbox '%s': %s", o.name, err)
+	}
+
+	head := entries[0]
+	switch {
+	case !head.DeadAt.IsZero():
+		log.Printf("WARNING: %s of problem '%s' from outbox '%s' failed %d times, it is moved to dead letters, %d pending, %d dead: %s", head.Action, head.Problem.ProblemID, o.name, head.Attempts, pending, dead, cause)
+	case len(entries) == 1:
+		log.Printf("Failed %s problem '%s' from outbox '%s', attempt %d, retry in %s, %d pending, %d dead: %s", head.Action, head.Problem.ProblemID, o.name, head.Attempts, backoff(head.Attempts), pending, dead, cause)
+	default:
+		log.Printf("Failed write batch of %d events from outbox '%s', attempt %d, retry in %s, %d pending, %d dead: %s", len(entries), o.name, head.Attempts, backoff(head.Attempts), pending, dead, cause)
+	}
+
+	return nil
+}
+
+func backoff(attempts int) time.Duration {
+	if attempts >= 20 {
+		return maxBackoff
+	}
+	return min(minBackoff<<(attempts-1), maxBackoff)
+}
+
+// Pending returns count of events not written to the target repository yet.
+func (o *Outbox) Pending() (int, error) {
+	pending, _, err := o.Counts()
+	return pending, err
+}
+
+// Counts returns count of pending events and of dead letters.
+func (o *Outbox) Counts() (int, int, error) {
+	var pending, dead int
+
+	err := o.db.View(func(tx *bbolt.Tx) error {
+		pending = tx.Bucket(o.bucket).Stats().KeyN
+		dead = tx.Bucket(o.dead).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed count outbox '%s': %s", o.name, err)
+	}
+
+	return pending, dead, nil
+}
+
+func (o *Outbox) first() (*Entry, error) {
+	entries, err := o.firstN(1)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// firstN returns up to n oldest entries.
+func (o *Outbox) firstN(n int) ([]*Entry, error) {
+	var entries []*Entry
+
+	err := o.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(o.bucket).Cursor()
+		for k, v := cursor.First(); k != nil && len(entries) < n; k, v = cursor.Next() {
+			entry, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+func (o *Outbox) delete(entries ...*Entry) error {
+	return o.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(o.bucket)
+		for _, entry := range entries {
+			err := bucket.Delete(encodeID(entry.ID))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List returns pending entries of every outbox in db by outbox name.
+func List(db *bbolt.DB) (map[string][]Entry, error) {
+	return list(db, bucketPrefix)
+}
+
+// ListDead returns dead letters of every outbox in db by outbox name.
+func ListDead(db *bbolt.DB) (map[string][]Entry, error) {
+	return list(db, deadPrefix)
+}
+
+func list(db *bbolt.DB, prefix string) (map[string][]Entry, error) {
+	entries := map[string][]Entry{}
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			if !strings.HasPrefix(string(name), prefix) {
+				return nil
+			}
+
+			outbox_name := strings.TrimPrefix(string(name), prefix)
+			entries[outbox_name] = []Entry{}
+
+			return bucket.ForEach(func(k, v []byte) error {
+				entry, err := decodeEntry(k, v)
+				if err != nil {
+					return err
+				}
+				entries[outbox_name] = append(entries[outbox_name], *entry)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed list outbox: %s", err)
+	}
+
+	return entries, nil
+}
+
+// Requeue moves dead letters of outbox with the given ids back to the end of
+// pending events with attempts reset, every dead letter if ids are empty.
+// Every dead letter of problem of the given ids is requeued, in recorded
+// order, as later events of problem are parked behind its dead letter.
+// Returns count of requeued entries.
+func Requeue(db *bbolt.DB, name string, ids []uint64) (int, error) {
+	count := 0
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketPrefix + name))
+		dead := tx.Bucket([]byte(deadPrefix + name))
+		if bucket == nil || dead == nil {
+			return fmt.Errorf("outbox '%s' not found", name)
+		}
+
+		var letters []*Entry
+		problems := map[string]bool{}
+		err := dead.ForEach(func(k, v []byte) error {
+			entry, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			letters = append(letters, entry)
+			if len(ids) == 0 || slices.Contains(ids, entry.ID) {
+				problems[entry.Problem.ProblemID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var entries []*Entry
+		for _, entry := range letters {
+			if problems[entry.Problem.ProblemID] {
+				entries = append(entries, entry)
+			}
+		}
+
+		for _, entry := range entries {
+			err := dead.Delete(encodeID(entry.ID))
+			if err != nil {
+				return err
+			}
+
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			entry.Attempts = 0
+			entry.NextAttemptAt = time.Time{}
+			entry.DeadAt = time.Time{}
+			err = putEntry(bucket, id, entry)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed requeue dead letters: %s", err)
+	}
+
+	return count, nil
+}
+
+// firstOf returns the oldest entry of problem in bucket, nil if none.
+func firstOf(bucket *bbolt.Bucket, problem_id string) (*Entry, error) {
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		entry, err := decodeEntry(k, v)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Problem.ProblemID == problem_id {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// parkLater moves pending entries of problem of dead letter to dead letters.
+func parkLater(bucket *bbolt.Bucket, dead *bbolt.Bucket, letter *Entry, now time.Time) error {
+	var later []*Entry
+	err := bucket.ForEach(func(k, v []byte) error {
+		entry, err := decodeEntry(k, v)
+		if err != nil {
+			return err
+		}
+		if entry.Problem.ProblemID == letter.Problem.ProblemID && entry.ID > letter.ID {
+			later = append(later, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range later {
+		err := bucket.Delete(encodeID(entry.ID))
+		if err != nil {
+			return err
+		}
+		park(entry, letter, now)
+		err = putEntry(dead, entry.ID, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func park(entry *Entry, letter *Entry, now time.Time) {
+	entry.DeadAt = now
+	entry.LastError = fmt.Sprintf("parked behind dead letter #%d", letter.ID)
+}
+
+func putEntry(bucket *bbolt.Bucket, id uint64, entry *Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(encodeID(id), value)
+}
+
+func decodeEntry(k []byte, v []byte) (*Entry, error) {
+	entry := Entry{}
+	if err := json.Unmarshal(v, &entry); err != nil {
+		return nil, fmt.Errorf("Failed decode outbox entry: %s", err)
+	}
+	entry.ID = binary.BigEndian.Uint64(k)
+	return &entry, nil
+}
+
+func encodeID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+// sleep waits for d, returns false if ctx is done earlier.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,241 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/outbox"
+
+	"go.etcd.io/bbolt"
+)
+
+// rejectingRepository rejects every write of problems in rejected, e.g. cell
+// over the size limit, and keeps "<action> <id>" of the others.
+type rejectingRepository struct {
+	mu       sync.Mutex
+	rejected map[string]bool
+	written  []string
+}
+
+func (r *rejectingRepository) write(action string, problem *entity.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rejected[problem.ProblemID] {
+		return errors.New("400 bad request")
+	}
+	r.written = append(r.written, action+" "+problem.ProblemID)
+	return nil
+}
+
+func (r *rejectingRepository) accept(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.rejected, id)
+}
+
+func (r *rejectingRepository) Written() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string{}, r.written...)
+}
+
+func (r *rejectingRepository) Create(ctx context.Context, problem *entity.Problem) error {
+	return r.write(repository.ActionCreate, problem)
+}
+
+func (r *rejectingRepository) Update(ctx context.Context, problem *entity.Problem) error {
+	return r.write(repository.ActionUpdate, problem)
+}
+
+func (r *rejectingRepository) Delete(ctx context.Context, problem *entity.Problem) error {
+	return r.write(repository.ActionDelete, problem)
+}
+
+func (r *rejectingRepository) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	return nil, repository.ErrNotFound
+}
+
+func (r *rejectingRepository) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	return nil, nil
+}
+
+func (r *rejectingRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// batchRepository fails the whole batch if any write of it is rejected.
+type batchRepository struct {
+	rejectingRepository
+}
+
+func (r *batchRepository) WriteBatch(ctx context.Context, writes []repository.Write) error {
+	r.mu.Lock()
+	for _, write := range writes {
+		if r.rejected[write.Problem.ProblemID] {
+			r.mu.Unlock()
+			return errors.New("400 bad request")
+		}
+	}
+	r.mu.Unlock()
+
+	for _, write := range writes {
+		r.write(write.Action, write.Problem)
+	}
+	return nil
+}
+
+func openDB(t *testing.T) *bbolt.DB {
+	t.Helper()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "outbox.bolt.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed open db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// run writes events of outbox until written returns count, then stops it.
+func run(t *testing.T, o *outbox.Outbox, written func() int, count int) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		o.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for written() < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Outbox wrote %d events, want %d", written(), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRejectedEventIsParkedAsDeadLetter(t *testing.T) {
+	for name, repo := range map[string]interface {
+		repository.Repository
+		Written() []string
+	}{
+		"single": &rejectingRepository{rejected: map[string]bool{"100": true}},
+		"batch":  &batchRepository{rejectingRepository{rejected: map[string]bool{"100": true}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := openDB(t)
+			ctx := context.Background()
+
+			o, err := outbox.New(db, "sheet", repo, outbox.Options{MaxAttempts: 2})
+			if err != nil {
+				t.Fatalf("Failed create outbox: %s", err)
+			}
+
+			for _, id := range []string{"100", "200"} {
+				if err := o.Create(ctx, &entity.Problem{ProblemID: id}); err != nil {
+					t.Fatalf("Failed create: %s", err)
+				}
+			}
+
+			// rejected 100 does not block 200
+			run(t, o, func() int { return len(repo.Written()) }, 1)
+
+			pending, dead, err := o.Counts()
+			if err != nil || pending != 0 || dead != 1 {
+				t.Fatalf("Counts are %d pending, %d dead, %v, want only one dead", pending, dead, err)
+			}
+
+			letters, err := outbox.ListDead(db)
+			if err != nil {
+				t.Fatalf("Failed list dead letters: %s", err)
+			}
+			if len(letters["sheet"]) != 1 || letters["sheet"][0].Problem.ProblemID != "100" || letters["sheet"][0].Attempts != 2 {
+				t.Fatalf("Dead letters are %+v, want problem 100 after 2 attempts", letters["sheet"])
+			}
+
+			count, err := outbox.Requeue(db, "sheet", nil)
+			if err != nil || count != 1 {
+				t.Fatalf("Requeue returned %d, %v, want 1 requeued", count, err)
+			}
+
+			entries, err := outbox.List(db)
+			if err != nil {
+				t.Fatalf("Failed list outbox: %s", err)
+			}
+			if len(entries["sheet"]) != 1 || entries["sheet"][0].Problem.ProblemID != "100" || entries["sheet"][0].Attempts != 0 {
+				t.Fatalf("Pending entries are %+v, want requeued problem 100", entries["sheet"])
+			}
+		})
+	}
+}
+
+func TestLaterEventsAreParkedBehindDeadLetter(t *testing.T) {
+	db := openDB(t)
+	ctx := context.Background()
+	repo := &rejectingRepository{rejected: map[string]bool{"100": true}}
+
+	o, err := outbox.New(db, "sheet", repo, outbox.Options{MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("Failed create outbox: %s", err)
+	}
+
+	if err := o.Create(ctx, &entity.Problem{ProblemID: "100"}); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	// pending update is parked when create of the problem dies
+	if err := o.Update(ctx, &entity.Problem{ProblemID: "100", Severity: "High"}); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+	run(t, o, func() int {
+		_, dead, _ := o.Counts()
+		return dead
+	}, 2)
+
+	// resolution of dead problem waits for it, other problems do not
+	if err := o.Update(ctx, &entity.Problem{ProblemID: "100", IsResolved: true}); err != nil {
+		t.Fatalf("Failed update: %s", err)
+	}
+	if err := o.Create(ctx, &entity.Problem{ProblemID: "200"}); err != nil {
+		t.Fatalf("Failed create: %s", err)
+	}
+	run(t, o, func() int { return len(repo.Written()) }, 1)
+
+	pending, dead, err := o.Counts()
+	if err != nil || pending != 0 || dead != 3 {
+		t.Fatalf("Counts are %d pending, %d dead, %v, want every event of 100 dead", pending, dead, err)
+	}
+
+	letters, err := outbox.ListDead(db)
+	if err != nil {
+		t.Fatalf("Failed list dead letters: %s", err)
+	}
+
+	// requeue of the first dead letter brings back the parked updates too
+	repo.accept("100")
+	count, err := outbox.Requeue(db, "sheet", []uint64{letters["sheet"][0].ID})
+	if err != nil || count != 3 {
+		t.Fatalf("Requeue returned %d, %v, want 3 requeued", count, err)
+	}
+	run(t, o, func() int { return len(repo.Written()) }, 4)
+
+	want := []string{"create 200", "create 100", "update 100", "update 100"}
+	if written := repo.Written(); !reflect.DeepEqual(written, want) {
+		t.Fatalf("Written are %q, want %q", written, want)
+	}
+}
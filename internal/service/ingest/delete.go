@@ -0,0 +1,50 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+)
+
+// HandleDeletion applies configured policy to problem which alert message was
+// deleted from chat: problem is marked withdrawn, deleted or left as is.
+func (s *Service) HandleDeletion(ctx context.Context, problem_id string) error {
+	if s.deleted != config.DeletedMessagesWithdraw && s.deleted != config.DeletedMessagesDelete {
+		log.Printf("Alert message of problem '%s' from source '%s' is deleted, ignoring it", problem_id, s.source.Name)
+		return nil
+	}
+
+	problem, err := s.repo.Get(ctx, problem_id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed read problem '%s' of deleted message: %s", problem_id, err)
+	}
+
+	if s.deleted == config.DeletedMessagesDelete {
+		err = s.repo.Delete(ctx, problem)
+		if err != nil {
+			return fmt.Errorf("Failed delete problem '%s' of deleted message: %s", problem_id, err)
+		}
+		log.Printf("Problem '%s' from source '%s' is deleted with its alert message", problem_id, s.source.Name)
+		return nil
+	}
+
+	if problem.IsWithdrawn {
+		return nil
+	}
+
+	problem.IsWithdrawn = true
+	err = s.repo.Update(ctx, problem)
+	if err != nil {
+		return fmt.Errorf("Failed withdraw problem '%s' of deleted message: %s", problem_id, err)
+	}
+	log.Printf("Problem '%s' from source '%s' is withdrawn with its alert message", problem_id, s.source.Name)
+
+	return nil
+}
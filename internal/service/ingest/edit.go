@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+)
+
+// Change is field of problem changed by edit of its message, values are formatted.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Revision is result of edited message applied to problem, Created is set
+// if problem did not exist before the edit.
+type Revision struct {
+	ProblemID string   `json:"problem_id"`
+	Created   bool     `json:"created,omitempty"`
+	Changes   []Change `json:"changes"`
+}
+
+// HandleEdit parses edited message and reconciles stored problem with it.
+// Problem is created if it does not exist, e.g. placeholder was edited into
+// alert. Edited update adds its comment if it is new. Nil revision is returned
+// if message is not an alert or nothing changed. Edits of grouped alertmanager
+// notifications are skipped, as alertmanager never edits its messages.
+func (s *Service) HandleEdit(ctx context.Context, message string, sent_at time.Time) (*Revision, error) {
+	result, ok := s.parse(message, sent_at)
+	if !ok {
+		return nil, nil
+	}
+	if len(result.Problems) > 1 {
+		log.Printf("WARNING: skipping edit of grouped message '%s' of source '%s'", message, s.source.Name)
+		return nil, nil
+	}
+	edited := result.Problem
+
+	stored, err := s.repo.Get(ctx, edited.ProblemID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("Failed read problem of edited message '%s': %s", message, err)
+	}
+
+	if result.Kind == config.ParserTemplateKindUpdate {
+		updated, err := s.applyUpdate(ctx, edited)
+		if err != nil || updated == nil {
+			return nil, err
+		}
+		return &Revision{
+			ProblemID: updated.ProblemID,
+			Changes:   diffProblems(stored, updated),
+		}, nil
+	}
+
+	if stored == nil {
+		err := s.Apply(ctx, edited)
+		if err != nil {
+			return nil, fmt.Errorf("Failed write problem of edited message '%s' to repository: %s", message, err)
+		}
+
+		log.Printf("Problem '%s' from edited message of source '%s' successfully writed to repository", message, s.source.Name)
+
+		return &Revision{
+			ProblemID: edited.ProblemID,
+			Created:   true,
+			Changes:   diffProblems(&entity.Problem{}, edited),
+		}, nil
+	}
+
+	reconciled := reconcileProblem(stored, edited)
+
+	changes := diffProblems(stored, reconciled)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	err = s.repo.Update(ctx, reconciled)
+	if err != nil {
+		return nil, fmt.Errorf("Failed write problem of edited message '%s' to repository: %s", message, err)
+	}
+
+	log.Printf("Problem '%s' of source '%s' is changed by edited message: %v", reconciled.ProblemID, s.source.Name, changes)
+
+	return &Revision{
+		ProblemID: reconciled.ProblemID,
+		Changes:   changes,
+	}, nil
+}
+
+// reconcileProblem applies edited message as later message of the same problem,
+// except that camera, description and details of the edit replace stored ones,
+// as edits usually fix them. Edited start message does not reopen resolved problem.
+func reconcileProblem(stored *entity.Problem, edited *entity.Problem) *entity.Problem {
+	reconciled := mergeProblem(stored, edited)
+
+	if edited.CameraID != "" {
+		reconciled.CameraID = edited.CameraID
+	}
+	if edited.Description != "" {
+		reconciled.Description = edited.Description
+	}
+	if edited.Severity != "" {
+		reconciled.Severity = edited.Severity
+	}
+	if edited.Host != "" {
+		reconciled.Host = edited.Host
+	}
+	if edited.HostIP != "" {
+		reconciled.HostIP = edited.HostIP
+	}
+	if edited.OperationalData != "" {
+		reconciled.OperationalData = edited.OperationalData
+	}
+	if len(edited.Tags) > 0 {
+		reconciled.Tags = edited.Tags
+	}
+
+	return reconciled
+}
+
+// diffProblems lists parsed fields that differ, annotations are not compared.
+func diffProblems(old *entity.Problem, new *entity.Problem) []Change {
+	formatTime := func(t *time.Time) string {
+		if t == nil || t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	fields := []Change{
+		{Field: "CameraID", Old: old.CameraID, New: new.CameraID},
+		{Field: "Description", Old: old.Description, New: new.Description},
+		{Field: "StartedAt", Old: formatTime(&old.StartedAt), New: formatTime(&new.StartedAt)},
+		{Field: "IsResolved", Old: strconv.FormatBool(old.IsResolved), New: strconv.FormatBool(new.IsResolved)},
+		{Field: "ResolvedAt", Old: formatTime(old.ResolvedAt), New: formatTime(new.ResolvedAt)},
+		{Field: "Severity", Old: old.Severity, New: new.Severity},
+		{Field: "Host", Old: old.Host, New: new.Host},
+		{Field: "HostIP", Old: old.HostIP, New: new.HostIP},
+		{Field: "OperationalData", Old: old.OperationalData, New: new.OperationalData},
+		{Field: "Tags", Old: entity.FormatTags(old.Tags), New: entity.FormatTags(new.Tags)},
+		{Field: "IsAcknowledged", Old: strconv.FormatBool(old.IsAcknowledged), New: strconv.FormatBool(new.IsAcknowledged)},
+		{Field: "Acknowledger", Old: old.Acknowledger, New: new.Acknowledger},
+		{Field: "Comments", Old: strconv.Itoa(len(old.Comments)), New: strconv.Itoa(len(new.Comments))},
+	}
+
+	var changes []Change
+	for _, field := range fields {
+		if field.Old != field.New {
+			changes = append(changes, field)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,227 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/parser"
+)
+
+// Service turns alert messages into problems in repository, it is shared by
+// all telegram peer types so every chat gets the same problem lifecycle.
+type Service struct {
+	source      config.TelegramSource
+	repo        repository.Repository
+	annotations repository.Repository
+	parser      *parser.Parser
+	location    *time.Location
+	stats       *parseStats
+	// deleted is policy of problems which alert messages are deleted
+	deleted string
+}
+
+// New creates pipeline of the given source, problems are written to repo.
+// Operator annotations of resolved problems are read from annotations, it may be nil.
+func New(cfg *config.Config, source config.TelegramSource, repo repository.Repository, annotations repository.Repository) (*Service, error) {
+	location, err := time.LoadLocation(source.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Failed load timezone of source '%s': %s", source.Name, err)
+	}
+
+	problem_parser, err := parser.New(source.ParserTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("Failed create parser of source '%s': %s", source.Name, err)
+	}
+
+	return &Service{
+		source:      source,
+		repo:        repo,
+		annotations: annotations,
+		parser:      problem_parser,
+		location:    location,
+		stats:       newParseStats(source.Name, cfg.LogLevel == config.LogLevelDebug),
+		deleted:     cfg.TelegramDeletedMessages,
+	}, nil
+}
+
+// HandleMessage parses message sent at sent_at and applies parsed problems,
+// grouped alertmanager notification has several of them. Messages that are
+// not alerts are counted and ignored, no problems are returned for them and
+// for updates of problem. Resolved problems are returned with operator
+// annotations if they can be read.
+func (s *Service) HandleMessage(ctx context.Context, message string, sent_at time.Time) ([]*entity.Problem, error) {
+	result, ok := s.parse(message, sent_at)
+	if !ok {
+		return nil, nil
+	}
+
+	if result.Kind == config.ParserTemplateKindUpdate {
+		_, err := s.applyUpdate(ctx, result.Problem)
+		if err != nil {
+			return nil, fmt.Errorf("Failed write update of problem '%s' to repository: %s", message, err)
+		}
+		return nil, nil
+	}
+
+	problems := make([]*entity.Problem, 0, len(result.Problems))
+	for _, problem := range result.Problems {
+		err := s.Apply(ctx, problem)
+		if errors.Is(err, repository.ErrAlreadyExists) && result.Kind == config.ParserTemplateKindAlertmanager {
+			var reopened bool
+			reopened, err = s.reopen(ctx, problem)
+			if err == nil && !reopened {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed write problem '%s' to repository: %s", message, err)
+		}
+
+		if problem.IsResolved && s.annotations != nil {
+			annotated, err := s.annotations.Get(ctx, problem.ProblemID)
+			if err != nil {
+				log.Printf("Failed read annotations of problem '%s': %s", problem.ProblemID, err)
+			} else {
+				problem.Annotations = annotated.Annotations
+			}
+		}
+
+		problems = append(problems, problem)
+	}
+
+	log.Printf("Problem '%s' from source '%s' successfully writed to repository", message, s.source.Name)
+
+	return problems, nil
+}
+
+// parse sets source of parsed problems and times their message does not
+// carry to sent_at, start of resolved problem is left to Apply.
+func (s *Service) parse(message string, sent_at time.Time) (*parser.ParseResult, bool) {
+	result, err := s.parser.ParseProblemMessage(message, s.location)
+	if err != nil {
+		s.stats.report(message, err)
+		return nil, false
+	}
+
+	if len(result.Normalizations) > 0 {
+		log.Printf("Message '%s' from source '%s' matched template '%s' only after normalizations %v", message, s.source.Name, result.Template, result.Normalizations)
+	}
+
+	for _, problem := range result.Problems {
+		problem.Source = s.source.Name
+
+		if problem.IsResolved && problem.ResolvedAt == nil {
+			resolved_at := sent_at
+			problem.ResolvedAt = &resolved_at
+		}
+		if !problem.IsResolved && problem.StartedAt.IsZero() && result.Kind != config.ParserTemplateKindUpdate {
+			problem.StartedAt = sent_at
+		}
+	}
+
+	return result, true
+}
+
+// Apply creates started problem or resolves existing one,
+// resolved problem is created if its start was missed.
+// Acknowledgement, comments and withdrawal of existing problem are kept,
+// as well as its details missing in resolution message.
+func (s *Service) Apply(ctx context.Context, problem *entity.Problem) error {
+	if problem == nil {
+		return fmt.Errorf("Failed apply problem, problem is nil")
+	}
+
+	if !problem.IsResolved {
+		return s.repo.Create(ctx, problem)
+	}
+
+	known, err := s.repo.Get(ctx, problem.ProblemID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	mergeResolution(known, problem)
+
+	return s.repo.Update(ctx, problem)
+}
+
+// mergeResolution keeps state of known problem in its resolution, known is
+// nil if start of problem was missed.
+func mergeResolution(known *entity.Problem, problem *entity.Problem) {
+	if known != nil {
+		keepUpdates(known, problem)
+		fillDetails(problem, known)
+		if problem.StartedAt.IsZero() {
+			problem.StartedAt = known.StartedAt
+		}
+	}
+	// message carries neither start time nor duration and start was missed
+	if problem.StartedAt.IsZero() && problem.ResolvedAt != nil {
+		problem.StartedAt = *problem.ResolvedAt
+	}
+}
+
+// reopen starts new episode of resolved problem which alert fires again,
+// alertmanager alert keeps its fingerprint across episodes. Returns false if
+// problem is still active, alertmanager repeats firing alerts in every
+// notification of their group.
+func (s *Service) reopen(ctx context.Context, problem *entity.Problem) (bool, error) {
+	known, err := s.repo.Get(ctx, problem.ProblemID)
+	if err != nil {
+		return false, err
+	}
+	if !known.IsResolved && !known.IsWithdrawn {
+		return false, nil
+	}
+
+	err = s.repo.Update(ctx, problem)
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Problem '%s' from source '%s' fires again, it is reopened", problem.ProblemID, s.source.Name)
+
+	return true, nil
+}
+
+// applyUpdate adds comment of update to existing problem, update of unknown
+// problem is skipped, as its start time is unknown. Returns updated problem,
+// nil if nothing changed.
+func (s *Service) applyUpdate(ctx context.Context, update *entity.Problem) (*entity.Problem, error) {
+	known, err := s.repo.Get(ctx, update.ProblemID)
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Printf("WARNING: skipping update of unknown problem '%s' from source '%s'", update.ProblemID, s.source.Name)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updated, changed := mergeUpdate(known, update)
+	if !changed {
+		return nil, nil
+	}
+
+	err = s.repo.Update(ctx, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Problem '%s' from source '%s' is updated: %s", update.ProblemID, s.source.Name, describeComments(update.Comments))
+
+	return updated, nil
+}
+
+func (s *Service) Source() config.TelegramSource {
+	return s.source
+}
+
+// Stats returns counters of not parsed messages by kind.
+func (s *Service) Stats() string {
+	return s.stats.String()
+}
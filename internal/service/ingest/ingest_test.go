@@ -0,0 +1,180 @@
+package ingest_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
+)
+
+const (
+	problemText  = "Problem: С камеры cam-1 нет сигнала\nProblem started at 09:30:00 on 2026.10.18\nOriginal problem ID: 100"
+	updateText   = "Problem updated: С камеры cam-1 нет сигнала\nAdmin acknowledged problem at 2026.10.18 09:40:00.\nMessage: restarting camera\nOriginal problem ID: 100"
+	resolvedText = "Resolved in 15m 0s: С камеры cam-1 нет сигнала\nProblem has been resolved in 15m 0s at 09:45:00 on 2026.10.18\nOriginal problem ID: 100"
+)
+
+// handle feeds message to service and fails test on error.
+func handle(t *testing.T, service *ingest.Service, message string) []*entity.Problem {
+	t.Helper()
+
+	problems, err := service.HandleMessage(context.Background(), message, at(12, 0))
+	if err != nil {
+		t.Fatalf("Failed handle message: %s", err)
+	}
+	return problems
+}
+
+func assertWrites(t *testing.T, repo *memoryRepository, want ...string) {
+	t.Helper()
+
+	if writes := repo.Writes(); !reflect.DeepEqual(writes, want) {
+		t.Fatalf("Writes are %q, want %q", writes, want)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	repo := newMemoryRepository()
+	service := newService(t, repo)
+
+	problems := handle(t, service, problemText)
+	assertWrites(t, repo, "create 100")
+
+	want := entity.Problem{
+		ProblemID:   "100",
+		CameraID:    "cam-1",
+		Description: "нет сигнала",
+		StartedAt:   at(9, 30),
+		Source:      "zabbix",
+	}
+	if len(problems) != 1 || !reflect.DeepEqual(*problems[0], want) {
+		t.Fatalf("Problems are %+v, want %+v", problems, want)
+	}
+
+	stored, err := repo.Get(context.Background(), "100")
+	if err != nil || !reflect.DeepEqual(*stored, want) {
+		t.Fatalf("Stored problem is %+v, %v, want %+v", stored, err, want)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	repo := newMemoryRepository()
+	service := newService(t, repo)
+
+	handle(t, service, problemText)
+	handle(t, service, updateText)
+	repo.Writes()
+
+	problems := handle(t, service, resolvedText)
+	assertWrites(t, repo, "update 100")
+
+	// acknowledgement of the started problem is kept
+	resolved_at := at(9, 45)
+	want := entity.Problem{
+		ProblemID:      "100",
+		CameraID:       "cam-1",
+		Description:    "нет сигнала",
+		StartedAt:      at(9, 30),
+		IsResolved:     true,
+		ResolvedAt:     &resolved_at,
+		Source:         "zabbix",
+		IsAcknowledged: true,
+		Acknowledger:   "Admin",
+		Comments: []entity.Comment{
+			{Author: "Admin", Action: "acknowledged", Message: "restarting camera", Acknowledgement: entity.Acknowledged, CreatedAt: at(9, 40)},
+		},
+	}
+	if len(problems) != 1 || !reflect.DeepEqual(*problems[0], want) {
+		t.Fatalf("Problems are %+v, want %+v", problems, want)
+	}
+}
+
+func TestResolveWithoutStart(t *testing.T) {
+	repo := newMemoryRepository()
+	service := newService(t, repo)
+
+	// start of missed problem is computed from duration of resolution
+	handle(t, service, resolvedText)
+	assertWrites(t, repo, "update 100")
+
+	stored, err := repo.Get(context.Background(), "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	if !stored.IsResolved || !stored.StartedAt.Equal(at(9, 30)) || stored.ResolvedAt == nil || !stored.ResolvedAt.Equal(at(9, 45)) {
+		t.Fatalf("Stored problem is %+v, want resolved at 09:45 after start at 09:30", *stored)
+	}
+
+	// message that carries neither start nor duration starts at resolution
+	resolved_at := at(10, 0)
+	problem := &entity.Problem{ProblemID: "101", IsResolved: true, ResolvedAt: &resolved_at}
+	if err := service.Apply(context.Background(), problem); err != nil {
+		t.Fatalf("Failed apply: %s", err)
+	}
+	if !problem.StartedAt.Equal(resolved_at) {
+		t.Fatalf("Problem starts at %s, want %s", problem.StartedAt, resolved_at)
+	}
+}
+
+func TestDuplicateCreate(t *testing.T) {
+	repo := newMemoryRepository()
+	service := newService(t, repo)
+
+	handle(t, service, problemText)
+	handle(t, service, resolvedText)
+	repo.Writes()
+
+	// repeated zabbix alert does not reopen resolved problem
+	if _, err := service.HandleMessage(context.Background(), problemText, at(12, 0)); err == nil {
+		t.Fatalf("Duplicate create succeeded")
+	}
+	assertWrites(t, repo, "create 100")
+
+	stored, err := repo.Get(context.Background(), "100")
+	if err != nil || !stored.IsResolved {
+		t.Fatalf("Stored problem is %+v, %v, want resolved problem", stored, err)
+	}
+}
+
+func TestAlertmanagerReopen(t *testing.T) {
+	const (
+		firing   = "[FIRING:1] CameraDown\nLabels:\n - alertname = CameraDown\n - camera = cam-1\nAnnotations:\n - fingerprint = 5f3c0e1d2a4b6c7d"
+		resolved = "[RESOLVED] CameraDown\nLabels:\n - alertname = CameraDown\n - camera = cam-1\nAnnotations:\n - fingerprint = 5f3c0e1d2a4b6c7d"
+	)
+
+	repo := newMemoryRepository()
+	source := config.TelegramSource{
+		Name:            "prometheus",
+		Timezone:        "UTC",
+		ParserTemplates: []config.ParserTemplate{{Name: "alertmanager", Kind: config.ParserTemplateKindAlertmanager}},
+	}
+	service, err := ingest.New(&config.Config{}, source, repo, nil)
+	if err != nil {
+		t.Fatalf("Failed create ingest service: %s", err)
+	}
+
+	handle(t, service, firing)
+	assertWrites(t, repo, "create 5f3c0e1d2a4b6c7d")
+
+	// alertmanager repeats firing alert in every notification of its group
+	if problems := handle(t, service, firing); len(problems) != 0 {
+		t.Fatalf("Problems are %+v, want active problem skipped", problems)
+	}
+	assertWrites(t, repo, "create 5f3c0e1d2a4b6c7d")
+
+	handle(t, service, resolved)
+	assertWrites(t, repo, "update 5f3c0e1d2a4b6c7d")
+
+	// alert that fires again after resolution starts new episode
+	if problems := handle(t, service, firing); len(problems) != 1 {
+		t.Fatalf("Problems are %+v, want reopened problem", problems)
+	}
+	assertWrites(t, repo, "create 5f3c0e1d2a4b6c7d", "update 5f3c0e1d2a4b6c7d")
+
+	stored, err := repo.Get(context.Background(), "5f3c0e1d2a4b6c7d")
+	if err != nil || stored.IsResolved || stored.ResolvedAt != nil {
+		t.Fatalf("Stored problem is %+v, %v, want active problem", stored, err)
+	}
+}
@@ -0,0 +1,92 @@
+package ingest_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+)
+
+// memoryRepository keeps problems in memory and records "<action> <id>" of
+// writes, Create of existing problem fails as in local store.
+type memoryRepository struct {
+	mu       sync.Mutex
+	problems map[string]*entity.Problem
+	writes   []string
+}
+
+func newMemoryRepository(problems ...*entity.Problem) *memoryRepository {
+	r := memoryRepository{problems: map[string]*entity.Problem{}}
+	for _, problem := range problems {
+		stored := *problem
+		r.problems[problem.ProblemID] = &stored
+	}
+	return &r
+}
+
+func (r *memoryRepository) Create(ctx context.Context, problem *entity.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writes = append(r.writes, repository.ActionCreate+" "+problem.ProblemID)
+
+	if _, ok := r.problems[problem.ProblemID]; ok {
+		return fmt.Errorf("Failed create problem '%s': %w", problem.ProblemID, repository.ErrAlreadyExists)
+	}
+	stored := *problem
+	r.problems[problem.ProblemID] = &stored
+	return nil
+}
+
+func (r *memoryRepository) Update(ctx context.Context, problem *entity.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writes = append(r.writes, repository.ActionUpdate+" "+problem.ProblemID)
+
+	stored := *problem
+	r.problems[problem.ProblemID] = &stored
+	return nil
+}
+
+func (r *memoryRepository) Get(ctx context.Context, id string) (*entity.Problem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	problem, ok := r.problems[id]
+	if !ok {
+		return nil, fmt.Errorf("Failed get problem '%s': %w", id, repository.ErrNotFound)
+	}
+	found := *problem
+	return &found, nil
+}
+
+func (r *memoryRepository) List(ctx context.Context, filter repository.Filter) ([]*entity.Problem, error) {
+	return nil, nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, problem *entity.Problem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writes = append(r.writes, repository.ActionDelete+" "+problem.ProblemID)
+
+	delete(r.problems, problem.ProblemID)
+	return nil
+}
+
+func (r *memoryRepository) Close(ctx context.Context) error {
+	return nil
+}
+
+// Writes returns writes made since the previous call.
+func (r *memoryRepository) Writes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writes := r.writes
+	r.writes = nil
+	return writes
+}
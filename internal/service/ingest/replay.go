@@ -0,0 +1,207 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+)
+
+const (
+	ReplayActionCreate = "create"
+	ReplayActionUpdate = "update"
+)
+
+// ReplayMessage is historical message with time it was sent.
+type ReplayMessage struct {
+	Text   string
+	SentAt time.Time
+}
+
+type ReplayItem struct {
+	Action  string
+	Problem *entity.Problem
+}
+
+// PlanReplay parses historical messages given in chronological order and pairs
+// every problem start with its resolution, so each problem is written once
+// with its final state. Updates are merged into their problems, updates of
+// problems started before the messages are skipped.
+func (s *Service) PlanReplay(messages []ReplayMessage) []ReplayItem {
+	var order []string
+	problems := map[string]*entity.Problem{}
+
+	for _, message := range messages {
+		result, ok := s.parse(message.Text, message.SentAt)
+		if !ok {
+			continue
+		}
+
+		for _, problem := range result.Problems {
+			known, ok := problems[problem.ProblemID]
+			if result.Kind == config.ParserTemplateKindUpdate {
+				if ok {
+					problems[problem.ProblemID], _ = mergeUpdate(known, problem)
+				}
+				continue
+			}
+			if !ok {
+				order = append(order, problem.ProblemID)
+				problems[problem.ProblemID] = problem
+				continue
+			}
+
+			// alertmanager repeats firing alerts and fires resolved ones again
+			// with the same fingerprint, the latter starts new episode
+			if !problem.IsResolved && result.Kind == config.ParserTemplateKindAlertmanager {
+				if known.IsResolved {
+					problems[problem.ProblemID] = problem
+				}
+				continue
+			}
+
+			problems[problem.ProblemID] = mergeProblem(known, problem)
+		}
+	}
+
+	items := make([]ReplayItem, 0, len(order))
+	for _, problem_id := range order {
+		problem := problems[problem_id]
+		// resolution without start time whose start was missed
+		if problem.StartedAt.IsZero() && problem.ResolvedAt != nil {
+			problem.StartedAt = *problem.ResolvedAt
+		}
+
+		action := ReplayActionCreate
+		if problem.IsResolved {
+			action = ReplayActionUpdate
+		}
+
+		items = append(items, ReplayItem{
+			Action:  action,
+			Problem: problem,
+		})
+	}
+
+	return items
+}
+
+// mergeProblem applies later message of the same problem, start message has
+// exact start time, while resolution one has it computed from duration.
+func mergeProblem(known *entity.Problem, later *entity.Problem) *entity.Problem {
+	merged := *known
+
+	if later.IsResolved {
+		merged.IsResolved = true
+		merged.ResolvedAt = later.ResolvedAt
+		if known.IsResolved {
+			merged.StartedAt = later.StartedAt
+		}
+	} else if known.IsResolved {
+		merged.StartedAt = later.StartedAt
+	}
+
+	if merged.CameraID == "" {
+		merged.CameraID = later.CameraID
+	}
+	if merged.Description == "" {
+		merged.Description = later.Description
+	}
+	fillDetails(&merged, later)
+
+	return &merged
+}
+
+// fillDetails copies details of alert that problem does not have from other.
+func fillDetails(problem *entity.Problem, other *entity.Problem) {
+	if problem.Severity == "" {
+		problem.Severity = other.Severity
+	}
+	if problem.Host == "" {
+		problem.Host = other.Host
+	}
+	if problem.HostIP == "" {
+		problem.HostIP = other.HostIP
+	}
+	if problem.OperationalData == "" {
+		problem.OperationalData = other.OperationalData
+	}
+	if len(problem.Tags) == 0 {
+		problem.Tags = other.Tags
+	}
+}
+
+// ReplayStats is count of planned items by outcome of their write.
+type ReplayStats struct {
+	Written int
+	// Skipped are items already in repository, e.g. on repeated import
+	Skipped int
+	Failed  int
+}
+
+// ApplyReplay writes planned problems the way Apply does: problem already in
+// repository is merged with its stored state, so updates and details saved
+// before the import are kept. Failed items are logged and skipped, so import
+// can be repeated.
+func (s *Service) ApplyReplay(ctx context.Context, items []ReplayItem) ReplayStats {
+	stats := ReplayStats{}
+
+	for _, item := range items {
+		written, err := s.applyReplayItem(ctx, item)
+
+		switch {
+		case errors.Is(err, repository.ErrAlreadyExists) || err == nil && !written:
+			stats.Skipped++
+		case err != nil:
+			stats.Failed++
+			log.Printf("Failed %s problem '%s': %s", item.Action, item.Problem.ProblemID, err)
+		default:
+			stats.Written++
+		}
+	}
+
+	return stats
+}
+
+// applyReplayItem returns false if repository already has everything the item carries.
+func (s *Service) applyReplayItem(ctx context.Context, item ReplayItem) (bool, error) {
+	problem := item.Problem
+
+	known, err := s.repo.Get(ctx, problem.ProblemID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return true, s.repo.Create(ctx, problem)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// updates of replayed messages are added to the stored ones
+	known, changed := mergeUpdate(known, problem)
+
+	if item.Action == ReplayActionCreate {
+		// problem is started before, only new updates are written
+		if !changed {
+			return false, nil
+		}
+		return true, s.repo.Update(ctx, known)
+	}
+
+	if !changed && known.IsResolved && sameTime(known.ResolvedAt, problem.ResolvedAt) {
+		return false, nil
+	}
+
+	mergeResolution(known, problem)
+
+	return true, s.repo.Update(ctx, problem)
+}
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,145 @@
+package ingest_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2026, 10, 18, hour, minute, 0, 0, time.UTC)
+}
+
+func newService(t *testing.T, repo *memoryRepository) *ingest.Service {
+	t.Helper()
+
+	source := config.TelegramSource{Name: "zabbix", Timezone: "UTC"}
+	service, err := ingest.New(&config.Config{}, source, repo, nil)
+	if err != nil {
+		t.Fatalf("Failed create ingest service: %s", err)
+	}
+	return service
+}
+
+// replayMessages are chat history with every kind of message.
+var replayMessages = []ingest.ReplayMessage{
+	{SentAt: at(9, 30), Text: "Problem: С камеры cam-1 нет сигнала\nProblem started at 09:30:00 on 2026.10.18\nOriginal problem ID: 100"},
+	{SentAt: at(9, 40), Text: "Problem updated: С камеры cam-1 нет сигнала\nAdmin acknowledged problem at 2026.10.18 09:40:00.\nMessage: restarting camera\nOriginal problem ID: 100"},
+	{SentAt: at(9, 45), Text: "Resolved in 15m 0s: С камеры cam-1 нет сигнала\nProblem has been resolved in 15m 0s at 09:45:00 on 2026.10.18\nOriginal problem ID: 100"},
+	{SentAt: at(10, 5), Text: "Resolved in 5m 0s: Disk is full\nProblem has been resolved in 5m 0s at 10:05:00 on 2026.10.18\nOriginal problem ID: 101"},
+	{SentAt: at(10, 10), Text: "Problem: С камеры cam-2 нет сигнала\nProblem started at 10:10:00 on 2026.10.18\nOriginal problem ID: 102"},
+	{SentAt: at(10, 15), Text: "Коллеги, камера cam-1 снова в сети"},
+	{SentAt: at(10, 20), Text: "Problem updated: Disk is full\nAdmin commented problem at 2026.10.18 10:20:00.\nMessage: cleaned up\nOriginal problem ID: 99"},
+}
+
+func TestPlanReplay(t *testing.T) {
+	service := newService(t, nil)
+
+	items := service.PlanReplay(replayMessages)
+
+	resolved_100 := at(9, 45)
+	resolved_101 := at(10, 5)
+	want := []ingest.ReplayItem{
+		{
+			Action: ingest.ReplayActionUpdate,
+			Problem: &entity.Problem{
+				ProblemID:      "100",
+				CameraID:       "cam-1",
+				Description:    "нет сигнала",
+				StartedAt:      at(9, 30),
+				IsResolved:     true,
+				ResolvedAt:     &resolved_100,
+				Source:         "zabbix",
+				IsAcknowledged: true,
+				Acknowledger:   "Admin",
+				Comments: []entity.Comment{
+					{Author: "Admin", Action: "acknowledged", Message: "restarting camera", Acknowledgement: entity.Acknowledged, CreatedAt: at(9, 40)},
+				},
+			},
+		},
+		{
+			Action: ingest.ReplayActionUpdate,
+			Problem: &entity.Problem{
+				ProblemID:   "101",
+				Description: "Disk is full",
+				StartedAt:   at(10, 0),
+				IsResolved:  true,
+				ResolvedAt:  &resolved_101,
+				Source:      "zabbix",
+			},
+		},
+		{
+			Action: ingest.ReplayActionCreate,
+			Problem: &entity.Problem{
+				ProblemID:   "102",
+				CameraID:    "cam-2",
+				Description: "нет сигнала",
+				StartedAt:   at(10, 10),
+				Source:      "zabbix",
+			},
+		},
+	}
+
+	if len(items) != len(want) {
+		t.Fatalf("Plan has %d items, want %d", len(items), len(want))
+	}
+	for i := range want {
+		if items[i].Action != want[i].Action || !reflect.DeepEqual(*items[i].Problem, *want[i].Problem) {
+			t.Fatalf("Item %d is %s %+v, want %s %+v", i, items[i].Action, *items[i].Problem, want[i].Action, *want[i].Problem)
+		}
+	}
+	if stats := service.Stats(); stats != "malformed alert: 0, unknown alert kind: 0, not alert: 1" {
+		t.Fatalf("Stats are '%s', want one not alert", stats)
+	}
+}
+
+func TestApplyReplayOverExistingStore(t *testing.T) {
+	// problems written by the service before import
+	repo := newMemoryRepository(
+		&entity.Problem{
+			ProblemID:   "100",
+			CameraID:    "cam-1",
+			Description: "нет сигнала",
+			StartedAt:   at(9, 30),
+			Source:      "zabbix",
+			Severity:    "High",
+			Comments: []entity.Comment{
+				{Author: "Operator", Action: "commented", Message: "on site", CreatedAt: at(9, 35)},
+			},
+		},
+		&entity.Problem{ProblemID: "102", CameraID: "cam-2", StartedAt: at(10, 10), Source: "zabbix"},
+	)
+	service := newService(t, repo)
+	ctx := context.Background()
+
+	stats := service.ApplyReplay(ctx, service.PlanReplay(replayMessages))
+	if stats != (ingest.ReplayStats{Written: 2, Skipped: 1}) {
+		t.Fatalf("Stats are %+v, want 2 written and existing problem 102 skipped", stats)
+	}
+	if writes := repo.Writes(); !reflect.DeepEqual(writes, []string{"update 100", "create 101"}) {
+		t.Fatalf("Writes are %q, want update of 100 and create of 101", writes)
+	}
+
+	// resolution keeps updates and details saved before import
+	got, err := repo.Get(ctx, "100")
+	if err != nil {
+		t.Fatalf("Failed get: %s", err)
+	}
+	if !got.IsResolved || got.Severity != "High" || !got.IsAcknowledged || got.Acknowledger != "Admin" || len(got.Comments) != 2 || got.Comments[0].Author != "Operator" {
+		t.Fatalf("Problem is %+v, want resolved with severity and both comments", *got)
+	}
+
+	// repeated import changes nothing
+	stats = service.ApplyReplay(ctx, service.PlanReplay(replayMessages))
+	if stats != (ingest.ReplayStats{Skipped: 3}) {
+		t.Fatalf("Stats of repeated import are %+v, want every item skipped", stats)
+	}
+	if writes := repo.Writes(); len(writes) != 0 {
+		t.Fatalf("Repeated import wrote %q", writes)
+	}
+}
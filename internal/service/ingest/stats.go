@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/parser"
+)
+
+// parseStats counts messages from target chat that were not parsed, so
+// malformed alerts are not lost among ordinary chat messages. Counters are
+// logged with every malformed alert and alert of unknown kind.
+type parseStats struct {
+	mu     sync.Mutex
+	source string
+	counts map[parser.ErrorKind]int
+	debug  bool
+}
+
+func newParseStats(source string, debug bool) *parseStats {
+	return &parseStats{
+		source: source,
+		counts: map[parser.ErrorKind]int{},
+		debug:  debug,
+	}
+}
+
+func (s *parseStats) report(message string, err error) {
+	var parse_err *parser.ParseError
+	if !errors.As(err, &parse_err) {
+		log.Printf("Failed parse message '%s': %s", message, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.counts[parse_err.Kind]++
+	s.mu.Unlock()
+
+	if parse_err.Kind == parser.ErrorKindNotAlert {
+		if s.debug {
+			log.Printf("Message '%s' from target chat is not alert", message)
+		}
+		return
+	}
+
+	log.Printf("WARNING: %s in message '%s' from source '%s': %s, not parsed messages of source: %s", parse_err.Kind, message, s.source, parse_err, s)
+}
+
+func (s *parseStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kinds := []parser.ErrorKind{parser.ErrorKindMalformed, parser.ErrorKindUnknownKind, parser.ErrorKindNotAlert}
+	parts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		parts = append(parts, fmt.Sprintf("%s: %d", kind, s.counts[kind]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,49 @@
+package ingest_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNotParsedMessagesAreLoggedWithCounters(t *testing.T) {
+	service := newService(t, newMemoryRepository())
+	ctx := context.Background()
+
+	var logged bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&logged)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+
+	for _, message := range []string{
+		"Коллеги, камера cam-1 снова в сети",
+		"Problem: С камеры cam-1 нет сигнала\nStarted at 09:30:00 on 2026.10.18\nOriginal problem ID: 100",
+		"Problem is back: С камеры cam-1 нет сигнала\nOriginal problem ID: 100",
+	} {
+		if _, err := service.HandleMessage(ctx, message, at(9, 30)); err != nil {
+			t.Fatalf("Failed handle message: %s", err)
+		}
+	}
+
+	// every malformed or unknown alert is logged with running counters of source
+	warnings := strings.Split(logged.String(), "WARNING: ")[1:]
+	want := []string{
+		"not parsed messages of source: malformed alert: 1, unknown alert kind: 0, not alert: 1",
+		"not parsed messages of source: malformed alert: 1, unknown alert kind: 1, not alert: 1",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("Logged %q, want %d warnings", logged.String(), len(want))
+	}
+	for i, warning := range warnings {
+		if !strings.Contains(warning, "from source 'zabbix'") || !strings.HasSuffix(strings.TrimSpace(warning), want[i]) {
+			t.Fatalf("Warning %d is %q, want warning of source 'zabbix' ending with %q", i, warning, want[i])
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ingest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/entity"
+)
+
+// mergeUpdate adds comments of update to known problem and applies their
+// acknowledgement and severity changes, comments that are already known are skipped,
+// so redelivered update changes nothing.
+func mergeUpdate(known *entity.Problem, update *entity.Problem) (*entity.Problem, bool) {
+	merged := *known
+	merged.Comments = slices.Clone(known.Comments)
+
+	changed := false
+	for _, comment := range update.Comments {
+		if slices.ContainsFunc(merged.Comments, func(c entity.Comment) bool { return sameComment(c, comment) }) {
+			continue
+		}
+		changed = true
+
+		merged.Comments = append(merged.Comments, comment)
+
+		switch comment.Acknowledgement {
+		case entity.Acknowledged:
+			merged.IsAcknowledged = true
+			merged.Acknowledger = comment.Author
+		case entity.Unacknowledged:
+			merged.IsAcknowledged = false
+			merged.Acknowledger = ""
+		}
+
+		if comment.Severity != "" {
+			merged.Severity = comment.Severity
+		}
+	}
+
+	return &merged, changed
+}
+
+// keepUpdates copies state that alert messages do not carry from known
+// problem to problem that replaces it.
+func keepUpdates(known *entity.Problem, problem *entity.Problem) {
+	problem.IsWithdrawn = known.IsWithdrawn
+	problem.IsAcknowledged = known.IsAcknowledged
+	problem.Acknowledger = known.Acknowledger
+	problem.Comments = known.Comments
+}
+
+func sameComment(a entity.Comment, b entity.Comment) bool {
+	return a.Author == b.Author && a.Action == b.Action && a.Message == b.Message && a.CreatedAt.Equal(b.CreatedAt)
+}
+
+func describeComments(comments []entity.Comment) string {
+	described := make([]string, 0, len(comments))
+	for _, comment := range comments {
+		described = append(described, fmt.Sprintf("%s %s '%s'", comment.Author, comment.Action, comment.Message))
+	}
+	return strings.Join(described, ", ")
+}
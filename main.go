@@ -6,10 +6,13 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
 	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/interfaces/telegram"
-	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/google_sheets"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/service/ingest"
 )
 
 func main() {
@@ -28,18 +31,66 @@ func main() {
 
 	log.Print("\n" + cfg_masked)
 
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(cfg, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "outbox" {
+		runOutbox(cfg, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "revisions" {
+		runRevisions(cfg, os.Args[2:])
+		return
+	}
+
+	log.Print("open state database...")
+
+	boltdb, err := telegram.OpenStateDB(cfg, nil)
+	if err != nil {
+		log.Fatalf("Error open state database: %s", err)
+	}
+
 	log.Print("connect to google sheets...")
 
-	repo, err := google_sheets.New(cfg)
+	repos, err := openRepositories(cfg, boltdb)
 	if err != nil {
-		log.Fatalf("Error init google sheets: %s", err)
+		log.Fatalf("Error init repositories: %s", err)
 	}
 
 	log.Print("connect to google sheets successfull")
 
+	outbox_ctx, outbox_cancel := context.WithCancel(context.Background())
+
+	var outbox_wg sync.WaitGroup
+	for _, sheet_outbox := range repos.outboxes {
+		outbox_wg.Add(1)
+		go func() {
+			defer outbox_wg.Done()
+			sheet_outbox.Run(outbox_ctx)
+		}()
+	}
+
+	ingest_services := make([]*ingest.Service, 0, len(cfg.TelegramSources))
+	for _, source := range cfg.TelegramSources {
+		// annotations are read from the sheet only to reply assignee
+		var annotations repository.Repository
+		if cfg.TelegramReplyAssignee {
+			annotations = repos.sheets[source.GoogleSheetsSheet]
+		}
+
+		ingest_service, err := ingest.New(cfg, source, repos.stores[source.GoogleSheetsSheet], annotations)
+		if err != nil {
+			log.Fatalf("Error init ingest service: %s", err)
+		}
+		ingest_services = append(ingest_services, ingest_service)
+	}
+
 	log.Print("configure telegram client...")
 
-	telegram_client, err := telegram.New(cfg, repo)
+	telegram_client, err := telegram.New(cfg, ingest_services, boltdb)
 	if err != nil {
 		log.Fatalf("Error configure telegram client: %s", err)
 	}
@@ -47,36 +98,60 @@ func main() {
 	log.Print("configure telegram client successfull")
 	log.Print("starting telegram client...")
 
-	err = telegram_client.Run()
-	if err != nil {
-		log.Fatalf("Error start telegram client: %s", err)
-	}
-
-	log.Print("telegram client successfull started")
-	log.Print("press ctrl c to shutdown")
-
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	// signals are handled before Run, it blocks until client is stopped
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	var wg sync.WaitGroup
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-
 		<-ctx.Done()
 
 		log.Print("shutdown telegram client...")
-		telegram_client.Stop()
+		err := telegram_client.Stop()
 		if err != nil {
 			log.Printf("failed shutdown telegram client: %s", err)
 		}
+	}()
+
+	log.Print("press ctrl c to shutdown")
+
+	err = telegram_client.Run()
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Error run telegram client: %s", err)
+	}
+	cancel()
+
+	for _, ingest_service := range ingest_services {
+		log.Printf("not parsed messages from source '%s': %s", ingest_service.Source().Name, ingest_service.Stats())
+	}
+
+	log.Print("stop outbox workers...")
+	outbox_cancel()
+	outbox_wg.Wait()
+	for name, sheet_outbox := range repos.outboxes {
+		pending, dead, err := sheet_outbox.Counts()
+		if err != nil {
+			log.Printf("failed count outbox: %s", err)
+			continue
+		}
+		log.Printf("outbox '%s': %d pending, %d dead", name, pending, dead)
+	}
+
+	// ctx is already cancelled, repositories are closed with own deadline
+	close_ctx, close_cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer close_cancel()
 
-		log.Print("close google sheets connection...")
-		err := repo.Close(ctx)
+	log.Print("close google sheets connection...")
+	for _, repo := range repos.stores {
+		err := repo.Close(close_ctx)
 		if err != nil {
 			log.Printf("failed close google sheets connection: %s", err)
 		}
-	}()
-	wg.Wait()
+	}
+
+	err = boltdb.Close()
+	if err != nil {
+		log.Printf("failed close state database: %s", err)
+	}
+
 	log.Print("exit")
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/interfaces/telegram"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/outbox"
+
+	"go.etcd.io/bbolt"
+)
+
+// runOutbox prints problem events that are not written to google sheets yet
+// and dead letters, "outbox requeue <name> [id...]" moves dead letters of
+// outbox back to pending, every one of them if ids are empty.
+//
+// The database is locked while the service is running, so the service must be
+// stopped first, the running service logs pending and dead counts of outbox on
+// every failed write.
+func runOutbox(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("outbox", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), outboxUsage)
+	}
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) > 0 && args[0] == "requeue" {
+		requeueOutbox(cfg, args[1:])
+		return
+	}
+
+	boltdb := openStoppedStateDB(cfg, true)
+	defer boltdb.Close()
+
+	entries, err := outbox.List(boltdb)
+	if err != nil {
+		log.Fatalf("Error read outbox: %s", err)
+	}
+
+	dead, err := outbox.ListDead(boltdb)
+	if err != nil {
+		log.Fatalf("Error read dead letters: %s", err)
+	}
+
+	for name, outbox_entries := range entries {
+		fmt.Printf("outbox '%s': %d pending, %d dead\n", name, len(outbox_entries), len(dead[name]))
+
+		for _, entry := range outbox_entries {
+			printEntry(&entry)
+		}
+
+		if len(dead[name]) > 0 {
+			fmt.Println("  dead letters, requeue with: outbox requeue " + strconv.Quote(name) + " [id...]")
+		}
+		for _, entry := range dead[name] {
+			printEntry(&entry)
+		}
+	}
+}
+
+const outboxUsage = `usage: outbox
+       outbox requeue <name> [id...]
+
+Prints problem events that are not written to google sheets yet and dead
+letters, requeue moves dead letters of outbox back to pending, every one of
+them if ids are empty.
+
+The service must be stopped first: it locks the state database while it is
+running and both commands fail after a second of waiting for the lock. The
+running service logs pending and dead counts of outbox on every failed write.
+`
+
+func printEntry(entry *outbox.Entry) {
+	fmt.Printf("  #%d %s %s, recorded at %s", entry.ID, entry.Action, describeProblem(&entry.Problem), entry.CreatedAt.Format(time.DateTime))
+	switch {
+	case !entry.DeadAt.IsZero():
+		fmt.Printf(", dead at %s after %d attempts, last error: %s", entry.DeadAt.Format(time.DateTime), entry.Attempts, entry.LastError)
+	case entry.Attempts > 0:
+		fmt.Printf(", attempts %d, next at %s, last error: %s", entry.Attempts, entry.NextAttemptAt.Format(time.DateTime), entry.LastError)
+	}
+	fmt.Println()
+}
+
+func requeueOutbox(cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal("Error requeue dead letters: usage: outbox requeue <name> [id...], the service must be stopped")
+	}
+
+	ids := make([]uint64, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			log.Fatalf("Error requeue dead letters: invalid id '%s'", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	boltdb := openStoppedStateDB(cfg, false)
+	defer boltdb.Close()
+
+	count, err := outbox.Requeue(boltdb, args[0], ids)
+	if err != nil {
+		log.Fatalf("Error requeue dead letters: %s", err)
+	}
+
+	fmt.Printf("outbox '%s': %d dead letters requeued\n", args[0], count)
+}
+
+// openStoppedStateDB opens state database of commands that need the service
+// to be stopped, it fails fast if the database is locked by the service.
+func openStoppedStateDB(cfg *config.Config, read_only bool) *bbolt.DB {
+	boltdb, err := telegram.OpenStateDB(cfg, &bbolt.Options{ReadOnly: read_only, Timeout: time.Second})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		log.Fatal("Error open state database, it is locked by the running service, stop it first")
+	}
+	if err != nil {
+		log.Fatalf("Error open state database: %s", err)
+	}
+	return boltdb
+}
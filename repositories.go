@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/domain/repository"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/fanout"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/google_sheets"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/jsonl"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/local"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/repository/outbox"
+
+	"go.etcd.io/bbolt"
+)
+
+// repositories are repositories of every sheet by sheet name.
+type repositories struct {
+	// stores are local stores that forward problems to sinks
+	stores map[string]repository.Repository
+	// outboxes must be run to deliver problems to google sheets
+	outboxes map[string]*outbox.Outbox
+	// sheets are read directly for operator annotations
+	sheets map[string]repository.Repository
+}
+
+// openRepositories creates repository of every sheet: problems are stored in
+// local store and forwarded to configured sinks, google sheets one is written
+// through outbox.
+func openRepositories(cfg *config.Config, boltdb *bbolt.DB) (*repositories, error) {
+	repos := repositories{
+		stores:   map[string]repository.Repository{},
+		outboxes: map[string]*outbox.Outbox{},
+		sheets:   map[string]repository.Repository{},
+	}
+
+	// audit files are shared by sheets
+	audit_files := map[string]repository.Repository{}
+
+	for _, source := range cfg.TelegramSources {
+		sheet := source.GoogleSheetsSheet
+		if _, ok := repos.stores[sheet]; ok {
+			continue
+		}
+
+		// rotating sheets look up start time of problems in the local store
+		var rotated []interface{ SetStore(repository.Repository) }
+
+		sinks := make([]fanout.Sink, 0, len(cfg.Sinks))
+		for _, sink := range cfg.Sinks {
+			switch sink.Kind {
+			case config.SinkKindGoogleSheets:
+				var sheets_repo repository.Repository
+				if source.GoogleSheetsRotationLayout != "" {
+					rotating, err := google_sheets.NewRotating(context.Background(), cfg, sheet, source.GoogleSheetsSchema, source.GoogleSheetsRotationLayout)
+					if err != nil {
+						return nil, fmt.Errorf("Failed init google sheets: %s", err)
+					}
+					rotated = append(rotated, rotating)
+					sheets_repo = rotating
+				} else {
+					var err error
+					sheets_repo, err = google_sheets.New(context.Background(), cfg, sheet, source.GoogleSheetsSchema)
+					if err != nil {
+						return nil, fmt.Errorf("Failed init google sheets: %s", err)
+					}
+				}
+
+				sheet_outbox, err := outbox.New(boltdb, sheet, sheets_repo, outbox.Options{
+					BatchSize:   cfg.GoogleSheetsBatchSize,
+					BatchWindow: cfg.GoogleSheetsBatchWindow,
+					MaxAttempts: cfg.GoogleSheetsMaxAttempts,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("Failed init outbox: %s", err)
+				}
+				repos.outboxes[sheet] = sheet_outbox
+				repos.sheets[sheet] = sheets_repo
+
+				sinks = append(sinks, fanout.Sink{
+					Name:   fmt.Sprintf("google sheets '%s'", sheet),
+					Policy: fanout.Policy(sink.Policy),
+					Repo:   sheet_outbox,
+				})
+			case config.SinkKindJSONLines:
+				audit_file, ok := audit_files[sink.Path]
+				if !ok {
+					var err error
+					audit_file, err = jsonl.New(sink.Path)
+					if err != nil {
+						return nil, fmt.Errorf("Failed init audit file: %s", err)
+					}
+					audit_files[sink.Path] = audit_file
+				}
+
+				sinks = append(sinks, fanout.Sink{
+					Name:   fmt.Sprintf("audit file '%s'", sink.Path),
+					Policy: fanout.Policy(sink.Policy),
+					Repo:   audit_file,
+				})
+			}
+		}
+
+		sheet_sinks, err := fanout.New(sinks...)
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := local.New(boltdb, sheet, sheet_sinks)
+		if err != nil {
+			return nil, fmt.Errorf("Failed init local store: %s", err)
+		}
+		repos.stores[sheet] = store
+
+		for _, rotating := range rotated {
+			rotating.SetStore(store)
+		}
+	}
+
+	return &repos, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/config"
+	"github.com/pdkonovalov/gk132_spb_tg2gs/internal/interfaces/telegram"
+)
+
+// runRevisions prints saved edits of alert messages with changes they made
+// to problems, the service must be stopped as it locks the database.
+func runRevisions(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("revisions", flag.ExitOnError)
+	chat_id := flags.Int64("chat", 0, "chat id of edited messages, every chat if empty")
+	problem_id := flags.String("problem", "", "problem id of edited messages, every problem if empty")
+	flags.Parse(args)
+
+	boltdb := openStoppedStateDB(cfg, true)
+	defer boltdb.Close()
+
+	revisions, err := telegram.ListRevisions(boltdb, *chat_id, *problem_id)
+	if err != nil {
+		log.Fatalf("Error read revisions: %s", err)
+	}
+
+	for _, rev := range revisions {
+		fmt.Printf("chat %d message %d #%d edited at %s", rev.ChatID, rev.MessageID, rev.ID, rev.EditedAt.Format(time.DateTime))
+		if rev.ProblemID != "" {
+			fmt.Printf(", problem '%s'", rev.ProblemID)
+		}
+		if len(rev.Changes) > 0 {
+			changes := make([]string, 0, len(rev.Changes))
+			for _, change := range rev.Changes {
+				changes = append(changes, fmt.Sprintf("%s '%s' -> '%s'", change.Field, change.Old, change.New))
+			}
+			fmt.Printf(", changes: %s", strings.Join(changes, ", "))
+		}
+		fmt.Printf("\n  %s\n", strings.ReplaceAll(rev.Message, "\n", "\n  "))
+	}
+
+	log.Printf("%d revisions", len(revisions))
+}